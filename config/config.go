@@ -17,8 +17,10 @@ limitations under the License.
 package config
 
 import (
+	"crypto/tls"
 	"flag"
 	"strconv"
+	"time"
 )
 
 //consts exported out of package
@@ -34,6 +36,22 @@ const (
 
 	//Default vSphere port
 	DefaultVSpherePort = 0
+
+	//DefaultDatastoreScanInterval disables the datastore file scan
+	DefaultDatastoreScanInterval = "0s"
+
+	//DefaultScrapeTimeoutOffset is subtracted from the scrape timeout of
+	//Prometheus to leave time for sending the response
+	DefaultScrapeTimeoutOffset = "500ms"
+
+	//DefaultDatastoreScanDelay is the pause between scanning two datastores
+	DefaultDatastoreScanDelay = "30s"
+
+	//DefaultTagRefreshInterval disables the vSphere tags
+	DefaultTagRefreshInterval = "0s"
+
+	//DefaultPerfMaxLevel collects the perf counters of every statistics level
+	DefaultPerfMaxLevel = 4
 )
 
 // Role is role of the target in vSphere.
@@ -44,41 +62,170 @@ const (
 	VSphereRoleEsx            Role = "esx"
 	VSphereRoleDatastore      Role = "datastore"
 	VSphereRoleVirtualMachine Role = "virtualmachine"
+	VSphereRoleLicense        Role = "license"
+)
+
+// Auth is how the exporter logs in to vSphere.
+type Auth string
+
+// The valid options for vSphereAuth.
+const (
+	VSphereAuthPassword    Auth = "password"
+	VSphereAuthCertificate Auth = "certificate"
+	VSphereAuthExtension   Auth = "extension"
+	VSphereAuthToken       Auth = "token"
 )
 
 //Config is the representation of the config
 type Config struct {
+	ConfigFile string
+
+	//fileLines holds the line of each setting of the config file, for
+	//the errors of Validate
+	fileLines map[string]int
+
+	//vcenterFiles holds the vcenters list of the config file until Load
+	//builds VCenters from it
+	vcenterFiles []vcenterFileConfig
+
+	//VCenters holds a config for each entry of the vcenters list of the
+	//config file, empty when the exporter monitors a single vCenter
+	VCenters []*Config
+
 	LogLevel string
 	Debug    bool
 
 	RestPort int
 
+	WebConfigFile string
+
+	ScrapeTimeoutOffset time.Duration
+
 	VSphereHostname string
 	VSpherePort     int
 	VSphereInsecure bool
 	VSphereUser     string
+	VSphereUserFile string
 	VSpherePass     string
+	VSpherePassFile string
 	VSphereType     string
+
+	VSphereCAFile     string
+	VSphereThumbprint string
+
+	VSphereAuth         string
+	VSphereCertFile     string
+	VSphereKeyFile      string
+	VSphereExtensionKey string
+	VSphereTokenFile    string
+
+	//VSphereCertificate and VSphereToken are read from their files on load
+	VSphereCertificate *tls.Certificate
+	VSphereToken       string
+
+	DatastoreScanInterval time.Duration
+	DatastoreScanDelay    time.Duration
+
+	EsxBaselineFile string
+
+	CustomAttributes string
+
+	TagRefreshInterval time.Duration
+	TagCategories      string
+
+	LegacyMetricNames bool
+
+	EsxPerfInterval       int
+	EsxPerfSamples        int
+	DatastorePerf         bool
+	DatastorePerfInterval int
+	DatastorePerfSamples  int
+
+	PerfInclude        string
+	PerfExclude        string
+	PerfMaxLevel       int
+	PerfMaxDeviceLevel int
+
+	EntityInclude string
+	EntityExclude string
+
+	SampleTimestamps bool
+}
+
+//VCenterConfigs returns the config of each vCenter to monitor
+func (cfg *Config) VCenterConfigs() []*Config {
+	if len(cfg.VCenters) == 0 {
+		return []*Config{cfg}
+	}
+	return cfg.VCenters
 }
 
 //AddFlags adds flags to the command line parsing
 func (cfg *Config) AddFlags(fs *flag.FlagSet) {
+	fs.StringVar(&cfg.ConfigFile, "config.file", cfg.ConfigFile, "YAML config file, reloaded on SIGHUP or a POST to /-/reload")
+
 	fs.StringVar(&cfg.LogLevel, "loglevel", cfg.LogLevel, "Set the logging level")
 	fs.BoolVar(&cfg.Debug, "debug", cfg.Debug, "Debug mode")
 
 	fs.IntVar(&cfg.RestPort, "rest.port", cfg.RestPort, "Port to serve up REST endpoint")
+	fs.DurationVar(&cfg.ScrapeTimeoutOffset, "scrape.timeout-offset", cfg.ScrapeTimeoutOffset, "Subtracted from the X-Prometheus-Scrape-Timeout-Seconds header to get the deadline of the vCenter calls of a scrape")
+	fs.StringVar(&cfg.WebConfigFile, "web.config.file", cfg.WebConfigFile, "Prometheus web-config file that enables TLS and authentication on the REST endpoint")
 
 	fs.StringVar(&cfg.VSphereHostname, "vsphere.hostname", cfg.VSphereHostname, "vCenter Server hostname")
 	fs.IntVar(&cfg.VSpherePort, "vsphere.port", cfg.VSpherePort, "vCenter Server port")
 	fs.BoolVar(&cfg.VSphereInsecure, "vsphere.insecure", cfg.VSphereInsecure, "vCenter Server insecure mode")
+	fs.StringVar(&cfg.VSphereCAFile, "vsphere.ca-file", cfg.VSphereCAFile, "PEM bundle of the CAs to verify the vCenter Server certificate with")
+	fs.StringVar(&cfg.VSphereThumbprint, "vsphere.thumbprint", cfg.VSphereThumbprint, "Comma separated SHA-1 or SHA-256 thumbprints the vCenter Server certificate has to match")
 	fs.StringVar(&cfg.VSphereUser, "vsphere.username", cfg.VSphereUser, "vCenter Server Username")
+	fs.StringVar(&cfg.VSphereUserFile, "vsphere.username-file", cfg.VSphereUserFile, "File to read the vCenter Server Username from")
 	fs.StringVar(&cfg.VSpherePass, "vsphere.password", cfg.VSpherePass, "vCenter Server Password")
+	fs.StringVar(&cfg.VSpherePassFile, "vsphere.password-file", cfg.VSpherePassFile, "File to read the vCenter Server Password from, re-read when it changes")
 	fs.StringVar(&cfg.VSphereType, "vsphere.type", cfg.VSphereType, "What type of objects to discover")
+
+	fs.StringVar(&cfg.VSphereAuth, "vsphere.auth", cfg.VSphereAuth, "How to log in to vCenter: password, certificate, extension or token")
+	fs.StringVar(&cfg.VSphereCertFile, "vsphere.cert-file", cfg.VSphereCertFile, "PEM client certificate for certificate or extension auth")
+	fs.StringVar(&cfg.VSphereKeyFile, "vsphere.key-file", cfg.VSphereKeyFile, "PEM private key of the client certificate for certificate or extension auth")
+	fs.StringVar(&cfg.VSphereExtensionKey, "vsphere.extension-key", cfg.VSphereExtensionKey, "Key of the vCenter extension the certificate is registered for")
+	fs.StringVar(&cfg.VSphereTokenFile, "vsphere.token-file", cfg.VSphereTokenFile, "File holding a SAML bearer token for token auth, re-read when it changes")
+
+	fs.DurationVar(&cfg.DatastoreScanInterval, "datastore.scan-interval", cfg.DatastoreScanInterval, "Interval between datastore file scans (0 disables)")
+	fs.DurationVar(&cfg.DatastoreScanDelay, "datastore.scan-delay", cfg.DatastoreScanDelay, "Pause between scanning two datastores")
+
+	fs.StringVar(&cfg.EsxBaselineFile, "esx.baseline-file", cfg.EsxBaselineFile, "JSON file describing the expected host configuration")
+
+	fs.StringVar(&cfg.CustomAttributes, "vsphere.custom-attributes", cfg.CustomAttributes, "Comma separated custom attributes (name or name=label) to add as labels to the info metrics")
+
+	fs.DurationVar(&cfg.TagRefreshInterval, "vsphere.tag-refresh-interval", cfg.TagRefreshInterval, "Interval between refreshes of the vSphere tags (0 disables)")
+	fs.StringVar(&cfg.TagCategories, "vsphere.tag-categories", cfg.TagCategories, "Comma separated tag categories to add as labels to the info metrics")
+
+	fs.BoolVar(&cfg.LegacyMetricNames, "vsphere.legacy-metric-names", cfg.LegacyMetricNames, "Use the <key>_<name> metric names of earlier releases")
+
+	fs.IntVar(&cfg.EsxPerfInterval, "esx.perf-interval", cfg.EsxPerfInterval, "Perf interval ID in seconds to query for hosts (0 detects it)")
+	fs.IntVar(&cfg.EsxPerfSamples, "esx.perf-samples", cfg.EsxPerfSamples, "Number of perf samples of hosts to aggregate per scrape into min, max and avg")
+	fs.BoolVar(&cfg.DatastorePerf, "datastore.perf", cfg.DatastorePerf, "Collect the perf counters of datastores")
+	fs.IntVar(&cfg.DatastorePerfInterval, "datastore.perf-interval", cfg.DatastorePerfInterval, "Perf interval ID in seconds to query for datastores (0 detects it)")
+	fs.IntVar(&cfg.DatastorePerfSamples, "datastore.perf-samples", cfg.DatastorePerfSamples, "Number of perf samples of datastores to aggregate per scrape into min, max and avg")
+
+	fs.StringVar(&cfg.PerfInclude, "perf.include", cfg.PerfInclude, "Comma separated globs or /regexps/ of the group.name.rollup perf counters to collect")
+	fs.StringVar(&cfg.PerfExclude, "perf.exclude", cfg.PerfExclude, "Comma separated globs or /regexps/ of the group.name.rollup perf counters to skip")
+	fs.IntVar(&cfg.PerfMaxLevel, "perf.max-level", cfg.PerfMaxLevel, "Highest statistics level of the perf counters to collect")
+	fs.IntVar(&cfg.PerfMaxDeviceLevel, "perf.max-device-level", cfg.PerfMaxDeviceLevel, "Highest per device statistics level to collect instances for (0 collects aggregates only)")
+
+	fs.StringVar(&cfg.EntityInclude, "vsphere.entity-include", cfg.EntityInclude, "Comma separated kind:pattern rules of the entities to serve (path, name, cluster, folder, power, tag)")
+	fs.StringVar(&cfg.EntityExclude, "vsphere.entity-exclude", cfg.EntityExclude, "Comma separated kind:pattern rules of the entities not to serve (path, name, cluster, folder, power, tag)")
+
+	fs.BoolVar(&cfg.SampleTimestamps, "perf.sample-timestamps", cfg.SampleTimestamps, "Expose perf counters with the time of the vSphere sample instead of the scrape time")
 }
 
 //NewConfig creates a new Config object
 func NewConfig() *Config {
 	return &Config{
+		ConfigFile: env("CONFIG_FILE", ""),
+
+		WebConfigFile: env("WEB_CONFIG_FILE", ""),
+
+		ScrapeTimeoutOffset: envDuration("SCRAPE_TIMEOUT_OFFSET", DefaultScrapeTimeoutOffset),
+
 		LogLevel:        env("LOG_LEVEL", "info"),
 		Debug:           envBool("DEBUG", "false"),
 		RestPort:        envInt("REST_PORT", strconv.Itoa(DefaultRestPort)),
@@ -86,7 +233,46 @@ func NewConfig() *Config {
 		VSpherePort:     envInt("VSPHERE_PORT", strconv.Itoa(DefaultVSpherePort)),
 		VSphereInsecure: envBool("VSPHERE_INSECURE", "false"),
 		VSphereUser:     env("VSPHERE_USERNAME", ""),
+		VSphereUserFile: env("VSPHERE_USERNAME_FILE", ""),
 		VSpherePass:     env("VSPHERE_PASSWORD", ""),
+		VSpherePassFile: env("VSPHERE_PASSWORD_FILE", ""),
 		VSphereType:     env("VSPHERE_TYPE", ""),
+
+		VSphereCAFile:     env("VSPHERE_CA_FILE", ""),
+		VSphereThumbprint: env("VSPHERE_THUMBPRINT", ""),
+
+		VSphereAuth:         env("VSPHERE_AUTH", string(VSphereAuthPassword)),
+		VSphereCertFile:     env("VSPHERE_CERT_FILE", ""),
+		VSphereKeyFile:      env("VSPHERE_KEY_FILE", ""),
+		VSphereExtensionKey: env("VSPHERE_EXTENSION_KEY", ""),
+		VSphereTokenFile:    env("VSPHERE_TOKEN_FILE", ""),
+
+		DatastoreScanInterval: envDuration("DATASTORE_SCAN_INTERVAL", DefaultDatastoreScanInterval),
+		DatastoreScanDelay:    envDuration("DATASTORE_SCAN_DELAY", DefaultDatastoreScanDelay),
+
+		EsxBaselineFile: env("ESX_BASELINE_FILE", ""),
+
+		CustomAttributes: env("CUSTOM_ATTRIBUTES", ""),
+
+		TagRefreshInterval: envDuration("TAG_REFRESH_INTERVAL", DefaultTagRefreshInterval),
+		TagCategories:      env("TAG_CATEGORIES", ""),
+
+		LegacyMetricNames: envBool("LEGACY_METRIC_NAMES", "false"),
+
+		EsxPerfInterval:       envInt("ESX_PERF_INTERVAL", "0"),
+		EsxPerfSamples:        envInt("ESX_PERF_SAMPLES", "1"),
+		DatastorePerf:         envBool("DATASTORE_PERF", "false"),
+		DatastorePerfInterval: envInt("DATASTORE_PERF_INTERVAL", "0"),
+		DatastorePerfSamples:  envInt("DATASTORE_PERF_SAMPLES", "1"),
+
+		PerfInclude:        env("PERF_INCLUDE", ""),
+		PerfExclude:        env("PERF_EXCLUDE", ""),
+		PerfMaxLevel:       envInt("PERF_MAX_LEVEL", strconv.Itoa(DefaultPerfMaxLevel)),
+		PerfMaxDeviceLevel: envInt("PERF_MAX_DEVICE_LEVEL", "0"),
+
+		EntityInclude: env("ENTITY_INCLUDE", ""),
+		EntityExclude: env("ENTITY_EXCLUDE", ""),
+
+		SampleTimestamps: envBool("SAMPLE_TIMESTAMPS", "false"),
 	}
 }
@@ -0,0 +1,488 @@
+/*
+Copyright (c) 2018 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+//fileConfig is the layout of the YAML config file. Settings left out of the
+//file keep the value of the environment.
+type fileConfig struct {
+	LogLevel *string `yaml:"log_level"`
+	Debug    *bool   `yaml:"debug"`
+	RestPort *int    `yaml:"rest_port"`
+
+	WebConfigFile *string `yaml:"web_config_file"`
+
+	ScrapeTimeoutOffset *time.Duration `yaml:"scrape_timeout_offset"`
+
+	VSphere   vsphereFileConfig   `yaml:"vsphere"`
+	VCenters  []vcenterFileConfig `yaml:"vcenters"`
+	Esx       esxFileConfig       `yaml:"esx"`
+	Datastore datastoreFileConfig `yaml:"datastore"`
+	Perf      perfFileConfig      `yaml:"perf"`
+}
+
+type vsphereFileConfig struct {
+	Hostname     *string `yaml:"hostname"`
+	Port         *int    `yaml:"port"`
+	Insecure     *bool   `yaml:"insecure"`
+	CAFile       *string `yaml:"ca_file"`
+	Username     *string `yaml:"username"`
+	UsernameFile *string `yaml:"username_file"`
+	Password     *string `yaml:"password"`
+	PasswordFile *string `yaml:"password_file"`
+	Type         *string `yaml:"type"`
+
+	Auth         *string `yaml:"auth"`
+	CertFile     *string `yaml:"cert_file"`
+	KeyFile      *string `yaml:"key_file"`
+	ExtensionKey *string `yaml:"extension_key"`
+	TokenFile    *string `yaml:"token_file"`
+
+	Thumbprints        []string       `yaml:"thumbprints"`
+	CustomAttributes   []string       `yaml:"custom_attributes"`
+	TagRefreshInterval *time.Duration `yaml:"tag_refresh_interval"`
+	TagCategories      []string       `yaml:"tag_categories"`
+	LegacyMetricNames  *bool          `yaml:"legacy_metric_names"`
+	EntityInclude      []string       `yaml:"entity_include"`
+	EntityExclude      []string       `yaml:"entity_exclude"`
+}
+
+//vcenterFileConfig is an entry of the vcenters list. Its connection and
+//credential settings replace the ones of the vsphere section.
+type vcenterFileConfig struct {
+	Hostname     *string  `yaml:"hostname"`
+	Port         *int     `yaml:"port"`
+	Insecure     *bool    `yaml:"insecure"`
+	CAFile       *string  `yaml:"ca_file"`
+	Thumbprints  []string `yaml:"thumbprints"`
+	Username     *string  `yaml:"username"`
+	UsernameFile *string  `yaml:"username_file"`
+	Password     *string  `yaml:"password"`
+	PasswordFile *string  `yaml:"password_file"`
+
+	Auth         *string `yaml:"auth"`
+	CertFile     *string `yaml:"cert_file"`
+	KeyFile      *string `yaml:"key_file"`
+	ExtensionKey *string `yaml:"extension_key"`
+	TokenFile    *string `yaml:"token_file"`
+}
+
+type esxFileConfig struct {
+	BaselineFile *string `yaml:"baseline_file"`
+	PerfInterval *int    `yaml:"perf_interval"`
+	PerfSamples  *int    `yaml:"perf_samples"`
+}
+
+type datastoreFileConfig struct {
+	ScanInterval *time.Duration `yaml:"scan_interval"`
+	ScanDelay    *time.Duration `yaml:"scan_delay"`
+	Perf         *bool          `yaml:"perf"`
+	PerfInterval *int           `yaml:"perf_interval"`
+	PerfSamples  *int           `yaml:"perf_samples"`
+}
+
+//flagKeys names the config file settings of the flags that are not named
+//like the setting with dashes
+var flagKeys = map[string]string{
+	"loglevel":              "log_level",
+	"rest.port":             "rest_port",
+	"scrape.timeout-offset": "scrape_timeout_offset",
+	"web.config.file":       "web_config_file",
+	"vsphere.thumbprint":    "vsphere.thumbprints",
+}
+
+type perfFileConfig struct {
+	Include          []string `yaml:"include"`
+	Exclude          []string `yaml:"exclude"`
+	MaxLevel         *int     `yaml:"max_level"`
+	MaxDeviceLevel   *int     `yaml:"max_device_level"`
+	SampleTimestamps *bool    `yaml:"sample_timestamps"`
+}
+
+//Load builds the config from the environment, the config file and the
+//command line, in increasing order of precedence, and reads the secret files.
+//The secrets are registered for redaction.
+func Load(args []string) (*Config, error) {
+	cfg := NewConfig()
+	fs := flag.NewFlagSet("vsphere-proxy", flag.ContinueOnError)
+	cfg.AddFlags(fs)
+
+	err := fs.Parse(args)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.ConfigFile != "" {
+		err = cfg.LoadFile(cfg.ConfigFile)
+		if err != nil {
+			return nil, err
+		}
+
+		// Flags given on the command line win over the file
+		err = fs.Parse(args)
+		if err != nil {
+			return nil, err
+		}
+		fs.Visit(func(f *flag.Flag) {
+			key, ok := flagKeys[f.Name]
+			if !ok {
+				key = strings.Replace(f.Name, "-", "_", -1)
+			}
+			delete(cfg.fileLines, key)
+		})
+	}
+
+	err = cfg.readSecretFiles()
+	if err != nil {
+		return nil, err
+	}
+	AddSecrets(cfg.VSpherePass, cfg.VSphereToken)
+
+	err = cfg.loadVCenters()
+	if err != nil {
+		return nil, err
+	}
+
+	err = cfg.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+//LoadFile applies the settings of a YAML config file. Unknown keys and values
+//of the wrong type are rejected with the line they are on, and the lines are
+//kept for the errors of Validate.
+func (cfg *Config) LoadFile(file string) error {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	var fileCfg fileConfig
+	err = yaml.UnmarshalStrict(data, &fileCfg)
+	if err != nil {
+		return fmt.Errorf("invalid config file %s: %v", file, err)
+	}
+
+	fileCfg.apply(cfg)
+	cfg.vcenterFiles = fileCfg.VCenters
+	cfg.fileLines = settingLines(data)
+
+	return nil
+}
+
+//loadVCenters builds the config of each entry of the vcenters list on top
+//of the loaded config and reads its secret files
+func (cfg *Config) loadVCenters() error {
+	cfg.VCenters = nil
+	for i, entry := range cfg.vcenterFiles {
+		vcenter := *cfg
+		vcenter.fileLines = nil
+		vcenter.vcenterFiles = nil
+		vcenter.clearFileSecrets()
+		entry.apply(&vcenter)
+
+		err := vcenter.readSecretFiles()
+		if err != nil {
+			return fmt.Errorf("vcenters[%d]: %v", i, err)
+		}
+		AddSecrets(vcenter.VSpherePass, vcenter.VSphereToken)
+
+		cfg.VCenters = append(cfg.VCenters, &vcenter)
+	}
+
+	return nil
+}
+
+//settingLines maps the settings of a YAML config file, e.g. vsphere.hostname,
+//to the line they are on. The config file has two levels at most.
+func settingLines(data []byte) map[string]int {
+	lines := make(map[string]int)
+	section := ""
+	for i, line := range strings.Split(string(data), "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") || strings.HasPrefix(trimmed, "-") {
+			continue
+		}
+		colon := strings.Index(trimmed, ":")
+		if colon <= 0 {
+			continue
+		}
+
+		key := strings.Trim(trimmed[:colon], `"'`)
+		if trimmed == line {
+			section = key
+			lines[key] = i + 1
+		} else if section != "" {
+			lines[section+"."+key] = i + 1
+		}
+	}
+
+	return lines
+}
+
+//locate prefixes an error of Validate with the place of its setting in the
+//config file, when the setting comes from there
+func (cfg *Config) locate(err string) string {
+	colon := strings.Index(err, ":")
+	if colon <= 0 {
+		return err
+	}
+
+	line, ok := cfg.fileLines[err[:colon]]
+	if !ok {
+		return err
+	}
+	return fmt.Sprintf("%s:%d: %s", cfg.ConfigFile, line, err)
+}
+
+func (f *fileConfig) apply(cfg *Config) {
+	setString(&cfg.LogLevel, f.LogLevel)
+	setBool(&cfg.Debug, f.Debug)
+	setInt(&cfg.RestPort, f.RestPort)
+	setString(&cfg.WebConfigFile, f.WebConfigFile)
+	setDuration(&cfg.ScrapeTimeoutOffset, f.ScrapeTimeoutOffset)
+
+	setString(&cfg.VSphereHostname, f.VSphere.Hostname)
+	setInt(&cfg.VSpherePort, f.VSphere.Port)
+	setBool(&cfg.VSphereInsecure, f.VSphere.Insecure)
+	setString(&cfg.VSphereCAFile, f.VSphere.CAFile)
+	setList(&cfg.VSphereThumbprint, f.VSphere.Thumbprints)
+	setString(&cfg.VSphereUser, f.VSphere.Username)
+	setString(&cfg.VSphereUserFile, f.VSphere.UsernameFile)
+	setString(&cfg.VSpherePass, f.VSphere.Password)
+	setString(&cfg.VSpherePassFile, f.VSphere.PasswordFile)
+	setString(&cfg.VSphereType, f.VSphere.Type)
+	setString(&cfg.VSphereAuth, f.VSphere.Auth)
+	setString(&cfg.VSphereCertFile, f.VSphere.CertFile)
+	setString(&cfg.VSphereKeyFile, f.VSphere.KeyFile)
+	setString(&cfg.VSphereExtensionKey, f.VSphere.ExtensionKey)
+	setString(&cfg.VSphereTokenFile, f.VSphere.TokenFile)
+	setList(&cfg.CustomAttributes, f.VSphere.CustomAttributes)
+	setDuration(&cfg.TagRefreshInterval, f.VSphere.TagRefreshInterval)
+	setList(&cfg.TagCategories, f.VSphere.TagCategories)
+	setBool(&cfg.LegacyMetricNames, f.VSphere.LegacyMetricNames)
+	setList(&cfg.EntityInclude, f.VSphere.EntityInclude)
+	setList(&cfg.EntityExclude, f.VSphere.EntityExclude)
+
+	setString(&cfg.EsxBaselineFile, f.Esx.BaselineFile)
+	setInt(&cfg.EsxPerfInterval, f.Esx.PerfInterval)
+	setInt(&cfg.EsxPerfSamples, f.Esx.PerfSamples)
+
+	setDuration(&cfg.DatastoreScanInterval, f.Datastore.ScanInterval)
+	setDuration(&cfg.DatastoreScanDelay, f.Datastore.ScanDelay)
+	setBool(&cfg.DatastorePerf, f.Datastore.Perf)
+	setInt(&cfg.DatastorePerfInterval, f.Datastore.PerfInterval)
+	setInt(&cfg.DatastorePerfSamples, f.Datastore.PerfSamples)
+
+	setList(&cfg.PerfInclude, f.Perf.Include)
+	setList(&cfg.PerfExclude, f.Perf.Exclude)
+	setInt(&cfg.PerfMaxLevel, f.Perf.MaxLevel)
+	setInt(&cfg.PerfMaxDeviceLevel, f.Perf.MaxDeviceLevel)
+	setBool(&cfg.SampleTimestamps, f.Perf.SampleTimestamps)
+}
+
+func (v *vcenterFileConfig) apply(cfg *Config) {
+	// A value or a file of the entry replaces both of the vsphere section
+	if v.Username != nil || v.UsernameFile != nil {
+		cfg.VSphereUser = ""
+		cfg.VSphereUserFile = ""
+	}
+	if v.Password != nil || v.PasswordFile != nil {
+		cfg.VSpherePass = ""
+		cfg.VSpherePassFile = ""
+	}
+
+	setString(&cfg.VSphereHostname, v.Hostname)
+	setInt(&cfg.VSpherePort, v.Port)
+	setBool(&cfg.VSphereInsecure, v.Insecure)
+	setString(&cfg.VSphereCAFile, v.CAFile)
+	setList(&cfg.VSphereThumbprint, v.Thumbprints)
+	setString(&cfg.VSphereUser, v.Username)
+	setString(&cfg.VSphereUserFile, v.UsernameFile)
+	setString(&cfg.VSpherePass, v.Password)
+	setString(&cfg.VSpherePassFile, v.PasswordFile)
+	setString(&cfg.VSphereAuth, v.Auth)
+	setString(&cfg.VSphereCertFile, v.CertFile)
+	setString(&cfg.VSphereKeyFile, v.KeyFile)
+	setString(&cfg.VSphereExtensionKey, v.ExtensionKey)
+	setString(&cfg.VSphereTokenFile, v.TokenFile)
+}
+
+func setString(dst *string, value *string) {
+	if value != nil {
+		*dst = *value
+	}
+}
+
+func setInt(dst *int, value *int) {
+	if value != nil {
+		*dst = *value
+	}
+}
+
+func setBool(dst *bool, value *bool) {
+	if value != nil {
+		*dst = *value
+	}
+}
+
+func setDuration(dst *time.Duration, value *time.Duration) {
+	if value != nil {
+		*dst = *value
+	}
+}
+
+//setList joins a YAML list into the comma separated form of the environment,
+//an empty list clears the setting
+func setList(dst *string, values []string) {
+	if values != nil {
+		*dst = strings.Join(values, ",")
+	}
+}
+
+//Validate checks the settings that would otherwise only fail once the
+//metrics are registered or scraped
+func (cfg *Config) Validate() error {
+	errs := cfg.validate()
+
+	// The entries share the settings outside of the connection, their
+	// errors are reported once
+	shared := make(map[string]bool)
+	for _, err := range errs {
+		shared[err] = true
+	}
+
+	hostnames := make(map[string]bool)
+	for i, vcenter := range cfg.VCenters {
+		entry := fmt.Sprintf("vcenters[%d]", i)
+		if vcenter.VSphereHostname == "" {
+			errs = append(errs, entry+": needs a hostname")
+		} else if hostnames[vcenter.VSphereHostname] {
+			errs = append(errs, fmt.Sprintf("%s: %s is listed twice", entry, vcenter.VSphereHostname))
+		}
+		hostnames[vcenter.VSphereHostname] = true
+
+		for _, err := range vcenter.validate() {
+			if !shared[err] {
+				errs = append(errs, entry+": "+err)
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		for i, err := range errs {
+			errs[i] = cfg.locate(err)
+		}
+		return fmt.Errorf("invalid config:\n  %s", strings.Join(errs, "\n  "))
+	}
+
+	return nil
+}
+
+//validate returns the errors of the settings of a single vCenter
+func (cfg *Config) validate() []string {
+	var errs []string
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Sprintf(format, args...))
+		}
+	}
+
+	switch Role(cfg.VSphereType) {
+	case VSphereRoleEsx, VSphereRoleDatastore, VSphereRoleVirtualMachine, VSphereRoleLicense:
+	default:
+		errs = append(errs, fmt.Sprintf("vsphere.type: unknown role %q", cfg.VSphereType))
+	}
+
+	switch Auth(cfg.VSphereAuth) {
+	case VSphereAuthPassword:
+	case VSphereAuthCertificate:
+		check(cfg.VSphereCertFile != "" && cfg.VSphereKeyFile != "", "vsphere.auth: certificate needs vsphere.cert_file and vsphere.key_file")
+	case VSphereAuthExtension:
+		check(cfg.VSphereCertFile != "" && cfg.VSphereKeyFile != "", "vsphere.auth: extension needs vsphere.cert_file and vsphere.key_file")
+		check(cfg.VSphereExtensionKey != "", "vsphere.auth: extension needs vsphere.extension_key")
+	case VSphereAuthToken:
+		check(cfg.VSphereTokenFile != "", "vsphere.auth: token needs vsphere.token_file")
+	default:
+		errs = append(errs, fmt.Sprintf("vsphere.auth: unknown method %q", cfg.VSphereAuth))
+	}
+
+	if cfg.VSphereInsecure {
+		check(cfg.VSphereCAFile == "" && cfg.VSphereThumbprint == "", "vsphere.insecure: turns off the verification vsphere.ca_file and vsphere.thumbprints ask for")
+	}
+	if _, err := ParseThumbprints(cfg.VSphereThumbprint); err != nil {
+		errs = append(errs, fmt.Sprintf("vsphere.thumbprints: %v", err))
+	}
+
+	check(cfg.RestPort > 0 && cfg.RestPort <= 65535, "rest_port: %d is not a valid port", cfg.RestPort)
+	check(cfg.VSpherePort >= 0 && cfg.VSpherePort <= 65535, "vsphere.port: %d is not a valid port", cfg.VSpherePort)
+	check(cfg.ScrapeTimeoutOffset >= 0, "scrape_timeout_offset: must not be negative")
+	check(cfg.TagRefreshInterval >= 0, "vsphere.tag_refresh_interval: must not be negative")
+	check(cfg.EsxPerfInterval >= 0, "esx.perf_interval: must not be negative")
+	check(cfg.EsxPerfSamples >= 1, "esx.perf_samples: must be at least 1")
+	check(cfg.DatastoreScanInterval >= 0, "datastore.scan_interval: must not be negative")
+	check(cfg.DatastoreScanDelay >= 0, "datastore.scan_delay: must not be negative")
+	check(cfg.DatastorePerfInterval >= 0, "datastore.perf_interval: must not be negative")
+	check(cfg.DatastorePerfSamples >= 1, "datastore.perf_samples: must be at least 1")
+	check(cfg.PerfMaxLevel >= 1 && cfg.PerfMaxLevel <= 4, "perf.max_level: %d is not between 1 and 4", cfg.PerfMaxLevel)
+	check(cfg.PerfMaxDeviceLevel >= 0 && cfg.PerfMaxDeviceLevel <= 4, "perf.max_device_level: %d is not between 0 and 4", cfg.PerfMaxDeviceLevel)
+
+	return errs
+}
+
+//ParseThumbprints splits comma separated certificate thumbprints and brings
+//them into the upper case, colon separated form vSphere shows. SHA-1 and
+//SHA-256 thumbprints are told apart by their length.
+func ParseThumbprints(value string) ([]string, error) {
+	var thumbprints []string
+	for _, thumbprint := range strings.Split(value, ",") {
+		hex := strings.ToUpper(strings.Replace(strings.TrimSpace(thumbprint), ":", "", -1))
+		if hex == "" {
+			continue
+		}
+		if len(hex) != 40 && len(hex) != 64 {
+			return nil, fmt.Errorf("%q is neither a SHA-1 nor a SHA-256 thumbprint", thumbprint)
+		}
+
+		var pairs []string
+		for i := 0; i < len(hex); i += 2 {
+			if !isHex(hex[i]) || !isHex(hex[i+1]) {
+				return nil, fmt.Errorf("%q is not hexadecimal", thumbprint)
+			}
+			pairs = append(pairs, hex[i:i+2])
+		}
+		thumbprints = append(thumbprints, strings.Join(pairs, ":"))
+	}
+
+	return thumbprints, nil
+}
+
+func isHex(b byte) bool {
+	return (b >= '0' && b <= '9') || (b >= 'A' && b <= 'F')
+}
@@ -0,0 +1,255 @@
+/*
+Copyright (c) 2018 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	assert "github.com/stretchr/testify/assert"
+)
+
+func writeConfigFile(t *testing.T, content string) string {
+	dir, err := ioutil.TempDir("", "config")
+	assert.NoError(t, err)
+
+	file := filepath.Join(dir, "config.yml")
+	assert.NoError(t, ioutil.WriteFile(file, []byte(content), 0600))
+	return file
+}
+
+func TestLoadFile(t *testing.T) {
+	file := writeConfigFile(t, `
+log_level: debug
+vsphere:
+  hostname: vcenter.example.com
+  type: esx
+  tag_refresh_interval: 10m
+  entity_include:
+    - cluster:prod-*
+    - tag:env/prod
+esx:
+  perf_samples: 3
+perf:
+  exclude: []
+  max_level: 2
+`)
+	defer os.RemoveAll(filepath.Dir(file))
+
+	cfg := NewConfig()
+	cfg.PerfExclude = "disk.*"
+	err := cfg.LoadFile(file)
+	assert.NoError(t, err)
+
+	assert.Equal(t, "debug", cfg.LogLevel)
+	assert.Equal(t, "vcenter.example.com", cfg.VSphereHostname)
+	assert.Equal(t, "esx", cfg.VSphereType)
+	assert.Equal(t, 10*time.Minute, cfg.TagRefreshInterval)
+	assert.Equal(t, "cluster:prod-*,tag:env/prod", cfg.EntityInclude)
+	assert.Equal(t, 3, cfg.EsxPerfSamples)
+	assert.Equal(t, "", cfg.PerfExclude)
+	assert.Equal(t, 2, cfg.PerfMaxLevel)
+
+	// Settings left out keep their value
+	assert.Equal(t, DefaultRestPort, cfg.RestPort)
+	assert.Equal(t, 1, cfg.DatastorePerfSamples)
+}
+
+func TestLoadFileStrict(t *testing.T) {
+	file := writeConfigFile(t, `
+vsphere:
+  hostname: vcenter.example.com
+  hostnmae: typo
+esx:
+  perf_samples: many
+`)
+	defer os.RemoveAll(filepath.Dir(file))
+
+	err := NewConfig().LoadFile(file)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "line 4: field hostnmae not found")
+	assert.Contains(t, err.Error(), "line 6: cannot unmarshal !!str `many`")
+}
+
+func TestLoad(t *testing.T) {
+	file := writeConfigFile(t, `
+vsphere:
+  type: datastore
+  port: 8443
+perf:
+  max_level: 3
+`)
+	defer os.RemoveAll(filepath.Dir(file))
+
+	// The command line wins over the file
+	cfg, err := Load([]string{"-config.file", file, "-perf.max-level", "1"})
+	assert.NoError(t, err)
+	assert.Equal(t, "datastore", cfg.VSphereType)
+	assert.Equal(t, 8443, cfg.VSpherePort)
+	assert.Equal(t, 1, cfg.PerfMaxLevel)
+
+	_, err = Load([]string{"-config.file", file, "-perf.max-level", "7", "-esx.perf-samples", "0"})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "\n  perf.max_level: 7 is not between 1 and 4")
+	assert.Contains(t, err.Error(), "esx.perf_samples: must be at least 1")
+}
+
+func TestLoadValidateLines(t *testing.T) {
+	file := writeConfigFile(t, `
+# monitoring of the lab
+rest_port: 0
+vsphere:
+  type: cluster
+  "port": 8443
+perf:
+  include:
+    - cpu.*
+  max_level: 9
+`)
+	defer os.RemoveAll(filepath.Dir(file))
+
+	_, err := Load([]string{"-config.file", file})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), file+`:3: rest_port: 0 is not a valid port`)
+	assert.Contains(t, err.Error(), file+`:5: vsphere.type: unknown role "cluster"`)
+	assert.Contains(t, err.Error(), file+`:10: perf.max_level: 9 is not between 1 and 4`)
+
+	// A setting overridden on the command line is not blamed on the file
+	_, err = Load([]string{"-config.file", file, "-rest.port", "-1"})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "\n  rest_port: -1 is not a valid port")
+}
+
+func TestLoadVCenters(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	password := filepath.Join(dir, "password")
+	assert.NoError(t, ioutil.WriteFile(password, []byte("shared-secret\n"), 0600))
+	file := filepath.Join(dir, "config.yml")
+	assert.NoError(t, ioutil.WriteFile(file, []byte(`
+vsphere:
+  type: esx
+  username: monitoring
+  password_file: `+password+`
+vcenters:
+  - hostname: vc1.example.com
+  - hostname: vc2.example.com
+    port: 8443
+    password: other-secret
+`), 0600))
+
+	cfg, err := Load([]string{"-config.file", file})
+	assert.NoError(t, err)
+	if !assert.Len(t, cfg.VCenters, 2) {
+		return
+	}
+	assert.Equal(t, cfg.VCenters, cfg.VCenterConfigs())
+
+	// The entries share the settings of the vsphere section they leave out
+	vc1, vc2 := cfg.VCenters[0], cfg.VCenters[1]
+	assert.Equal(t, "vc1.example.com", vc1.VSphereHostname)
+	assert.Equal(t, "esx", vc1.VSphereType)
+	assert.Equal(t, "monitoring", vc1.VSphereUser)
+	assert.Equal(t, "shared-secret", vc1.VSpherePass)
+	assert.Equal(t, "vc2.example.com", vc2.VSphereHostname)
+	assert.Equal(t, 8443, vc2.VSpherePort)
+	assert.Equal(t, "monitoring", vc2.VSphereUser)
+	assert.Equal(t, "other-secret", vc2.VSpherePass)
+	assert.Equal(t, "", vc2.VSpherePassFile)
+	assert.Equal(t, []string{password}, cfg.SecretFiles())
+
+	// A rotation reads the secret files of every entry again
+	assert.NoError(t, ioutil.WriteFile(password, []byte("rotated-secret\n"), 0600))
+	rotated, err := cfg.RereadSecretFiles()
+	assert.NoError(t, err)
+	assert.Equal(t, "rotated-secret", rotated.VCenters[0].VSpherePass)
+	assert.Equal(t, "other-secret", rotated.VCenters[1].VSpherePass)
+	assert.Equal(t, "shared-secret", vc1.VSpherePass)
+
+	// A single vCenter has no entries
+	single := NewConfig()
+	assert.Equal(t, []*Config{single}, single.VCenterConfigs())
+}
+
+func TestValidateVCenters(t *testing.T) {
+	file := writeConfigFile(t, `
+vsphere:
+  type: datastore
+perf:
+  max_level: 9
+vcenters:
+  - hostname: vc1.example.com
+  - hostname: vc1.example.com
+    auth: token
+  - port: 443
+`)
+	defer os.RemoveAll(filepath.Dir(file))
+
+	_, err := Load([]string{"-config.file", file})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "\n  vcenters[1]: vc1.example.com is listed twice")
+	assert.Contains(t, err.Error(), "\n  vcenters[1]: vsphere.auth: token needs vsphere.token_file")
+	assert.Contains(t, err.Error(), "\n  vcenters[2]: needs a hostname")
+
+	// The settings the entries share are reported once
+	assert.Equal(t, 1, strings.Count(err.Error(), "perf.max_level"))
+}
+
+func TestValidate(t *testing.T) {
+	cfg := NewConfig()
+	cfg.VSphereType = "cluster"
+	err := cfg.Validate()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), `vsphere.type: unknown role "cluster"`)
+
+	cfg.VSphereType = string(VSphereRoleVirtualMachine)
+	assert.NoError(t, cfg.Validate())
+}
+
+func TestParseThumbprints(t *testing.T) {
+	sha1 := "0123456789abcdef0123456789abcdef01234567"
+	sha256 := "01:23:45:67:89:AB:CD:EF:01:23:45:67:89:AB:CD:EF:01:23:45:67:89:AB:CD:EF:01:23:45:67:89:AB:CD:EF"
+
+	thumbprints, err := ParseThumbprints(sha1 + ", " + sha256)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"01:23:45:67:89:AB:CD:EF:01:23:45:67:89:AB:CD:EF:01:23:45:67",
+		sha256,
+	}, thumbprints)
+
+	thumbprints, err = ParseThumbprints("")
+	assert.NoError(t, err)
+	assert.Empty(t, thumbprints)
+
+	_, err = ParseThumbprints("01:23")
+	assert.Error(t, err)
+
+	_, err = ParseThumbprints("zz23456789abcdef0123456789abcdef01234567")
+	assert.Error(t, err)
+
+	cfg := NewConfig()
+	cfg.VSphereInsecure = true
+	cfg.VSphereThumbprint = sha1
+	err = cfg.Validate()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "vsphere.insecure")
+}
@@ -0,0 +1,261 @@
+/*
+Copyright (c) 2018 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+//SecretPollInterval is how often the secret files are checked for rotation
+const SecretPollInterval = 30 * time.Second
+
+//redacted replaces secret values in logs and errors
+const redacted = "<redacted>"
+
+//minSecretLength keeps short values out of the redaction, a one letter
+//password would otherwise scrub that letter from every log line
+const minSecretLength = 4
+
+var (
+	secrets      []string
+	secretsMutex sync.RWMutex
+)
+
+//readSecretFiles replaces the secrets with the content of their files
+func (cfg *Config) readSecretFiles() error {
+	if cfg.VSphereUserFile != "" && cfg.VSphereUser != "" {
+		return fmt.Errorf("vsphere.username and vsphere.username_file are mutually exclusive")
+	}
+	if cfg.VSpherePassFile != "" && cfg.VSpherePass != "" {
+		return fmt.Errorf("vsphere.password and vsphere.password_file are mutually exclusive")
+	}
+
+	if cfg.VSphereUserFile != "" {
+		value, err := readSecretFile(cfg.VSphereUserFile)
+		if err != nil {
+			return err
+		}
+		cfg.VSphereUser = value
+	}
+
+	if cfg.VSpherePassFile != "" {
+		value, err := readSecretFile(cfg.VSpherePassFile)
+		if err != nil {
+			return err
+		}
+		cfg.VSpherePass = value
+	}
+
+	if cfg.VSphereTokenFile != "" {
+		value, err := readSecretFile(cfg.VSphereTokenFile)
+		if err != nil {
+			return err
+		}
+		cfg.VSphereToken = value
+	}
+
+	if cfg.VSphereCertFile != "" && cfg.VSphereKeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.VSphereCertFile, cfg.VSphereKeyFile)
+		if err != nil {
+			return fmt.Errorf("unable to load the vCenter client certificate: %v", err)
+		}
+		cfg.VSphereCertificate = &cert
+	}
+
+	return nil
+}
+
+//RereadSecretFiles returns a copy of the config with the secrets read again
+//from their files. The other settings stay as they are, so a rotation does
+//not pick up edits of the config file.
+func (cfg *Config) RereadSecretFiles() (*Config, error) {
+	next := *cfg
+	next.clearFileSecrets()
+
+	err := next.readSecretFiles()
+	if err != nil {
+		return nil, err
+	}
+	AddSecrets(next.VSpherePass, next.VSphereToken)
+
+	next.VCenters = nil
+	for i, vcenter := range cfg.VCenters {
+		vcenter, err = vcenter.RereadSecretFiles()
+		if err != nil {
+			return nil, fmt.Errorf("vcenters[%d]: %v", i, err)
+		}
+		next.VCenters = append(next.VCenters, vcenter)
+	}
+
+	return &next, nil
+}
+
+//clearFileSecrets drops the values read from the secret files, so they are
+//not taken for settings that clash with the files
+func (cfg *Config) clearFileSecrets() {
+	if cfg.VSphereUserFile != "" {
+		cfg.VSphereUser = ""
+	}
+	if cfg.VSpherePassFile != "" {
+		cfg.VSpherePass = ""
+	}
+}
+
+//readSecretFile reads a secret, without the trailing newline most editors
+//and kubectl create secret --from-file leave behind
+func readSecretFile(file string) (string, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", fmt.Errorf("unable to read secret file %s: %v", file, err)
+	}
+
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+//SecretFiles returns the files the secrets of all vCenters are read from
+func (cfg *Config) SecretFiles() []string {
+	var files []string
+	known := make(map[string]bool)
+	for _, vcenter := range append([]*Config{cfg}, cfg.VCenters...) {
+		for _, file := range []string{vcenter.VSphereUserFile, vcenter.VSpherePassFile, vcenter.VSphereTokenFile, vcenter.VSphereCertFile, vcenter.VSphereKeyFile} {
+			if file != "" && !known[file] {
+				known[file] = true
+				files = append(files, file)
+			}
+		}
+	}
+	return files
+}
+
+//AddSecrets registers values to scrub from logs and errors. Values shorter
+//than minSecretLength are skipped.
+func AddSecrets(values ...string) {
+	secretsMutex.Lock()
+	defer secretsMutex.Unlock()
+
+	for _, value := range values {
+		if len(value) < minSecretLength {
+			continue
+		}
+		known := false
+		for _, secret := range secrets {
+			if secret == value {
+				known = true
+				break
+			}
+		}
+		if !known {
+			secrets = append(secrets, value)
+		}
+	}
+
+	// Replace the longest secrets first, in case one contains another
+	sort.Slice(secrets, func(i, j int) bool {
+		return len(secrets[i]) > len(secrets[j])
+	})
+}
+
+//Redact scrubs the registered secrets from text
+func Redact(text string) string {
+	secretsMutex.RLock()
+	defer secretsMutex.RUnlock()
+
+	for _, secret := range secrets {
+		text = strings.Replace(text, secret, redacted, -1)
+	}
+	return text
+}
+
+//RedactingFormatter scrubs the registered secrets from every log entry
+type RedactingFormatter struct {
+	Formatter log.Formatter
+}
+
+//Format implements logrus.Formatter
+func (f *RedactingFormatter) Format(entry *log.Entry) ([]byte, error) {
+	data, err := f.Formatter.Format(entry)
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte(Redact(string(data))), nil
+}
+
+//WatchFiles calls changed whenever the content of one of the files changes
+//and returns when stop is closed. The list is asked for on every check, so it
+//follows reloads; a file new to the list is only noted, not reported.
+func WatchFiles(files func() []string, interval time.Duration, stop <-chan struct{}, changed func()) {
+	sums := make(map[string][]byte)
+	for _, file := range files() {
+		sums[file] = fileSum(file)
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		rotated := false
+		watched := make(map[string][]byte)
+		for _, file := range files() {
+			previous, known := sums[file]
+			sum := fileSum(file)
+			switch {
+			case known && sum == nil:
+				watched[file] = previous
+			case known && !bytes.Equal(sum, previous):
+				log.Infoln("Secret file", file, "changed")
+				watched[file] = sum
+				rotated = true
+			default:
+				watched[file] = sum
+			}
+		}
+		sums = watched
+
+		if rotated {
+			changed()
+		}
+	}
+}
+
+//fileSum returns the hash of the file or nil when it cannot be read, e.g.
+//while a mounted secret is being swapped
+func fileSum(file string) []byte {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil
+	}
+
+	sum := sha256.Sum256(data)
+	return sum[:]
+}
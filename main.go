@@ -20,8 +20,10 @@ import (
 	"flag"
 	"math/rand"
 	"os"
+	"os/signal"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	log "github.com/sirupsen/logrus"
@@ -34,14 +36,17 @@ func init() {
 	rand.Seed(time.Now().UnixNano())
 	log.SetOutput(os.Stdout)
 	log.SetLevel(log.InfoLevel)
+	log.SetFormatter(&config.RedactingFormatter{Formatter: &log.TextFormatter{}})
 	log.Infoln("Initializing the Prometheus metric collection for vSphere...")
 }
 
 func main() {
-	cfg := config.NewConfig()
-	fs := flag.NewFlagSet("vsphere-proxy", flag.ExitOnError)
-	cfg.AddFlags(fs)
-	fs.Parse(os.Args[1:])
+	cfg, err := config.Load(os.Args[1:])
+	if err == flag.ErrHelp {
+		os.Exit(2)
+	} else if err != nil {
+		log.Fatalln("Invalid configuration:", err)
+	}
 
 	level, err := log.ParseLevel(cfg.LogLevel)
 	if err != nil {
@@ -53,10 +58,54 @@ func main() {
 	log.SetLevel(level)
 
 	for _, e := range os.Environ() {
-		pair := strings.Split(e, "=")
+		pair := strings.SplitN(e, "=", 2)
+		if sensitiveEnv(pair[0]) {
+			pair[1] = "<redacted>"
+		}
 		log.Debugln(pair[0], "=", pair[1])
 	}
 
 	restServer := server.NewRestServer(cfg)
-	restServer.Server.Run(":" + strconv.Itoa(cfg.RestPort))
+	restServer.Loader = func() (*config.Config, error) {
+		return config.Load(os.Args[1:])
+	}
+
+	// Log in again when a mounted secret is rotated. The files are taken
+	// from the running config, so a reload can change them.
+	go config.WatchFiles(restServer.SecretFiles, config.SecretPollInterval, nil, func() {
+		err := restServer.RotateSecrets()
+		if err != nil {
+			log.Errorln("RotateSecrets Failed:", err)
+		}
+	})
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			err := restServer.Reload()
+			if err != nil {
+				log.Errorln("Reload Failed:", err)
+			}
+		}
+	}()
+
+	err = restServer.ListenAndServe(":" + strconv.Itoa(cfg.RestPort))
+	if err != nil {
+		log.Fatalln("ListenAndServe Failed:", err)
+	}
+}
+
+//sensitiveEnv tells whether an environment variable holds a credential. The
+//_FILE variants only hold a path.
+func sensitiveEnv(key string) bool {
+	if strings.HasSuffix(key, "_FILE") {
+		return false
+	}
+	for _, word := range []string{"PASSWORD", "SECRET", "TOKEN", "KEY"} {
+		if strings.Contains(key, word) {
+			return true
+		}
+	}
+	return false
 }
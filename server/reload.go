@@ -0,0 +1,201 @@
+/*
+Copyright (c) 2018 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	config "github.com/dvonthenen/vsphere-metrics-prometheus/config"
+	"github.com/dvonthenen/vsphere-metrics-prometheus/vsphere"
+)
+
+var (
+	//ErrReloadNotSupported - The server was started without a config loader
+	ErrReloadNotSupported = errors.New("The server does not support reloading the config")
+
+	//ErrRestartRequired - The new config changes settings that only apply on startup
+	ErrRestartRequired = errors.New("The config changes settings that need a restart")
+)
+
+//restartSettings returns the settings of the listener that differ between
+//the configs, they only apply on startup
+func restartSettings(old *config.Config, cfg *config.Config) []string {
+	var changed []string
+	if old.RestPort != cfg.RestPort {
+		changed = append(changed, "rest_port")
+	}
+	if old.WebConfigFile != cfg.WebConfigFile {
+		changed = append(changed, "web_config_file")
+	}
+	return changed
+}
+
+//Reload reads the config again and applies it once the scrapes in flight are
+//done. A vCenter whose client cannot apply the config, or that is new to it,
+//gets a new client, built while the scrapes go on. The clients of the
+//vCenters the config leaves out are closed. The running config and clients
+//are kept when the new config cannot be applied to all vCenters.
+func (s *RestServer) Reload() error {
+	log.Debugln("Reload ENTER")
+
+	if s.Loader == nil {
+		log.Debugln("Reload LEAVE")
+		return ErrReloadNotSupported
+	}
+
+	cfg, err := s.Loader()
+	if err != nil {
+		log.Debugln("Reload LEAVE")
+		return err
+	}
+
+	s.reloadMutex.Lock()
+	defer s.reloadMutex.Unlock()
+
+	s.mutex.RLock()
+	running := s.Config
+	vClients := s.vClients
+	s.mutex.RUnlock()
+
+	changed := restartSettings(running, cfg)
+	if len(changed) > 0 {
+		log.Debugln("Reload LEAVE")
+		return fmt.Errorf("%v: %s", ErrRestartRequired, strings.Join(changed, ", "))
+	}
+
+	next := make(map[string]*vsphere.Client)
+	built := make(map[string]*vsphere.Client)
+	var applies []func()
+	for _, vcenter := range cfg.VCenterConfigs() {
+		name := vcenter.VSphereHostname
+		vClient, ok := vClients[name]
+		if ok {
+			changed = vClient.NewClientSettings(vcenter)
+			if len(changed) == 0 {
+				apply, err := vClient.PrepareReload(vcenter)
+				if err != nil {
+					closeClients(built)
+					log.Debugln("Reload LEAVE")
+					return fmt.Errorf("%s: %v", name, err)
+				}
+				applies = append(applies, apply)
+				next[name] = vClient
+				continue
+			}
+			log.Infoln("Building a new client for", name, "for", strings.Join(changed, ", "))
+		} else {
+			log.Infoln("Building a client for", name)
+		}
+
+		vClient, err = newClient(vcenter)
+		if err != nil {
+			closeClients(built)
+			log.Debugln("Reload LEAVE")
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		built[name] = vClient
+		next[name] = vClient
+	}
+
+	s.mutex.Lock()
+	for _, apply := range applies {
+		apply()
+	}
+	s.vClients = next
+	s.Config = cfg
+	s.mutex.Unlock()
+
+	// The scrapes moved on to the new clients, so the old ones can log out
+	for name, vClient := range vClients {
+		if next[name] != vClient {
+			vClient.Close()
+		}
+	}
+
+	level, err := log.ParseLevel(cfg.LogLevel)
+	if err != nil {
+		log.Warnln("Invalid log level. Keeping", log.GetLevel())
+	} else {
+		log.SetLevel(level)
+	}
+
+	log.Infoln("Reloaded the config")
+	log.Debugln("Reload Succeeded")
+	log.Debugln("Reload LEAVE")
+
+	return nil
+}
+
+//RotateSecrets reads the secret files of the running config again and
+//applies them once the scrapes in flight are done. The config file is not
+//read, so an edit that cannot be applied does not hold up the rotation.
+func (s *RestServer) RotateSecrets() error {
+	log.Debugln("RotateSecrets ENTER")
+
+	s.reloadMutex.Lock()
+	defer s.reloadMutex.Unlock()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	cfg, err := s.Config.RereadSecretFiles()
+	if err != nil {
+		log.Debugln("RotateSecrets LEAVE")
+		return err
+	}
+
+	for _, vcenter := range cfg.VCenterConfigs() {
+		s.vClients[vcenter.VSphereHostname].UpdateCredentials(vcenter)
+	}
+	s.Config = cfg
+
+	log.Debugln("RotateSecrets Succeeded")
+	log.Debugln("RotateSecrets LEAVE")
+
+	return nil
+}
+
+//SecretFiles returns the secret files of the running config
+func (s *RestServer) SecretFiles() []string {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return s.Config.SecretFiles()
+}
+
+func (s *RestServer) postReload(w http.ResponseWriter, r *http.Request) error {
+	log.Debugln("postReload ENTER")
+
+	err := s.Reload()
+	if err != nil {
+		http.Error(w, config.Redact(err.Error()), http.StatusInternalServerError)
+		log.Debugln("postReload LEAVE")
+		return err
+	}
+
+	fmt.Fprintln(w, "Config reloaded")
+
+	log.Debugln("postReload Succeeded")
+	log.Debugln("postReload LEAVE")
+
+	return nil
+}
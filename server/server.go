@@ -17,7 +17,9 @@ limitations under the License.
 package server
 
 import (
+	"errors"
 	"net/http"
+	"sync"
 
 	"github.com/gorilla/mux"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -28,24 +30,51 @@ import (
 	"github.com/dvonthenen/vsphere-metrics-prometheus/vsphere"
 )
 
+var (
+	//ErrVCenterMissing - The scrape does not say which of several vCenters it is for
+	ErrVCenterMissing = errors.New("The vcenter parameter is required with several vCenters")
+
+	//ErrVCenterUnknown - The scrape asks for a vCenter that is not configured
+	ErrVCenterUnknown = errors.New("The vcenter parameter names an unknown vCenter")
+)
+
 //RestServer representation for a REST API server
 type RestServer struct {
-	Config  *config.Config
-	vClient *vsphere.Client
-	Server  *negroni.Negroni
+	Config *config.Config
+	Server *negroni.Negroni
+
+	//vClients holds the client of each vCenter by hostname
+	vClients map[string]*vsphere.Client
+
+	//Loader reads the config again on a reload
+	Loader func() (*config.Config, error)
+
+	//mutex is held for reading by the scrapes and for writing by a reload
+	mutex sync.RWMutex
+
+	//reloadMutex runs one reload or secret rotation at a time, so a client
+	//can be built for a new config without holding up the scrapes
+	reloadMutex sync.Mutex
+
+	//authCache remembers the basic auth checks of the web config
+	authCache authCache
 }
 
 //NewRestServer generates a new REST API server
 func NewRestServer(cfg *config.Config) *RestServer {
 	restServer := &RestServer{
-		Config:  cfg,
-		vClient: vsphere.NewClient(cfg),
+		Config:   cfg,
+		vClients: make(map[string]*vsphere.Client),
 	}
 
-	err := restServer.vClient.RegisterMetrics()
-	if err != nil {
-		log.Errorln("registerMetrics Failed:", err)
-		return nil
+	for _, vcenter := range cfg.VCenterConfigs() {
+		vClient, err := newClient(vcenter)
+		if err != nil {
+			log.Errorln("registerMetrics Failed:", err)
+			closeClients(restServer.vClients)
+			return nil
+		}
+		restServer.vClients[vcenter.VSphereHostname] = vClient
 	}
 
 	mux := mux.NewRouter()
@@ -55,36 +84,102 @@ func NewRestServer(cfg *config.Config) *RestServer {
 			log.Errorln("getVersion Failed:", err)
 		}
 	}).Methods("GET")
-	if cfg.VSphereType == string(config.VSphereRoleEsx) {
-		mux.HandleFunc("/datacenter/{datacenter}/host/{host}/metrics", func(w http.ResponseWriter, r *http.Request) {
-			err := restServer.vClient.GetVSphereEsxStats(w, r)
-			if err != nil {
-				log.Errorln("getVSphereStats Failed:", err)
-			}
-			promhttp.Handler().ServeHTTP(w, r)
-		}).Methods("GET")
-	} else if cfg.VSphereType == string(config.VSphereRoleDatastore) {
-		mux.HandleFunc("/datacenter/{datacenter}/datastore/{datastore}/metrics", func(w http.ResponseWriter, r *http.Request) {
-			err := restServer.vClient.GetVSphereDatastoreStats(w, r)
-			if err != nil {
-				log.Errorln("GetVSphereDatastoreStats Failed:", err)
-			}
-			promhttp.Handler().ServeHTTP(w, r)
-		}).Methods("GET")
-	} else if cfg.VSphereType == string(config.VSphereRoleVirtualMachine) {
-		mux.HandleFunc("/datacenter/{datacenter}/vm/{vm}/metrics", func(w http.ResponseWriter, r *http.Request) {
-			err := restServer.vClient.GetVSphereVMStats(w, r)
-			if err != nil {
-				log.Errorln("GetVSphereVMStats Failed:", err)
-			}
-			promhttp.Handler().ServeHTTP(w, r)
-		}).Methods("GET")
-	}
+	mux.HandleFunc("/-/reload", func(w http.ResponseWriter, r *http.Request) {
+		err := restServer.postReload(w, r)
+		if err != nil {
+			log.Errorln("postReload Failed:", err)
+		}
+	}).Methods("POST")
+	mux.Handle("/metrics", promhttp.HandlerFor(vsphere.SelfRegistry, promhttp.HandlerOpts{})).Methods("GET")
+	// The routes of every role are registered, a reload can change the role
+	mux.HandleFunc("/datacenter/{datacenter}/host/{host}/metrics", func(w http.ResponseWriter, r *http.Request) {
+		restServer.serveScrape(w, r, config.VSphereRoleEsx, "GetVSphereEsxStats", (*vsphere.Client).GetVSphereEsxStats)
+	}).Methods("GET")
+	mux.HandleFunc("/datacenter/{datacenter}/datastore/{datastore}/metrics", func(w http.ResponseWriter, r *http.Request) {
+		restServer.serveScrape(w, r, config.VSphereRoleDatastore, "GetVSphereDatastoreStats", (*vsphere.Client).GetVSphereDatastoreStats)
+	}).Methods("GET")
+	mux.HandleFunc("/datacenter/{datacenter}/vm/{vm}/metrics", func(w http.ResponseWriter, r *http.Request) {
+		restServer.serveScrape(w, r, config.VSphereRoleVirtualMachine, "GetVSphereVMStats", (*vsphere.Client).GetVSphereVMStats)
+	}).Methods("GET")
+	mux.HandleFunc("/license/metrics", func(w http.ResponseWriter, r *http.Request) {
+		restServer.serveScrape(w, r, config.VSphereRoleLicense, "GetVSphereLicenseStats", (*vsphere.Client).GetVSphereLicenseStats)
+	}).Methods("GET")
 
 	server := negroni.Classic()
+	server.Use(negroni.HandlerFunc(restServer.authenticate))
 	server.UseHandler(mux)
 
 	restServer.Server = server
 
 	return restServer
 }
+
+//newClient builds a client for cfg and registers its metrics
+func newClient(cfg *config.Config) (*vsphere.Client, error) {
+	vClient := vsphere.NewClient(cfg)
+
+	err := vClient.RegisterMetrics()
+	if err != nil {
+		vClient.Close()
+		return nil, err
+	}
+
+	return vClient, nil
+}
+
+//closeClients closes the clients, e.g. the ones built for a config that
+//could not be applied
+func closeClients(vClients map[string]*vsphere.Client) {
+	for _, vClient := range vClients {
+		vClient.Close()
+	}
+}
+
+//client returns the client of the vCenter a scrape asks for with the
+//vcenter parameter. It can be left out with a single vCenter.
+func (s *RestServer) client(r *http.Request) (*vsphere.Client, error) {
+	name := r.URL.Query().Get("vcenter")
+	if name == "" {
+		if len(s.vClients) != 1 {
+			return nil, ErrVCenterMissing
+		}
+		for _, vClient := range s.vClients {
+			return vClient, nil
+		}
+	}
+
+	vClient, ok := s.vClients[name]
+	if !ok {
+		return nil, ErrVCenterUnknown
+	}
+	return vClient, nil
+}
+
+//serveScrape collects the metrics of an entity with the client of its
+//vCenter and serves them. The entities of other roles are not found. The
+//duration and result of the scrape end up on the /metrics endpoint.
+func (s *RestServer) serveScrape(w http.ResponseWriter, r *http.Request, role config.Role, name string, collect func(*vsphere.Client, http.ResponseWriter, *http.Request) error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if s.Config.VSphereType != string(role) {
+		http.NotFound(w, r)
+		return
+	}
+
+	vClient, err := s.client(r)
+	if err == ErrVCenterMissing {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	err = vClient.Serve(w, r, func(w http.ResponseWriter, r *http.Request) error {
+		return collect(vClient, w, r)
+	})
+	if err != nil {
+		log.Errorln(name, "Failed:", err)
+	}
+}
@@ -20,6 +20,7 @@ import (
 	"io"
 	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
 	"os"
 	"strconv"
 	"testing"
@@ -30,6 +31,7 @@ import (
 
 	config "github.com/dvonthenen/vsphere-metrics-prometheus/config"
 	"github.com/dvonthenen/vsphere-metrics-prometheus/types"
+	"github.com/dvonthenen/vsphere-metrics-prometheus/vsphere"
 )
 
 var server *RestServer
@@ -83,3 +85,181 @@ func TestVersion(t *testing.T) {
 	assert.Equal(t, ver.VersionInt, config.VersionInt)
 	assert.Equal(t, ver.VersionStr, config.VersionStr)
 }
+
+func TestReload(t *testing.T) {
+	base := "http://127.0.0.1:" + strconv.Itoa(server.Config.RestPort)
+	url := base + "/-/reload"
+
+	running := *server.Config
+	loaded := running
+	loaded.EntityInclude = "name:ds-*"
+	server.Loader = func() (*config.Config, error) {
+		cfg := loaded
+		return &cfg, nil
+	}
+	defer func() {
+		loaded = running
+		assert.NoError(t, server.Reload())
+		server.Loader = nil
+	}()
+
+	post := func() (int, string) {
+		resp, err := http.Post(url, "text/plain", nil)
+		assert.NoError(t, err)
+		body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1048576))
+		resp.Body.Close()
+		assert.NoError(t, err)
+		return resp.StatusCode, string(body)
+	}
+	scrape := func(path string) int {
+		resp, err := http.Get(base + path)
+		assert.NoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// The running client applies the entity filters
+	vClient := server.vClients[running.VSphereHostname]
+	status, _ := post()
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "name:ds-*", server.Config.EntityInclude)
+	assert.Equal(t, vClient, server.vClients[running.VSphereHostname])
+
+	// A new role gets a new client. When it cannot connect the running
+	// client and config are kept.
+	loaded.EntityInclude = ""
+	loaded.VSphereType = string(config.VSphereRoleEsx)
+	status, _ = post()
+	assert.Equal(t, http.StatusInternalServerError, status)
+	assert.Equal(t, "name:ds-*", server.Config.EntityInclude)
+	assert.Equal(t, string(config.VSphereRoleDatastore), server.Config.VSphereType)
+	assert.Equal(t, vClient, server.vClients[running.VSphereHostname])
+
+	// The new client replaces the running one and serves the routes of its role
+	loaded.VSphereType = string(config.VSphereRoleLicense)
+	status, _ = post()
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, string(config.VSphereRoleLicense), server.Config.VSphereType)
+	assert.NotEqual(t, vClient, server.vClients[running.VSphereHostname])
+	assert.Equal(t, http.StatusNotFound, scrape("/datacenter/dc1/datastore/ds1/metrics"))
+	assert.NotEqual(t, http.StatusNotFound, scrape("/license/metrics"))
+
+	// The listener only changes on a restart
+	loaded.RestPort++
+	status, body := post()
+	assert.Equal(t, http.StatusInternalServerError, status)
+	assert.Contains(t, body, "rest_port")
+	assert.Equal(t, running.RestPort, server.Config.RestPort)
+	loaded.RestPort--
+}
+
+func TestReloadVCenters(t *testing.T) {
+	running := *server.Config
+	vcenter := func(hostname string) *config.Config {
+		cfg := running
+		cfg.VSphereType = string(config.VSphereRoleLicense)
+		cfg.VSphereHostname = hostname
+		return &cfg
+	}
+	loaded := *vcenter("")
+	loaded.VCenters = []*config.Config{vcenter("vc1"), vcenter("vc2")}
+	server.Loader = func() (*config.Config, error) {
+		cfg := loaded
+		return &cfg, nil
+	}
+	defer func() {
+		loaded = running
+		assert.NoError(t, server.Reload())
+		server.Loader = nil
+	}()
+
+	assert.NoError(t, server.Reload())
+	assert.Len(t, server.vClients, 2)
+	vc2 := server.vClients["vc2"]
+
+	// A vCenter left out of the config is closed, the others keep their client
+	loaded.VCenters = loaded.VCenters[1:]
+	assert.NoError(t, server.Reload())
+	assert.Len(t, server.vClients, 1)
+	assert.Equal(t, vc2, server.vClients["vc2"])
+}
+
+func TestScrapeVCenter(t *testing.T) {
+	vc1 := vsphere.NewClient(&config.Config{VSphereHostname: "vc1"})
+	vc2 := vsphere.NewClient(&config.Config{VSphereHostname: "vc2"})
+	s := &RestServer{vClients: map[string]*vsphere.Client{"vc1": vc1}}
+
+	request := func(query string) *http.Request {
+		return httptest.NewRequest("GET", "/license/metrics"+query, nil)
+	}
+
+	// A single vCenter does not need to be named
+	vClient, err := s.client(request(""))
+	assert.NoError(t, err)
+	assert.Equal(t, vc1, vClient)
+
+	s.vClients["vc2"] = vc2
+	_, err = s.client(request(""))
+	assert.Equal(t, ErrVCenterMissing, err)
+
+	vClient, err = s.client(request("?vcenter=vc2"))
+	assert.NoError(t, err)
+	assert.Equal(t, vc2, vClient)
+
+	_, err = s.client(request("?vcenter=vc3"))
+	assert.Equal(t, ErrVCenterUnknown, err)
+}
+
+func TestRotateSecrets(t *testing.T) {
+	file, err := ioutil.TempFile("", "password")
+	assert.NoError(t, err)
+	defer os.Remove(file.Name())
+	_, err = file.WriteString("first")
+	assert.NoError(t, err)
+	assert.NoError(t, file.Close())
+
+	running := *server.Config
+	loaded := running
+	loaded.VSpherePassFile = file.Name()
+	loaded.VSpherePass = "first"
+	server.Loader = func() (*config.Config, error) {
+		cfg := loaded
+		return &cfg, nil
+	}
+	defer func() {
+		server.Loader = func() (*config.Config, error) {
+			cfg := running
+			return &cfg, nil
+		}
+		assert.NoError(t, server.Reload())
+		server.Loader = nil
+	}()
+
+	assert.NoError(t, server.Reload())
+	assert.Equal(t, []string{file.Name()}, server.SecretFiles())
+
+	// An edit that cannot be applied does not hold up the rotation
+	loaded.VSphereType = string(config.VSphereRoleEsx)
+	assert.NoError(t, ioutil.WriteFile(file.Name(), []byte("second"), 0600))
+	assert.NoError(t, server.RotateSecrets())
+	assert.Equal(t, "second", server.Config.VSpherePass)
+	assert.Equal(t, running.VSphereType, server.Config.VSphereType)
+}
+
+func TestSelfMetrics(t *testing.T) {
+	url := "http://127.0.0.1:" +
+		strconv.Itoa(server.Config.RestPort) + "/metrics"
+
+	resp, err := http.Get(url)
+	assert.NotNil(t, resp)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1048576))
+	assert.NoError(t, err)
+	assert.Contains(t, string(body), `vsphere_exporter_session_age_seconds{vcenter="`+server.Config.VSphereHostname+`"} 0`)
+	assert.Contains(t, string(body), "go_goroutines")
+	assert.NotContains(t, string(body), "vsphere_datastore")
+}
@@ -43,7 +43,7 @@ func (s *RestServer) getVersion(w http.ResponseWriter, r *http.Request) error {
 	}
 
 	log.Debugln("response:", string(response))
-	fmt.Fprintf(w, string(response))
+	fmt.Fprint(w, string(response))
 
 	log.Debugln("getVersion Succeeded")
 	log.Debugln("getVersion LEAVE")
@@ -0,0 +1,433 @@
+/*
+Copyright (c) 2018 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/bcrypt"
+	yaml "gopkg.in/yaml.v2"
+
+	config "github.com/dvonthenen/vsphere-metrics-prometheus/config"
+)
+
+//authCacheSize bounds the cache of successful basic auth checks
+const authCacheSize = 100
+
+//dummyHash is compared for unknown users, so they take as long to reject as
+//a wrong password and the timing does not tell which users exist
+const dummyHash = "$2a$10$9tfjHqyQ9ikCScuBEEeE/.dsNftdFZvASt1rdn70JlFAFlk6JYnXW"
+
+var (
+	//ErrTLSTurnedOff - The web config lost its TLS section while serving TLS
+	ErrTLSTurnedOff = errors.New("The web config has no tls_server_config, TLS cannot be turned off without a restart")
+
+	//ErrNoClientCAs - Client certificates are verified without a CA
+	ErrNoClientCAs = errors.New("client_auth_type needs client_ca_file to verify client certificates")
+
+	//ErrClientNotAllowed - The client certificate has none of the allowed SANs
+	ErrClientNotAllowed = errors.New("The client certificate has none of the allowed SANs")
+)
+
+//webConfig is the web-config file of the Prometheus exporters, see
+//https://github.com/prometheus/exporter-toolkit/blob/master/docs/web-configuration.md
+//bearer_token_files is an addition of this exporter.
+type webConfig struct {
+	TLSServerConfig  *webTLSConfig     `yaml:"tls_server_config"`
+	HTTPServerConfig webHTTPConfig     `yaml:"http_server_config"`
+	BasicAuthUsers   map[string]string `yaml:"basic_auth_users"`
+	BearerTokenFiles []string          `yaml:"bearer_token_files"`
+
+	//bearerTokens are read from the BearerTokenFiles
+	bearerTokens []string
+}
+
+type webTLSConfig struct {
+	CertFile                 string   `yaml:"cert_file"`
+	KeyFile                  string   `yaml:"key_file"`
+	ClientAuthType           string   `yaml:"client_auth_type"`
+	ClientCAFile             string   `yaml:"client_ca_file"`
+	ClientAllowedSans        []string `yaml:"client_allowed_sans"`
+	MinVersion               string   `yaml:"min_version"`
+	MaxVersion               string   `yaml:"max_version"`
+	CipherSuites             []string `yaml:"cipher_suites"`
+	CurvePreferences         []string `yaml:"curve_preferences"`
+	PreferServerCipherSuites bool     `yaml:"prefer_server_cipher_suites"`
+}
+
+type webHTTPConfig struct {
+	HTTP2   *bool             `yaml:"http2"`
+	Headers map[string]string `yaml:"headers"`
+}
+
+var tlsVersions = map[string]uint16{
+	"TLS10": tls.VersionTLS10,
+	"TLS11": tls.VersionTLS11,
+	"TLS12": tls.VersionTLS12,
+	"TLS13": tls.VersionTLS13,
+}
+
+var clientAuthTypes = map[string]tls.ClientAuthType{
+	"":                           tls.NoClientCert,
+	"NoClientCert":               tls.NoClientCert,
+	"RequestClientCert":          tls.RequestClientCert,
+	"RequireAnyClientCert":       tls.RequireAnyClientCert,
+	"VerifyClientCertIfGiven":    tls.VerifyClientCertIfGiven,
+	"RequireAndVerifyClientCert": tls.RequireAndVerifyClientCert,
+}
+
+var curves = map[string]tls.CurveID{
+	"CurveP256": tls.CurveP256,
+	"CurveP384": tls.CurveP384,
+	"CurveP521": tls.CurveP521,
+	"X25519":    tls.X25519,
+}
+
+//loadWebConfig reads and checks the web config. It is read again for every
+//request and TLS handshake, so rotated certificates, users and tokens apply
+//right away.
+func loadWebConfig(file string) (*webConfig, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	web := &webConfig{}
+	err = yaml.UnmarshalStrict(data, web)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse %s: %v", file, err)
+	}
+
+	for user, hash := range web.BasicAuthUsers {
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return nil, fmt.Errorf("basic_auth_users: the password of %q is not a bcrypt hash: %v", user, err)
+		}
+	}
+
+	for _, tokenFile := range web.BearerTokenFiles {
+		data, err := ioutil.ReadFile(tokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("bearer_token_files: %v", err)
+		}
+		token := strings.TrimRight(string(data), "\r\n")
+		if token == "" {
+			return nil, fmt.Errorf("bearer_token_files: %s is empty", tokenFile)
+		}
+		config.AddSecrets(token)
+		web.bearerTokens = append(web.bearerTokens, token)
+	}
+
+	return web, nil
+}
+
+//tlsConfig builds the server side TLS config and loads the certificates
+func (w *webTLSConfig) tlsConfig() (*tls.Config, error) {
+	if w.CertFile == "" || w.KeyFile == "" {
+		return nil, errors.New("tls_server_config needs cert_file and key_file")
+	}
+
+	cert, err := tls.LoadX509KeyPair(w.CertFile, w.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load the server certificate: %v", err)
+	}
+
+	cfg := &tls.Config{
+		Certificates:             []tls.Certificate{cert},
+		MinVersion:               tls.VersionTLS12,
+		PreferServerCipherSuites: w.PreferServerCipherSuites,
+	}
+
+	if w.MinVersion != "" {
+		version, ok := tlsVersions[w.MinVersion]
+		if !ok {
+			return nil, fmt.Errorf("min_version: unknown TLS version %q", w.MinVersion)
+		}
+		cfg.MinVersion = version
+	}
+	if w.MaxVersion != "" {
+		version, ok := tlsVersions[w.MaxVersion]
+		if !ok {
+			return nil, fmt.Errorf("max_version: unknown TLS version %q", w.MaxVersion)
+		}
+		cfg.MaxVersion = version
+	}
+
+	for _, name := range w.CipherSuites {
+		id, ok := cipherSuite(name)
+		if !ok {
+			return nil, fmt.Errorf("cipher_suites: unknown cipher suite %q", name)
+		}
+		cfg.CipherSuites = append(cfg.CipherSuites, id)
+	}
+
+	for _, name := range w.CurvePreferences {
+		curve, ok := curves[name]
+		if !ok {
+			return nil, fmt.Errorf("curve_preferences: unknown curve %q", name)
+		}
+		cfg.CurvePreferences = append(cfg.CurvePreferences, curve)
+	}
+
+	clientAuth, ok := clientAuthTypes[w.ClientAuthType]
+	if !ok {
+		return nil, fmt.Errorf("client_auth_type: unknown type %q", w.ClientAuthType)
+	}
+	cfg.ClientAuth = clientAuth
+
+	if w.ClientCAFile != "" {
+		data, err := ioutil.ReadFile(w.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.ClientCAs = x509.NewCertPool()
+		if !cfg.ClientCAs.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("client_ca_file: %s holds no PEM certificates", w.ClientCAFile)
+		}
+	} else if clientAuth == tls.VerifyClientCertIfGiven || clientAuth == tls.RequireAndVerifyClientCert {
+		return nil, ErrNoClientCAs
+	}
+
+	if len(w.ClientAllowedSans) > 0 {
+		if cfg.ClientCAs == nil {
+			return nil, errors.New("client_allowed_sans needs client_ca_file")
+		}
+		cfg.VerifyPeerCertificate = allowedSANs(w.ClientAllowedSans)
+	}
+
+	return cfg, nil
+}
+
+//cipherSuite looks up a cipher suite by its Go name
+func cipherSuite(name string) (uint16, bool) {
+	for _, suites := range [][]*tls.CipherSuite{tls.CipherSuites(), tls.InsecureCipherSuites()} {
+		for _, suite := range suites {
+			if suite.Name == name {
+				return suite.ID, true
+			}
+		}
+	}
+	return 0, false
+}
+
+//allowedSANs only lets clients in whose verified certificate carries one of
+//the SANs
+func allowedSANs(sans []string) func([][]byte, [][]*x509.Certificate) error {
+	return func(_ [][]byte, verifiedChains [][]*x509.Certificate) error {
+		for _, chain := range verifiedChains {
+			if len(chain) == 0 {
+				continue
+			}
+			cert := chain[0]
+			var names []string
+			names = append(names, cert.DNSNames...)
+			names = append(names, cert.EmailAddresses...)
+			for _, ip := range cert.IPAddresses {
+				names = append(names, ip.String())
+			}
+			for _, uri := range cert.URIs {
+				names = append(names, uri.String())
+			}
+
+			for _, name := range names {
+				for _, san := range sans {
+					if name == san {
+						return nil
+					}
+				}
+			}
+		}
+		return ErrClientNotAllowed
+	}
+}
+
+//authRequired tells whether the web config asks for basic auth or a token
+func (web *webConfig) authRequired() bool {
+	return len(web.BasicAuthUsers) > 0 || len(web.bearerTokens) > 0
+}
+
+//authCache remembers successful basic auth checks, bcrypt is too slow to run
+//on every scrape
+type authCache struct {
+	mutex sync.Mutex
+	valid map[string]bool
+}
+
+func (a *authCache) check(user string, pass string, hash string) bool {
+	sum := sha256.Sum256([]byte(user + "\x00" + pass + "\x00" + hash))
+	key := hex.EncodeToString(sum[:])
+
+	a.mutex.Lock()
+	if a.valid[key] {
+		a.mutex.Unlock()
+		return true
+	}
+	a.mutex.Unlock()
+
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(pass)) != nil {
+		return false
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if a.valid == nil || len(a.valid) >= authCacheSize {
+		a.valid = make(map[string]bool)
+	}
+	a.valid[key] = true
+
+	return true
+}
+
+//authenticated checks the basic auth or bearer token of a request
+func (web *webConfig) authenticated(r *http.Request, cache *authCache) bool {
+	if user, pass, ok := r.BasicAuth(); ok {
+		hash, known := web.BasicAuthUsers[user]
+		if !known {
+			bcrypt.CompareHashAndPassword([]byte(dummyHash), []byte(pass))
+			return false
+		}
+		return cache.check(user, pass, hash)
+	}
+
+	auth := r.Header.Get("Authorization")
+	if strings.HasPrefix(auth, "Bearer ") {
+		token := []byte(strings.TrimPrefix(auth, "Bearer "))
+		for _, bearerToken := range web.bearerTokens {
+			if subtle.ConstantTimeCompare(token, []byte(bearerToken)) == 1 {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+//authenticate is the negroni middleware that applies the headers and the
+//authentication of the web config
+func (s *RestServer) authenticate(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	file := s.webConfigFile()
+	if file == "" {
+		next(w, r)
+		return
+	}
+
+	web, err := loadWebConfig(file)
+	if err != nil {
+		log.Errorln("loadWebConfig Failed:", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	for key, value := range web.HTTPServerConfig.Headers {
+		w.Header().Set(key, value)
+	}
+
+	if web.authRequired() && !web.authenticated(r, &s.authCache) {
+		log.Debugln("Unauthorized request from", r.RemoteAddr, "for", r.URL.Path)
+		if len(web.BasicAuthUsers) > 0 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="vsphere-metrics-prometheus"`)
+		}
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	next(w, r)
+}
+
+//webConfigFile returns the web config file of the running config. A reload
+//swaps the config, the file itself needs a restart to change.
+func (s *RestServer) webConfigFile() string {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return s.Config.WebConfigFile
+}
+
+//ListenAndServe serves the REST endpoint, over TLS when the web config has a
+//tls_server_config. Without a web config it serves plain HTTP like before.
+func (s *RestServer) ListenAndServe(addr string) error {
+	log.Debugln("ListenAndServe ENTER")
+
+	srv := &http.Server{
+		Addr:    addr,
+		Handler: s.Server,
+	}
+
+	file := s.webConfigFile()
+	if file == "" {
+		log.Infoln("Listening on", addr)
+		log.Debugln("ListenAndServe LEAVE")
+		return srv.ListenAndServe()
+	}
+
+	web, err := loadWebConfig(file)
+	if err != nil {
+		log.Debugln("ListenAndServe LEAVE")
+		return err
+	}
+
+	if web.HTTPServerConfig.HTTP2 != nil && !*web.HTTPServerConfig.HTTP2 {
+		srv.TLSNextProto = make(map[string]func(*http.Server, *tls.Conn, http.Handler))
+	}
+
+	if web.TLSServerConfig == nil {
+		log.Infoln("Listening on", addr)
+		log.Debugln("ListenAndServe LEAVE")
+		return srv.ListenAndServe()
+	}
+
+	// Fail on startup rather than on the first handshake
+	_, err = web.TLSServerConfig.tlsConfig()
+	if err != nil {
+		log.Debugln("ListenAndServe LEAVE")
+		return err
+	}
+
+	srv.TLSConfig = &tls.Config{
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			web, err := loadWebConfig(file)
+			if err != nil {
+				log.Errorln("loadWebConfig Failed:", err)
+				return nil, err
+			}
+			if web.TLSServerConfig == nil {
+				log.Errorln("loadWebConfig Failed:", ErrTLSTurnedOff)
+				return nil, ErrTLSTurnedOff
+			}
+			cfg, err := web.TLSServerConfig.tlsConfig()
+			if err != nil {
+				log.Errorln("tlsConfig Failed:", err)
+			}
+			return cfg, err
+		},
+	}
+
+	log.Infoln("Listening on", addr, "with TLS")
+	log.Debugln("ListenAndServe LEAVE")
+
+	return srv.ListenAndServeTLS("", "")
+}
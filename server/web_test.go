@@ -0,0 +1,251 @@
+/*
+Copyright (c) 2018 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	assert "github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+
+	config "github.com/dvonthenen/vsphere-metrics-prometheus/config"
+)
+
+type testCertificate struct {
+	cert     *x509.Certificate
+	key      *ecdsa.PrivateKey
+	certFile string
+	keyFile  string
+}
+
+//newTestCertificate issues a certificate signed by parent, or a self-signed
+//CA when parent is nil
+func newTestCertificate(t *testing.T, dir string, name string, parent *testCertificate) *testCertificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+
+	parentCert, parentKey := template, key
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage = x509.KeyUsageCertSign
+	} else {
+		parentCert, parentKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parentCert, &key.PublicKey, parentKey)
+	assert.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.NoError(t, err)
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err)
+
+	certFile := filepath.Join(dir, name+".crt")
+	keyFile := filepath.Join(dir, name+".key")
+	assert.NoError(t, ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	assert.NoError(t, ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600))
+
+	return &testCertificate{cert: cert, key: key, certFile: certFile, keyFile: keyFile}
+}
+
+func writeWebConfig(t *testing.T, dir string, content string) string {
+	file := filepath.Join(dir, "web.yml")
+	assert.NoError(t, ioutil.WriteFile(file, []byte(content), 0600))
+	return file
+}
+
+func TestWebConfigAuth(t *testing.T) {
+	dir, err := ioutil.TempDir("", "web")
+	assert.NoError(t, err)
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	assert.NoError(t, err)
+	tokenFile := filepath.Join(dir, "token")
+	assert.NoError(t, ioutil.WriteFile(tokenFile, []byte("my-token\n"), 0600))
+
+	cfg := config.NewConfig()
+	cfg.WebConfigFile = writeWebConfig(t, dir, `
+http_server_config:
+  headers:
+    X-Frame-Options: deny
+basic_auth_users:
+  prometheus: `+string(hash)+`
+bearer_token_files:
+  - `+tokenFile+`
+`)
+	s := &RestServer{Config: cfg}
+
+	serve := func(r *http.Request) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		s.authenticate(w, r, func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		})
+		return w
+	}
+
+	r := httptest.NewRequest("GET", "/version", nil)
+	w := serve(r)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Contains(t, w.Header().Get("WWW-Authenticate"), "Basic")
+	assert.Equal(t, "deny", w.Header().Get("X-Frame-Options"))
+
+	r = httptest.NewRequest("GET", "/version", nil)
+	r.SetBasicAuth("prometheus", "wrong")
+	assert.Equal(t, http.StatusUnauthorized, serve(r).Code)
+
+	r = httptest.NewRequest("GET", "/version", nil)
+	r.SetBasicAuth("nobody", "secret")
+	assert.Equal(t, http.StatusUnauthorized, serve(r).Code)
+
+	// Unknown users are checked against a hash as slow as a real one
+	cost, err := bcrypt.Cost([]byte(dummyHash))
+	assert.NoError(t, err)
+	assert.Equal(t, bcrypt.DefaultCost, cost)
+
+	// The second check is answered from the cache
+	for i := 0; i < 2; i++ {
+		r = httptest.NewRequest("GET", "/version", nil)
+		r.SetBasicAuth("prometheus", "secret")
+		assert.Equal(t, http.StatusOK, serve(r).Code)
+	}
+
+	r = httptest.NewRequest("GET", "/version", nil)
+	r.Header.Set("Authorization", "Bearer my-token")
+	assert.Equal(t, http.StatusOK, serve(r).Code)
+
+	r = httptest.NewRequest("GET", "/version", nil)
+	r.Header.Set("Authorization", "Bearer other-token")
+	assert.Equal(t, http.StatusUnauthorized, serve(r).Code)
+
+	// The token file is read again on every request
+	assert.NoError(t, ioutil.WriteFile(tokenFile, []byte("other-token"), 0600))
+	assert.Equal(t, http.StatusOK, serve(r).Code)
+	assert.Equal(t, "<redacted>", config.Redact("other-token"))
+}
+
+func TestLoadWebConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "web")
+	assert.NoError(t, err)
+
+	_, err = loadWebConfig(writeWebConfig(t, dir, `
+basic_auth_users:
+  prometheus: plaintext
+`))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "bcrypt")
+
+	_, err = loadWebConfig(writeWebConfig(t, dir, `
+tls_server_config:
+  cert_file: server.crt
+  unknown: true
+`))
+	assert.Error(t, err)
+
+	web, err := loadWebConfig(writeWebConfig(t, dir, `
+tls_server_config:
+  cert_file: server.crt
+  key_file: server.key
+  min_version: TLS13
+`))
+	assert.NoError(t, err)
+	assert.False(t, web.authRequired())
+	assert.Equal(t, "TLS13", web.TLSServerConfig.MinVersion)
+}
+
+func TestWebConfigTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "web")
+	assert.NoError(t, err)
+
+	ca := newTestCertificate(t, dir, "ca", nil)
+	serverCert := newTestCertificate(t, dir, "localhost", ca)
+	clientCert := newTestCertificate(t, dir, "prometheus", ca)
+
+	web := &webTLSConfig{
+		CertFile:       serverCert.certFile,
+		KeyFile:        serverCert.keyFile,
+		ClientAuthType: "RequireAndVerifyClientCert",
+		ClientCAFile:   ca.certFile,
+		CipherSuites:   []string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"},
+		MaxVersion:     "TLS12",
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	clientPair, err := tls.LoadX509KeyPair(clientCert.certFile, clientCert.keyFile)
+	assert.NoError(t, err)
+
+	get := func(certificates []tls.Certificate) error {
+		tlsConfig, err := web.tlsConfig()
+		assert.NoError(t, err)
+
+		server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		server.TLS = tlsConfig
+		server.StartTLS()
+		defer server.Close()
+
+		client := &http.Client{Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: roots, Certificates: certificates},
+		}}
+		res, err := client.Get(server.URL)
+		if err != nil {
+			return err
+		}
+		return res.Body.Close()
+	}
+
+	assert.NoError(t, get([]tls.Certificate{clientPair}))
+	assert.Error(t, get(nil))
+
+	web.ClientAllowedSans = []string{"grafana"}
+	assert.Error(t, get([]tls.Certificate{clientPair}))
+
+	web.ClientAllowedSans = []string{"prometheus"}
+	assert.NoError(t, get([]tls.Certificate{clientPair}))
+
+	web.ClientCAFile = ""
+	_, err = web.tlsConfig()
+	assert.Equal(t, ErrNoClientCAs, err)
+
+	web.ClientCAFile = ca.certFile
+	web.MinVersion = "SSL3"
+	_, err = web.tlsConfig()
+	assert.Error(t, err)
+}
@@ -0,0 +1,228 @@
+/*
+Copyright (c) 2018 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/vmware/govmomi"
+	govsession "github.com/vmware/govmomi/session"
+	"github.com/vmware/govmomi/vim25"
+	"github.com/vmware/govmomi/vim25/methods"
+	"github.com/vmware/govmomi/vim25/soap"
+	"github.com/vmware/govmomi/vim25/types"
+	"github.com/vmware/govmomi/vim25/xml"
+
+	config "github.com/dvonthenen/vsphere-metrics-prometheus/config"
+)
+
+//tokenLifetime is how long the WS-Security headers and the tokens requested
+//from STS are valid
+const tokenLifetime = 5 * time.Minute
+
+//wsTimeFormat is the UTC time format of WS-Security timestamps
+const wsTimeFormat = "2006-01-02T15:04:05.000Z"
+
+var (
+	//ErrCertificateNil - Certificate or extension auth was selected without a client certificate
+	ErrCertificateNil = errors.New("Certificate and extension auth need a client certificate and key")
+
+	//ErrTokenNil - Token auth was selected without a token
+	ErrTokenNil = errors.New("Token auth needs a SAML bearer token")
+)
+
+//wsSecurity is the WS-Security header that carries a SAML token
+type wsSecurity struct {
+	XMLName   xml.Name    `xml:"http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-wssecurity-secext-1.0.xsd Security"`
+	Timestamp wsTimestamp `xml:"http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-wssecurity-utility-1.0.xsd Timestamp"`
+	Token     string      `xml:",innerxml"`
+}
+
+type wsTimestamp struct {
+	Created string `xml:"http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-wssecurity-utility-1.0.xsd Created"`
+	Expires string `xml:"http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-wssecurity-utility-1.0.xsd Expires"`
+}
+
+type tokenHeader struct {
+	Security wsSecurity
+}
+
+//login connects to vCenter with the TLS settings and auth method of cfg
+func (c *Client) login(ctx context.Context, u *url.URL, cfg *config.Config) (*govmomi.Client, error) {
+	soapClient := soap.NewClient(u, cfg.VSphereInsecure)
+	err := configureTLS(soapClient, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	switch config.Auth(cfg.VSphereAuth) {
+	case config.VSphereAuthCertificate:
+		if cfg.VSphereCertificate == nil {
+			return nil, ErrCertificateNil
+		}
+		return loginByCertificate(ctx, soapClient, cfg.VSphereCertificate)
+
+	case config.VSphereAuthExtension:
+		if cfg.VSphereCertificate == nil {
+			return nil, ErrCertificateNil
+		}
+		soapClient.SetCertificate(*cfg.VSphereCertificate)
+
+		client, err := newGovmomiClient(ctx, soapClient)
+		if err != nil {
+			return nil, err
+		}
+		err = client.LoginExtensionByCertificate(ctx, cfg.VSphereExtensionKey, "")
+		if err != nil {
+			return nil, err
+		}
+		return client, nil
+
+	case config.VSphereAuthToken:
+		if cfg.VSphereToken == "" {
+			return nil, ErrTokenNil
+		}
+		return loginByToken(ctx, soapClient, cfg.VSphereToken)
+
+	default:
+		client, err := newGovmomiClient(ctx, soapClient)
+		if err != nil {
+			return nil, err
+		}
+		err = client.Login(ctx, url.UserPassword(cfg.VSphereUser, cfg.VSpherePass))
+		if err != nil {
+			return nil, err
+		}
+		return client, nil
+	}
+}
+
+//newGovmomiClient is govmomi.NewClient without the login, for a SOAP client
+//that has already been set up. The calls are instrumented from the login on.
+func newGovmomiClient(ctx context.Context, soapClient *soap.Client) (*govmomi.Client, error) {
+	vimClient, err := vim25.NewClient(ctx, soapClient)
+	if err != nil {
+		return nil, err
+	}
+	vimClient.RoundTripper = &instrumentedRoundTripper{roundTripper: vimClient.RoundTripper}
+
+	return &govmomi.Client{
+		Client:         vimClient,
+		SessionManager: govsession.NewManager(vimClient),
+	}, nil
+}
+
+//loginByToken logs in with a SAML bearer token. The vendored govmomi has no
+//STS client, so the token goes into the WS-Security header of the
+//LoginByToken request; the session cookie lands in the cookie jar of the
+//SOAP client like it does for a password login.
+func loginByToken(ctx context.Context, soapClient *soap.Client, token string) (*govmomi.Client, error) {
+	client, err := newGovmomiClient(ctx, soapClient)
+	if err != nil {
+		return nil, err
+	}
+
+	// Instrumented like every other call, but with the token header
+	roundTripper := &instrumentedRoundTripper{
+		roundTripper: &tokenRoundTripper{client: soapClient, token: token},
+	}
+	res, err := methods.LoginByToken(ctx, roundTripper, &types.LoginByToken{
+		This: *client.ServiceContent.SessionManager,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	log.Debugln("Logged in by token as", res.Returnval.UserName)
+
+	return client, nil
+}
+
+//tokenRoundTripper sends a SOAP call with a SAML token in the WS-Security
+//header, which soap.Client has no way to add
+type tokenRoundTripper struct {
+	client *soap.Client
+	token  string
+}
+
+//RoundTrip implements soap.RoundTripper
+func (t *tokenRoundTripper) RoundTrip(ctx context.Context, reqBody, resBody soap.HasFault) error {
+	now := time.Now().UTC()
+	reqEnv := soap.Envelope{
+		Header: &tokenHeader{
+			Security: wsSecurity{
+				Timestamp: wsTimestamp{
+					Created: now.Format(wsTimeFormat),
+					Expires: now.Add(tokenLifetime).Format(wsTimeFormat),
+				},
+				Token: t.token,
+			},
+		},
+		Body: reqBody,
+	}
+
+	b, err := xml.Marshal(reqEnv)
+	if err != nil {
+		return err
+	}
+
+	return postEnvelope(ctx, t.client, t.client.URL().String(), fmt.Sprintf("%s/%s", t.client.Namespace, t.client.Version), xml.Header+string(b), resBody)
+}
+
+//postEnvelope posts a SOAP envelope built outside of soap.Client and decodes
+//the response or fault into resBody
+func postEnvelope(ctx context.Context, client *soap.Client, u string, action string, envelope string, resBody soap.HasFault) error {
+	req, err := http.NewRequest("POST", u, strings.NewReader(envelope))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set(`Content-Type`, `text/xml; charset="utf-8"`)
+	req.Header.Set(`SOAPAction`, action)
+
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusInternalServerError {
+		return errors.New(res.Status)
+	}
+
+	resEnv := soap.Envelope{Body: resBody}
+
+	dec := xml.NewDecoder(res.Body)
+	dec.TypeFunc = types.TypeFunc()
+	err = dec.Decode(&resEnv)
+	if err != nil {
+		return err
+	}
+	if f := resBody.Fault(); f != nil {
+		return soap.WrapSoapFault(f)
+	}
+
+	return nil
+}
@@ -0,0 +1,125 @@
+/*
+Copyright (c) 2018 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package vsphere
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	assert "github.com/stretchr/testify/assert"
+
+	"github.com/vmware/govmomi/vim25/soap"
+)
+
+const serviceContentResponse = `<?xml version="1.0" encoding="UTF-8"?>
+<soapenv:Envelope xmlns:soapenv="http://schemas.xmlsoap.org/soap/envelope/">
+<soapenv:Body><RetrieveServiceContentResponse xmlns="urn:vim25"><returnval>
+<rootFolder type="Folder">group-d1</rootFolder>
+<propertyCollector type="PropertyCollector">propertyCollector</propertyCollector>
+<about><name>VMware vCenter Server</name><apiVersion>6.5</apiVersion><apiType>VirtualCenter</apiType></about>
+<sessionManager type="SessionManager">SessionManager</sessionManager>
+</returnval></RetrieveServiceContentResponse></soapenv:Body></soapenv:Envelope>`
+
+const loginByTokenResponse = `<?xml version="1.0" encoding="UTF-8"?>
+<soapenv:Envelope xmlns:soapenv="http://schemas.xmlsoap.org/soap/envelope/">
+<soapenv:Body><LoginByTokenResponse xmlns="urn:vim25"><returnval>
+<key>52a3</key><userName>VSPHERE.LOCAL\monitoring</userName><fullName>monitoring</fullName>
+<loginTime>2018-06-01T12:00:00Z</loginTime><lastActiveTime>2018-06-01T12:00:00Z</lastActiveTime>
+<locale>en</locale><messageLocale>en</messageLocale>
+</returnval></LoginByTokenResponse></soapenv:Body></soapenv:Envelope>`
+
+const invalidLoginResponse = `<?xml version="1.0" encoding="UTF-8"?>
+<soapenv:Envelope xmlns:soapenv="http://schemas.xmlsoap.org/soap/envelope/">
+<soapenv:Body><soapenv:Fault><faultcode>ServerFaultCode</faultcode>
+<faultstring>Cannot complete login due to an incorrect token.</faultstring>
+<detail><InvalidLoginFault xmlns="urn:vim25"/></detail>
+</soapenv:Fault></soapenv:Body></soapenv:Envelope>`
+
+const testToken = `<saml2:Assertion xmlns:saml2="urn:oasis:names:tc:SAML:2.0:assertion" ID="_test"></saml2:Assertion>`
+
+func newTokenServer(t *testing.T, login func(body string) (int, string)) *httptest.Server {
+	return httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, err := ioutil.ReadAll(r.Body)
+		assert.NoError(t, err)
+		body := string(data)
+
+		switch {
+		case strings.Contains(body, "RetrieveServiceContent"):
+			fmt.Fprint(w, serviceContentResponse)
+		case strings.Contains(body, "LoginByToken"):
+			status, response := login(body)
+			if status == http.StatusOK {
+				http.SetCookie(w, &http.Cookie{Name: "vmware_soap_session", Value: "session"})
+			}
+			w.WriteHeader(status)
+			fmt.Fprint(w, response)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func TestLoginByToken(t *testing.T) {
+	var request string
+	server := newTokenServer(t, func(body string) (int, string) {
+		request = body
+		return http.StatusOK, loginByTokenResponse
+	})
+	defer server.Close()
+
+	var before dto.Metric
+	assert.NoError(t, metricSoapDuration.WithLabelValues("LoginByToken").(prometheus.Metric).Write(&before))
+
+	u, _ := url.Parse(server.URL + "/sdk")
+	client, err := loginByToken(context.Background(), soap.NewClient(u, true), testToken)
+	assert.NoError(t, err)
+	assert.NotNil(t, client)
+
+	// The login is timed like the calls of the session
+	var after dto.Metric
+	assert.NoError(t, metricSoapDuration.WithLabelValues("LoginByToken").(prometheus.Metric).Write(&after))
+	assert.Equal(t, before.GetHistogram().GetSampleCount()+1, after.GetHistogram().GetSampleCount())
+
+	// The token is passed verbatim in the WS-Security header
+	assert.Contains(t, request, "oasis-200401-wss-wssecurity-secext-1.0.xsd")
+	assert.Contains(t, request, testToken)
+	assert.Contains(t, request, "Expires")
+	assert.Contains(t, request, `<_this type="SessionManager">SessionManager</_this>`)
+
+	// The session cookie is kept for the following calls
+	assert.Len(t, client.Client.Client.Jar.Cookies(u), 1)
+}
+
+func TestLoginByTokenFault(t *testing.T) {
+	server := newTokenServer(t, func(body string) (int, string) {
+		return http.StatusInternalServerError, invalidLoginResponse
+	})
+	defer server.Close()
+
+	u, _ := url.Parse(server.URL + "/sdk")
+	_, err := loginByToken(context.Background(), soap.NewClient(u, true), testToken)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "incorrect token")
+	assert.Equal(t, 1.0, counterValue(t, metricSoapFaults.WithLabelValues("LoginByToken", "ServerFaultCode")))
+}
@@ -0,0 +1,239 @@
+/*
+Copyright (c) 2018 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/iancoleman/strcase"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+//Entity info metrics carrying the custom attributes and tags as labels
+const (
+	esxInfo       = 304
+	datastoreInfo = 1296
+	vmInfo        = 2320
+)
+
+var (
+	invalidLabelChars   = regexp.MustCompile("[^a-zA-Z0-9_]")
+	repeatedUnderscores = regexp.MustCompile("_+")
+
+	//labels every info metric already has
+	reservedInfoLabels = []string{"datacenter", "name"}
+
+	//customFieldsRefreshInterval limits how often an unknown custom field key
+	//fetches the field definitions again, a value of a deleted field would
+	//otherwise fetch them for every entity
+	customFieldsRefreshInterval = time.Minute
+)
+
+//customAttribute maps a vSphere custom attribute onto a Prometheus label
+type customAttribute struct {
+	Name  string
+	Label string
+}
+
+//parseCustomAttributes parses the allowlist of custom attributes. Entries are
+//comma separated and either just the attribute name, in which case the label
+//name is derived from it, or name=label to pick the label name explicitly.
+func parseCustomAttributes(spec string) ([]customAttribute, error) {
+	var attributes []customAttribute
+	seen := make(map[string]bool)
+	for _, label := range reservedInfoLabels {
+		seen[label] = true
+	}
+
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		attribute := customAttribute{Name: entry}
+		if i := strings.LastIndex(entry, "="); i >= 0 {
+			attribute.Name = strings.TrimSpace(entry[:i])
+			attribute.Label = strings.TrimSpace(entry[i+1:])
+		}
+		attribute.Label = sanitizeLabelName(attribute.Label)
+		if attribute.Label == "" {
+			attribute.Label = sanitizeLabelName(attribute.Name)
+		}
+
+		if attribute.Name == "" || attribute.Label == "" {
+			return nil, fmt.Errorf("invalid custom attribute %q", entry)
+		}
+		if seen[attribute.Label] {
+			return nil, fmt.Errorf("custom attribute %q maps to duplicate label %q", attribute.Name, attribute.Label)
+		}
+		seen[attribute.Label] = true
+
+		attributes = append(attributes, attribute)
+	}
+
+	return attributes, nil
+}
+
+//sanitizeLabelName turns an arbitrary string into a valid Prometheus label name
+func sanitizeLabelName(name string) string {
+	label := invalidLabelChars.ReplaceAllString(strings.TrimSpace(name), "_")
+	label = strcase.ToSnake(label)
+	label = repeatedUnderscores.ReplaceAllString(label, "_")
+	label = strings.Trim(label, "_")
+	if label != "" && label[0] >= '0' && label[0] <= '9' {
+		label = "_" + label
+	}
+	return label
+}
+
+//registerInfoMetric registers the <subsystem>_info metric of a role
+func (c *Client) registerInfoMetric(subsystem string, key int) (*prometheus.GaugeVec, error) {
+	log.Debugln("registerInfoMetric ENTER")
+
+	attributes, err := parseCustomAttributes(c.config.CustomAttributes)
+	if err != nil {
+		log.Debugln("registerInfoMetric LEAVE")
+		return nil, err
+	}
+	c.customAttributes = attributes
+
+	categories, err := parseTagCategories(c.config.TagCategories)
+	if err != nil {
+		log.Debugln("registerInfoMetric LEAVE")
+		return nil, err
+	}
+	c.tagCategories = categories
+
+	labels := append([]string{}, reservedInfoLabels...)
+	for _, attribute := range c.customAttributes {
+		labels = append(labels, attribute.Label)
+	}
+	for _, category := range c.tagCategories {
+		labels = append(labels, category.Label)
+	}
+
+	metricName := fmt.Sprintf("%d_info", key)
+	log.Debugln("Key:", metricName)
+
+	myMetric := prometheus.NewGaugeVec(
+		c.gaugeOpts(subsystem, metricName, "info", "Information about the "+subsystem+" with its custom attributes and tags as labels"),
+		labels,
+	)
+	c.registry.MustRegister(myMetric)
+
+	log.Debugln("registerInfoMetric Succeeded")
+	log.Debugln("registerInfoMetric LEAVE")
+
+	return myMetric, nil
+}
+
+//setInfoMetric publishes the info series of an entity along with its custom
+//attributes and tags
+func (c *Client) setInfoMetric(s *session, myMetric *prometheus.GaugeVec, datacenterStr string, name string, ref types.ManagedObjectReference, values []types.BaseCustomFieldValue) {
+	if myMetric == nil {
+		return
+	}
+
+	fields := c.customFieldValues(s, values)
+	tags := tagValues(c.tags.Tags(ref))
+
+	labels := []string{datacenterStr, name}
+	for _, attribute := range c.customAttributes {
+		labels = append(labels, fields[attribute.Name])
+	}
+	for _, category := range c.tagCategories {
+		labels = append(labels, tags[category.Name])
+	}
+
+	// Only ever report the entity of the current scrape
+	myMetric.Reset()
+	myMetric.WithLabelValues(labels...).Set(1)
+}
+
+//customFieldValues resolves custom field keys to their names. The field
+//definitions are looked up once and refreshed when an unknown key shows up,
+//at most once per customFieldsRefreshInterval.
+func (c *Client) customFieldValues(s *session, values []types.BaseCustomFieldValue) map[string]string {
+	fields := make(map[string]string)
+	if len(c.customAttributes) == 0 || len(values) == 0 {
+		return fields
+	}
+
+	c.customFieldsMutex.Lock()
+	defer c.customFieldsMutex.Unlock()
+
+	for _, baseValue := range values {
+		value, ok := baseValue.(*types.CustomFieldStringValue)
+		if !ok {
+			continue
+		}
+
+		name, ok := c.customFields[value.Key]
+		if !ok && time.Since(c.customFieldsAt) >= customFieldsRefreshInterval {
+			c.customFieldsAt = time.Now()
+			err := c.resolveCustomFields(s)
+			if err != nil {
+				log.Warnln("resolveCustomFields failed:", err)
+			}
+			name, ok = c.customFields[value.Key]
+		}
+		if !ok {
+			continue
+		}
+
+		fields[name] = value.Value
+	}
+
+	return fields
+}
+
+//resolveCustomFields fetches the field definitions. The known ones are kept
+//when that fails.
+func (c *Client) resolveCustomFields(s *session) error {
+	log.Debugln("resolveCustomFields ENTER")
+
+	manager, err := object.GetCustomFieldsManager(s.client.Client)
+	if err != nil {
+		log.Debugln("resolveCustomFields LEAVE")
+		return err
+	}
+
+	definitions, err := manager.Field(s.ctx)
+	if err != nil {
+		log.Debugln("resolveCustomFields LEAVE")
+		return err
+	}
+
+	c.customFields = make(map[int32]string)
+	for _, definition := range definitions {
+		c.customFields[definition.Key] = definition.Name
+	}
+
+	log.Debugln("resolveCustomFields Succeeded")
+	log.Debugln("resolveCustomFields LEAVE")
+
+	return nil
+}
@@ -0,0 +1,86 @@
+/*
+Copyright (c) 2018 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package vsphere
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	assert "github.com/stretchr/testify/assert"
+
+	"github.com/vmware/govmomi/vim25/soap"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+func TestParseCustomAttributes(t *testing.T) {
+	attributes, err := parseCustomAttributes("Owner, Cost Center=cost_center ,Environment.Tier,2nd-Contact")
+	assert.NoError(t, err)
+	assert.Equal(t, []customAttribute{
+		{Name: "Owner", Label: "owner"},
+		{Name: "Cost Center", Label: "cost_center"},
+		{Name: "Environment.Tier", Label: "environment_tier"},
+		{Name: "2nd-Contact", Label: "_2nd_contact"},
+	}, attributes)
+
+	attributes, err = parseCustomAttributes("")
+	assert.NoError(t, err)
+	assert.Empty(t, attributes)
+
+	_, err = parseCustomAttributes("Name")
+	assert.Error(t, err)
+
+	_, err = parseCustomAttributes("Owner,owner")
+	assert.Error(t, err)
+
+	_, err = parseCustomAttributes("=owner")
+	assert.Error(t, err)
+}
+
+func TestCustomFieldValues(t *testing.T) {
+	fetches := 0
+	s := newFakeSession(&fakeVCenter{
+		properties: func(ctx context.Context, obj types.ManagedObjectReference) []types.DynamicProperty {
+			fetches++
+			return []types.DynamicProperty{{Name: "field", Val: types.ArrayOfCustomFieldDef{
+				CustomFieldDef: []types.CustomFieldDef{{Key: 1, Name: "Owner"}},
+			}}}
+		},
+		call: func(ctx context.Context, req, res soap.HasFault) error {
+			return errors.New("unexpected call")
+		},
+	})
+	s.client.ServiceContent.CustomFieldsManager = &types.ManagedObjectReference{Type: "CustomFieldsManager", Value: "CustomFieldsManager"}
+
+	c := &Client{customAttributes: []customAttribute{{Name: "Owner", Label: "owner"}}}
+	value := func(key int32, text string) types.BaseCustomFieldValue {
+		return &types.CustomFieldStringValue{CustomFieldValue: types.CustomFieldValue{Key: key}, Value: text}
+	}
+
+	assert.Equal(t, map[string]string{"Owner": "alice"}, c.customFieldValues(s, []types.BaseCustomFieldValue{value(1, "alice")}))
+	assert.Equal(t, 1, fetches)
+
+	// The value of a deleted field does not fetch the definitions for every entity
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, map[string]string{"Owner": "bob"}, c.customFieldValues(s, []types.BaseCustomFieldValue{value(1, "bob"), value(2, "gone")}))
+	}
+	assert.Equal(t, 1, fetches)
+
+	c.customFieldsAt = time.Now().Add(-customFieldsRefreshInterval)
+	c.customFieldValues(s, []types.BaseCustomFieldValue{value(2, "gone")})
+	assert.Equal(t, 2, fetches)
+}
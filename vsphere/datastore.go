@@ -17,6 +17,7 @@ limitations under the License.
 package vsphere
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 
@@ -37,11 +38,6 @@ const (
 	datastoreProvisioned
 )
 
-var (
-	metricsMapDatastore = make(map[int]*prometheus.GaugeVec)
-	//metricsMapDatastore = make(map[int]*prometheus.Desc)
-)
-
 func (c *Client) registerDatastoreMetrics() error {
 	log.Debugln("registerDatastoreMetrics ENTER")
 
@@ -50,80 +46,89 @@ func (c *Client) registerDatastoreMetrics() error {
 	log.Debugln("Key:", metricName)
 
 	myMetric := prometheus.NewGaugeVec(
-		prometheus.GaugeOpts{
-			Namespace: "vsphere",
-			Subsystem: "datastore",
-			Name:      metricName,
-			Help:      metricName,
-		},
+		c.gaugeOpts("datastore", metricName, "free_space_bytes", "Free space of the datastore"),
 		[]string{"datacenter"},
 	)
-	metricsMapDatastore[datastoreFreespace] = myMetric
-	prometheus.MustRegister(myMetric)
+	c.metricsMapDatastore[datastoreFreespace] = myMetric
+	c.registry.MustRegister(myMetric)
 
 	//uncommitted
 	metricName = fmt.Sprintf("%d_uncommitted_size", datastoreUncommitted)
 	log.Debugln("Key:", metricName)
 
 	myMetric = prometheus.NewGaugeVec(
-		prometheus.GaugeOpts{
-			Namespace: "vsphere",
-			Subsystem: "datastore",
-			Name:      metricName,
-			Help:      metricName,
-		},
+		c.gaugeOpts("datastore", metricName, "uncommitted_bytes", "Additional space the thin provisioned disks on the datastore can still claim"),
 		[]string{"datacenter"},
 	)
-	metricsMapDatastore[datastoreUncommitted] = myMetric
-	prometheus.MustRegister(myMetric)
+	c.metricsMapDatastore[datastoreUncommitted] = myMetric
+	c.registry.MustRegister(myMetric)
 
 	//usedspace
 	metricName = fmt.Sprintf("%d_usedspace_size", datastoreUsedSpace)
 	log.Debugln("Key:", metricName)
 
 	myMetric = prometheus.NewGaugeVec(
-		prometheus.GaugeOpts{
-			Namespace: "vsphere",
-			Subsystem: "datastore",
-			Name:      metricName,
-			Help:      metricName,
-		},
+		c.gaugeOpts("datastore", metricName, "used_space_bytes", "Used space of the datastore"),
 		[]string{"datacenter"},
 	)
-	metricsMapDatastore[datastoreUsedSpace] = myMetric
-	prometheus.MustRegister(myMetric)
+	c.metricsMapDatastore[datastoreUsedSpace] = myMetric
+	c.registry.MustRegister(myMetric)
 
 	//capacity
 	metricName = fmt.Sprintf("%d_capacity_size", datastoreCapacity)
 	log.Debugln("Key:", metricName)
 
 	myMetric = prometheus.NewGaugeVec(
-		prometheus.GaugeOpts{
-			Namespace: "vsphere",
-			Subsystem: "datastore",
-			Name:      metricName,
-			Help:      metricName,
-		},
+		c.gaugeOpts("datastore", metricName, "capacity_bytes", "Capacity of the datastore"),
 		[]string{"datacenter"},
 	)
-	metricsMapDatastore[datastoreCapacity] = myMetric
-	prometheus.MustRegister(myMetric)
+	c.metricsMapDatastore[datastoreCapacity] = myMetric
+	c.registry.MustRegister(myMetric)
 
 	//provisioned
 	metricName = fmt.Sprintf("%d_provisioned_size", datastoreProvisioned)
 	log.Debugln("Key:", metricName)
 
 	myMetric = prometheus.NewGaugeVec(
-		prometheus.GaugeOpts{
-			Namespace: "vsphere",
-			Subsystem: "datastore",
-			Name:      metricName,
-			Help:      metricName,
-		},
+		c.gaugeOpts("datastore", metricName, "provisioned_bytes", "Space provisioned on the datastore, used plus uncommitted"),
 		[]string{"datacenter"},
 	)
-	metricsMapDatastore[datastoreProvisioned] = myMetric
-	prometheus.MustRegister(myMetric)
+	c.metricsMapDatastore[datastoreProvisioned] = myMetric
+	c.registry.MustRegister(myMetric)
+
+	myMetric, err := c.registerInfoMetric("datastore", datastoreInfo)
+	if err != nil {
+		log.Debugln("registerInfoMetric Failed:", err)
+		log.Debugln("registerDatastoreMetrics LEAVE")
+		return err
+	}
+	c.metricsMapDatastore[datastoreInfo] = myMetric
+
+	err = c.registerDatastoreScanMetrics()
+	if err != nil {
+		log.Debugln("registerDatastoreScanMetrics Failed:", err)
+		log.Debugln("registerDatastoreMetrics LEAVE")
+		return err
+	}
+
+	c.startDatastoreScan()
+
+	// Perf counters need a connection to vCenter at startup so are opt-in
+	if c.config.DatastorePerf {
+		s, err := c.getSession(context.Background())
+		if err != nil {
+			log.Errorln("getSession failed:", err)
+			log.Debugln("registerDatastoreMetrics LEAVE")
+			return err
+		}
+
+		err = c.registerPerfMetrics(s, "datastore", c.config.DatastorePerfSamples, c.metricsMapDatastorePerf, c.perfCountersDatastore)
+		if err != nil {
+			log.Debugln("registerPerfMetrics Failed:", err)
+			log.Debugln("registerDatastoreMetrics LEAVE")
+			return err
+		}
+	}
 
 	/*
 		labels := []string{"datacenter", "datastore"}
@@ -132,31 +137,31 @@ func (c *Client) registerDatastoreMetrics() error {
 		metricName := fmt.Sprintf("%d_freespace_size", datastoreFreespace)
 		log.Debugln("Key:", metricName)
 		myMetric := prometheus.NewDesc(metricName, "freespace size", labels, nil)
-		metricsMapDatastore[int(datastoreFreespace)] = myMetric
+		c.metricsMapDatastore[int(datastoreFreespace)] = myMetric
 
 		//uncommitted
 		metricName = fmt.Sprintf("%d_uncommitted_size", datastoreUncommitted)
 		log.Debugln("Key:", metricName)
 		myMetric = prometheus.NewDesc(metricName, "uncommitted size", labels, nil)
-		metricsMapDatastore[int(datastoreUncommitted)] = myMetric
+		c.metricsMapDatastore[int(datastoreUncommitted)] = myMetric
 
 		//usedspace
 		metricName = fmt.Sprintf("%d_usedspace_size", datastoreUsedSpace)
 		log.Debugln("Key:", metricName)
 		myMetric = prometheus.NewDesc(metricName, "usedspace size", labels, nil)
-		metricsMapDatastore[int(datastoreUsedSpace)] = myMetric
+		c.metricsMapDatastore[int(datastoreUsedSpace)] = myMetric
 
 		//capacity
 		metricName = fmt.Sprintf("%d_capacity_size", datastoreCapacity)
 		log.Debugln("Key:", metricName)
 		myMetric = prometheus.NewDesc(metricName, "capacity size", labels, nil)
-		metricsMapDatastore[int(datastoreCapacity)] = myMetric
+		c.metricsMapDatastore[int(datastoreCapacity)] = myMetric
 
 		//provisioned
 		metricName = fmt.Sprintf("%d_provisioned_size", datastoreProvisioned)
 		log.Debugln("Key:", metricName)
 		myMetric = prometheus.NewDesc(metricName, "provisioned_size", labels, nil)
-		metricsMapDatastore[int(datastoreProvisioned)] = myMetric
+		c.metricsMapDatastore[int(datastoreProvisioned)] = myMetric
 	*/
 
 	log.Debugln("registerDatastoreMetrics Succeeded")
@@ -175,31 +180,34 @@ func (c *Client) GetVSphereDatastoreStats(w http.ResponseWriter, r *http.Request
 	datastoreStr := vars["datastore"]
 	log.Infoln("DS:", datastoreStr)
 
+	ctx, cancel := c.scrapeContext(r)
+	defer c.endScrape(ctx, cancel)
+
 	// Create client
-	err := c.getClient()
+	s, err := c.getSession(ctx)
 	if err != nil {
-		http.Error(w, "Unable connect to the vCenter Server", http.StatusGone)
-		log.Errorln("getClient failed:", err)
+		err = scrapeError(ctx, w, err, "Unable connect to the vCenter Server", http.StatusGone)
+		log.Errorln("getSession failed:", err)
 		log.Debugln("GetVSphereDatastoreStats LEAVE")
 
 		return err
 	}
 
 	//find our objects
-	finder := find.NewFinder(c.vClient.Client, false)
+	finder := find.NewFinder(s.client.Client, false)
 
-	dc, err := finder.Datacenter(*c.ctx, datacenterStr)
+	dc, err := finder.Datacenter(s.ctx, datacenterStr)
 	if err != nil {
-		http.Error(w, "Unable find the Datacener", http.StatusGone)
+		err = scrapeError(ctx, w, err, "Unable find the Datacener", http.StatusGone)
 		log.Errorln("finder.Datacenter(", datacenterStr, "):", err)
 		log.Debugln("GetVSphereDatastoreStats LEAVE")
 		return err
 	}
 	finder.SetDatacenter(dc)
 
-	datastore, err := finder.Datastore(*c.ctx, datastoreStr)
+	datastore, err := finder.Datastore(s.ctx, datastoreStr)
 	if err != nil {
-		http.Error(w, "Unable find the Datastore", http.StatusGone)
+		err = scrapeError(ctx, w, err, "Unable find the Datastore", http.StatusGone)
 		log.Errorln("finder.Datastore(", datastoreStr, "):", err)
 		log.Debugln("GetVSphereDatastoreStats LEAVE")
 		return err
@@ -209,9 +217,9 @@ func (c *Client) GetVSphereDatastoreStats(w http.ResponseWriter, r *http.Request
 	log.Infoln("Datastore:", datastore.InventoryPath)
 
 	var oDatastore mo.Datastore
-	err = datastore.Properties(*c.ctx, datastore.Reference(), []string{"summary"}, &oDatastore)
+	err = datastore.Properties(s.ctx, datastore.Reference(), []string{"summary", "customValue"}, &oDatastore)
 	if err != nil {
-		http.Error(w, "Unable get the Datastore properties", http.StatusBadRequest)
+		err = scrapeError(ctx, w, err, "Unable get the Datastore properties", http.StatusBadRequest)
 		log.Errorln("datastore.Properties(", datastoreStr, "):", err)
 		log.Debugln("GetVSphereDatastoreStats LEAVE")
 		return err
@@ -221,49 +229,72 @@ func (c *Client) GetVSphereDatastoreStats(w http.ResponseWriter, r *http.Request
 	log.Infoln(oDatastore.Summary.Name)
 	log.Infoln(oDatastore.Summary.Type)
 
-	myMetric := metricsMapDatastore[datastoreFreespace]
+	entity := entityInfo{
+		Path: datastore.InventoryPath,
+		Name: datastore.Name(),
+	}
+
+	err = c.filterEntity(w, datastore.Reference(), &entity)
+	if err != nil {
+		log.Debugln("GetVSphereDatastoreStats LEAVE")
+		return err
+	}
+
+	c.setInfoMetric(s, c.metricsMapDatastore[datastoreInfo], datacenterStr, datastore.Name(), datastore.Reference(), oDatastore.CustomValue)
+	c.setTagMetrics(datastore.Name(), datastore.Reference())
+
+	myMetric := c.metricsMapDatastore[datastoreFreespace]
 	if myMetric != nil {
 		myMetric.WithLabelValues(datacenterStr).Set(float64(oDatastore.Summary.FreeSpace))
 	}
-	myMetric = metricsMapDatastore[datastoreUncommitted]
+	myMetric = c.metricsMapDatastore[datastoreUncommitted]
 	if myMetric != nil {
 		myMetric.WithLabelValues(datacenterStr).Set(float64(oDatastore.Summary.Uncommitted))
 	}
-	myMetric = metricsMapDatastore[datastoreUsedSpace]
+	myMetric = c.metricsMapDatastore[datastoreUsedSpace]
 	if myMetric != nil {
 		myMetric.WithLabelValues(datacenterStr).Set(float64((oDatastore.Summary.Capacity - oDatastore.Summary.FreeSpace)))
 	}
-	myMetric = metricsMapDatastore[datastoreCapacity]
+	myMetric = c.metricsMapDatastore[datastoreCapacity]
 	if myMetric != nil {
 		myMetric.WithLabelValues(datacenterStr).Set(float64(oDatastore.Summary.Capacity))
 	}
-	myMetric = metricsMapDatastore[datastoreProvisioned]
+	myMetric = c.metricsMapDatastore[datastoreProvisioned]
 	if myMetric != nil {
 		myMetric.WithLabelValues(datacenterStr).Set(float64((oDatastore.Summary.Capacity - oDatastore.Summary.FreeSpace + oDatastore.Summary.Uncommitted)))
 	}
 
+	c.setDatastoreScanMetrics(datacenterStr, dc.Name(), datastore.Name())
+
+	if c.config.DatastorePerf {
+		err = c.setPerfMetrics(s, datacenterStr, datastore.Reference(), oDatastore.Summary.Type, c.config.DatastorePerfInterval, c.config.DatastorePerfSamples, c.metricsMapDatastorePerf, c.perfCountersDatastore)
+		if err != nil {
+			log.Errorln("setPerfMetrics(", datastoreStr, "):", err)
+		}
+	}
+
 	/*
-		myMetric := metricsMapDatastore[datastoreFreespace]
+		myMetric := c.metricsMapDatastore[datastoreFreespace]
 		if myMetric != nil {
 			prometheus.MustNewConstMetric(myMetric, prometheus.GaugeValue, float64(oDatastore.Summary.FreeSpace),
 				datacenterStr, datastoreStr)
 		}
-		myMetric = metricsMapDatastore[datastoreUncommitted]
+		myMetric = c.metricsMapDatastore[datastoreUncommitted]
 		if myMetric != nil {
 			prometheus.MustNewConstMetric(myMetric, prometheus.GaugeValue, float64(oDatastore.Summary.Uncommitted),
 				datacenterStr, datastoreStr)
 		}
-		myMetric = metricsMapDatastore[datastoreUsedSpace]
+		myMetric = c.metricsMapDatastore[datastoreUsedSpace]
 		if myMetric != nil {
 			prometheus.MustNewConstMetric(myMetric, prometheus.GaugeValue, float64((oDatastore.Summary.Capacity - oDatastore.Summary.FreeSpace)),
 				datacenterStr, datastoreStr)
 		}
-		myMetric = metricsMapDatastore[datastoreCapacity]
+		myMetric = c.metricsMapDatastore[datastoreCapacity]
 		if myMetric != nil {
 			prometheus.MustNewConstMetric(myMetric, prometheus.GaugeValue, float64(oDatastore.Summary.Capacity),
 				datacenterStr, datastoreStr)
 		}
-		myMetric = metricsMapDatastore[datastoreProvisioned]
+		myMetric = c.metricsMapDatastore[datastoreProvisioned]
 		if myMetric != nil {
 			prometheus.MustNewConstMetric(myMetric, prometheus.GaugeValue, float64((oDatastore.Summary.Capacity - oDatastore.Summary.FreeSpace + oDatastore.Summary.Uncommitted)),
 				datacenterStr, datastoreStr)
@@ -0,0 +1,417 @@
+/*
+Copyright (c) 2018 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/vmware/govmomi/find"
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/property"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+const (
+	datastoreScanFileSize = (iota + 1280)
+	datastoreScanOrphanedCount
+	datastoreScanOrphanedSize
+	datastoreScanTimestamp
+)
+
+//File categories used by the datastore file scan
+const (
+	datastoreFileVmdk  = "vmdk"
+	datastoreFileIso   = "iso"
+	datastoreFileLog   = "log"
+	datastoreFileVswp  = "vswp"
+	datastoreFileOther = "other"
+)
+
+var (
+	datastoreFileCategories = []string{datastoreFileVmdk, datastoreFileIso, datastoreFileLog, datastoreFileVswp, datastoreFileOther}
+
+	//extents and sidecars that belong to a vmdk descriptor
+	vmdkExtentSuffixes = []string{"-flat", "-delta", "-sesparse", "-ctk", "-rdm", "-rdmp"}
+
+	//datastoreScanTimeout bounds the scan of a single datastore
+	datastoreScanTimeout = 30 * time.Minute
+)
+
+//datastoreScanResult is the cached outcome of scanning a single datastore
+type datastoreScanResult struct {
+	FileSize      map[string]int64
+	OrphanedCount int
+	OrphanedSize  int64
+	Timestamp     time.Time
+}
+
+func datastoreScanKey(datacenter, datastore string) string {
+	return datacenter + "/" + datastore
+}
+
+func (c *Client) registerDatastoreScanMetrics() error {
+	log.Debugln("registerDatastoreScanMetrics ENTER")
+
+	//file size by category
+	metricName := fmt.Sprintf("%d_scan_file_size", datastoreScanFileSize)
+	log.Debugln("Key:", metricName)
+
+	myMetric := prometheus.NewGaugeVec(
+		c.gaugeOpts("datastore", metricName, "scan_file_bytes", "Size of the files found by the last scan per file type"),
+		[]string{"datacenter", "type"},
+	)
+	c.metricsMapDatastore[datastoreScanFileSize] = myMetric
+	c.registry.MustRegister(myMetric)
+
+	//orphaned vmdk count
+	metricName = fmt.Sprintf("%d_scan_orphaned_vmdk_count", datastoreScanOrphanedCount)
+	log.Debugln("Key:", metricName)
+
+	myMetric = prometheus.NewGaugeVec(
+		c.gaugeOpts("datastore", metricName, "scan_orphaned_vmdks", "Number of VMDKs not referenced by any registered VM"),
+		[]string{"datacenter"},
+	)
+	c.metricsMapDatastore[datastoreScanOrphanedCount] = myMetric
+	c.registry.MustRegister(myMetric)
+
+	//orphaned vmdk size
+	metricName = fmt.Sprintf("%d_scan_orphaned_vmdk_size", datastoreScanOrphanedSize)
+	log.Debugln("Key:", metricName)
+
+	myMetric = prometheus.NewGaugeVec(
+		c.gaugeOpts("datastore", metricName, "scan_orphaned_vmdk_bytes", "Size of the VMDKs not referenced by any registered VM"),
+		[]string{"datacenter"},
+	)
+	c.metricsMapDatastore[datastoreScanOrphanedSize] = myMetric
+	c.registry.MustRegister(myMetric)
+
+	//time of the last completed scan
+	metricName = fmt.Sprintf("%d_scan_timestamp_seconds", datastoreScanTimestamp)
+	log.Debugln("Key:", metricName)
+
+	myMetric = prometheus.NewGaugeVec(
+		c.gaugeOpts("datastore", metricName, "scan_timestamp_seconds", "Time the last scan of the datastore completed"),
+		[]string{"datacenter"},
+	)
+	c.metricsMapDatastore[datastoreScanTimestamp] = myMetric
+	c.registry.MustRegister(myMetric)
+
+	log.Debugln("registerDatastoreScanMetrics Succeeded")
+	log.Debugln("registerDatastoreScanMetrics LEAVE")
+
+	return nil
+}
+
+//setDatastoreScanMetrics publishes the cached scan for the datastore. The
+//series are cleared when the datastore has not been scanned yet so values
+//from another datastore are never reported.
+func (c *Client) setDatastoreScanMetrics(datacenterStr string, datacenterName string, datastoreName string) {
+	c.datastoreScanMutex.RLock()
+	result := c.datastoreScanCache[datastoreScanKey(datacenterName, datastoreName)]
+	c.datastoreScanMutex.RUnlock()
+
+	if result == nil {
+		log.Debugln("No datastore scan available for", datastoreName)
+		for _, key := range []int{datastoreScanFileSize, datastoreScanOrphanedCount, datastoreScanOrphanedSize, datastoreScanTimestamp} {
+			myMetric := c.metricsMapDatastore[key]
+			if myMetric != nil {
+				myMetric.Reset()
+			}
+		}
+		return
+	}
+
+	myMetric := c.metricsMapDatastore[datastoreScanFileSize]
+	if myMetric != nil {
+		for _, category := range datastoreFileCategories {
+			myMetric.WithLabelValues(datacenterStr, category).Set(float64(result.FileSize[category]))
+		}
+	}
+	myMetric = c.metricsMapDatastore[datastoreScanOrphanedCount]
+	if myMetric != nil {
+		myMetric.WithLabelValues(datacenterStr).Set(float64(result.OrphanedCount))
+	}
+	myMetric = c.metricsMapDatastore[datastoreScanOrphanedSize]
+	if myMetric != nil {
+		myMetric.WithLabelValues(datacenterStr).Set(float64(result.OrphanedSize))
+	}
+	myMetric = c.metricsMapDatastore[datastoreScanTimestamp]
+	if myMetric != nil {
+		myMetric.WithLabelValues(datacenterStr).Set(float64(result.Timestamp.Unix()))
+	}
+}
+
+//startDatastoreScan runs the datastore file scan in the background on the
+//configured interval. Datastores are scanned one at a time with a pause in
+//between to keep the load on vCenter and the hosts low.
+func (c *Client) startDatastoreScan() {
+	if c.config.DatastoreScanInterval <= 0 {
+		log.Infoln("Datastore file scan disabled")
+		return
+	}
+
+	log.Infoln("Datastore file scan every", c.config.DatastoreScanInterval)
+
+	// Close cancels a scan in progress as well
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-c.stop
+		cancel()
+	}()
+
+	c.loops.Add(1)
+	go func() {
+		defer c.loops.Done()
+		for {
+			err := c.scanDatastores(ctx)
+			if err != nil {
+				log.Errorln("scanDatastores failed:", err)
+			}
+
+			select {
+			case <-c.stop:
+				return
+			case <-time.After(c.currentConfig().DatastoreScanInterval):
+			}
+		}
+	}()
+}
+
+func (c *Client) scanDatastores(ctx context.Context) error {
+	log.Debugln("scanDatastores ENTER")
+
+	// Create client
+	s, err := c.getSession(ctx)
+	if err != nil {
+		log.Errorln("getSession failed:", err)
+		log.Debugln("scanDatastores LEAVE")
+		return err
+	}
+
+	finder := find.NewFinder(s.client.Client, false)
+
+	dcs, err := finder.DatacenterList(s.ctx, "*")
+	if err != nil {
+		log.Errorln("finder.DatacenterList failed:", err)
+		log.Debugln("scanDatastores LEAVE")
+		return err
+	}
+
+	for _, dc := range dcs {
+		finder.SetDatacenter(dc)
+
+		datastores, err := finder.DatastoreList(s.ctx, "*")
+		if err != nil {
+			log.Warnln("finder.DatastoreList(", dc.Name(), "):", err)
+			continue
+		}
+
+		for _, datastore := range datastores {
+			scanCtx, cancel := context.WithTimeout(ctx, datastoreScanTimeout)
+			result, err := c.scanDatastore(s.withContext(scanCtx), datastore)
+			cancel()
+			if err != nil {
+				log.Warnln("scanDatastore(", datastore.Name(), "):", err)
+			} else {
+				c.datastoreScanMutex.Lock()
+				c.datastoreScanCache[datastoreScanKey(dc.Name(), datastore.Name())] = result
+				c.datastoreScanMutex.Unlock()
+			}
+
+			select {
+			case <-ctx.Done():
+				log.Debugln("scanDatastores LEAVE")
+				return ctx.Err()
+			case <-time.After(c.currentConfig().DatastoreScanDelay):
+			}
+		}
+	}
+
+	log.Debugln("scanDatastores Succeeded")
+	log.Debugln("scanDatastores LEAVE")
+
+	return nil
+}
+
+func (c *Client) scanDatastore(s *session, datastore *object.Datastore) (*datastoreScanResult, error) {
+	log.Debugln("scanDatastore ENTER")
+	log.Infoln("Scanning datastore:", datastore.InventoryPath)
+
+	var oDatastore mo.Datastore
+	err := datastore.Properties(s.ctx, datastore.Reference(), []string{"browser", "vm"}, &oDatastore)
+	if err != nil {
+		log.Debugln("scanDatastore LEAVE")
+		return nil, err
+	}
+
+	//every file registered VMs on this datastore know about
+	referenced := make(map[string]bool)
+	if len(oDatastore.Vm) > 0 {
+		var oVMs []mo.VirtualMachine
+		pc := property.DefaultCollector(s.client.Client)
+		err = pc.Retrieve(s.ctx, oDatastore.Vm, []string{"layoutEx"}, &oVMs)
+		if err != nil {
+			log.Debugln("scanDatastore LEAVE")
+			return nil, err
+		}
+
+		for _, oVM := range oVMs {
+			if oVM.LayoutEx == nil {
+				continue
+			}
+			for _, file := range oVM.LayoutEx.File {
+				referenced[normalizeDatastorePath(file.Name)] = true
+			}
+		}
+	}
+
+	browser := object.NewHostDatastoreBrowser(s.client.Client, oDatastore.Browser)
+	spec := types.HostDatastoreBrowserSearchSpec{
+		Details: &types.FileQueryFlags{
+			FileType:  true,
+			FileSize:  true,
+			FileOwner: types.NewBool(false),
+		},
+	}
+
+	task, err := browser.SearchDatastoreSubFolders(s.ctx, datastore.Path(""), &spec)
+	if err != nil {
+		log.Debugln("scanDatastore LEAVE")
+		return nil, err
+	}
+
+	info, err := task.WaitForResult(s.ctx, nil)
+	if err != nil {
+		log.Debugln("scanDatastore LEAVE")
+		return nil, err
+	}
+
+	results, ok := info.Result.(types.ArrayOfHostDatastoreBrowserSearchResults)
+	if !ok {
+		log.Debugln("scanDatastore LEAVE")
+		return nil, fmt.Errorf("unexpected search result %T", info.Result)
+	}
+
+	result := summarizeDatastoreFiles(results.HostDatastoreBrowserSearchResults, referenced)
+
+	log.Debugln("scanDatastore Succeeded")
+	log.Debugln("scanDatastore LEAVE")
+
+	return result, nil
+}
+
+//summarizeDatastoreFiles sums the search results by file category and counts
+//the vmdk descriptors that no registered VM references. Disks vSphere manages
+//without a VM are never counted as orphaned.
+func summarizeDatastoreFiles(results []types.HostDatastoreBrowserSearchResults, referenced map[string]bool) *datastoreScanResult {
+	result := &datastoreScanResult{
+		FileSize:  make(map[string]int64),
+		Timestamp: time.Now(),
+	}
+
+	for _, folder := range results {
+		var folderPath object.DatastorePath
+		if !folderPath.FromString(folder.FolderPath) {
+			continue
+		}
+
+		for _, baseFile := range folder.File {
+			file := baseFile.GetFileInfo()
+
+			category := datastoreFileCategory(file.Path)
+			result.FileSize[category] += file.FileSize
+
+			if category != datastoreFileVmdk || managedDatastoreFolder(folderPath.Path) {
+				continue
+			}
+
+			filePath := object.DatastorePath{
+				Datastore: folderPath.Datastore,
+				Path:      path.Join(folderPath.Path, file.Path),
+			}
+			fullPath := filePath.String()
+			descriptor := vmdkDescriptor(fullPath)
+			if referenced[fullPath] || referenced[descriptor] {
+				continue
+			}
+
+			result.OrphanedSize += file.FileSize
+			if descriptor == fullPath {
+				result.OrphanedCount++
+			}
+		}
+	}
+
+	return result
+}
+
+//managedDatastoreFolder tells whether a folder holds disks that are not
+//attached through a VM's layoutEx: first class disks, e.g. CNS and Kubernetes
+//persistent volumes in fcd/, and content library items in contentlib-*/
+func managedDatastoreFolder(folder string) bool {
+	top := strings.SplitN(strings.TrimPrefix(folder, "/"), "/", 2)[0]
+	return top == "fcd" || strings.HasPrefix(top, "contentlib-")
+}
+
+func datastoreFileCategory(name string) string {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".vmdk":
+		return datastoreFileVmdk
+	case ".iso":
+		return datastoreFileIso
+	case ".log":
+		return datastoreFileLog
+	case ".vswp":
+		return datastoreFileVswp
+	}
+
+	return datastoreFileOther
+}
+
+//vmdkDescriptor maps an extent such as disk-flat.vmdk to its disk.vmdk descriptor
+func vmdkDescriptor(name string) string {
+	base := strings.TrimSuffix(name, ".vmdk")
+	for _, suffix := range vmdkExtentSuffixes {
+		if strings.HasSuffix(base, suffix) {
+			return strings.TrimSuffix(base, suffix) + ".vmdk"
+		}
+	}
+
+	return name
+}
+
+func normalizeDatastorePath(name string) string {
+	var p object.DatastorePath
+	if !p.FromString(name) {
+		return name
+	}
+
+	if p.Path != "" {
+		p.Path = path.Clean(p.Path)
+	}
+
+	return p.String()
+}
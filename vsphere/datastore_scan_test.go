@@ -0,0 +1,89 @@
+/*
+Copyright (c) 2018 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package vsphere
+
+import (
+	"testing"
+
+	assert "github.com/stretchr/testify/assert"
+
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+func TestSummarizeDatastoreFiles(t *testing.T) {
+	results := []types.HostDatastoreBrowserSearchResults{
+		{
+			FolderPath: "[ds1] vm1/",
+			File: []types.BaseFileInfo{
+				&types.VmDiskFileInfo{FileInfo: types.FileInfo{Path: "vm1.vmdk", FileSize: 1}},
+				&types.FileInfo{Path: "vm1-flat.vmdk", FileSize: 100},
+				&types.FileInfo{Path: "vm1-ctk.vmdk", FileSize: 2},
+				&types.FileInfo{Path: "vmware.log", FileSize: 10},
+				&types.FileInfo{Path: "vm1-abc.vswp", FileSize: 50},
+			},
+		},
+		{
+			FolderPath: "[ds1] old",
+			File: []types.BaseFileInfo{
+				&types.FileInfo{Path: "old.vmdk", FileSize: 1},
+				&types.FileInfo{Path: "old-flat.vmdk", FileSize: 200},
+			},
+		},
+		{
+			FolderPath: "[ds1]",
+			File: []types.BaseFileInfo{
+				&types.FileInfo{Path: "install.iso", FileSize: 30},
+				&types.FileInfo{Path: "notes.txt", FileSize: 5},
+			},
+		},
+		{
+			FolderPath: "[ds1] fcd",
+			File: []types.BaseFileInfo{
+				&types.FileInfo{Path: "pvc-1.vmdk", FileSize: 1},
+				&types.FileInfo{Path: "pvc-1-flat.vmdk", FileSize: 400},
+			},
+		},
+		{
+			FolderPath: "[ds1] contentlib-6f2c/b1a9",
+			File: []types.BaseFileInfo{
+				&types.FileInfo{Path: "template.vmdk", FileSize: 800},
+			},
+		},
+	}
+	referenced := map[string]bool{
+		normalizeDatastorePath("[ds1] vm1/vm1.vmdk"):      true,
+		normalizeDatastorePath("[ds1] vm1/vm1-flat.vmdk"): true,
+	}
+
+	result := summarizeDatastoreFiles(results, referenced)
+
+	assert.Equal(t, int64(1505), result.FileSize[datastoreFileVmdk])
+	assert.Equal(t, int64(30), result.FileSize[datastoreFileIso])
+	assert.Equal(t, int64(10), result.FileSize[datastoreFileLog])
+	assert.Equal(t, int64(50), result.FileSize[datastoreFileVswp])
+	assert.Equal(t, int64(5), result.FileSize[datastoreFileOther])
+	assert.Equal(t, 1, result.OrphanedCount)
+	assert.Equal(t, int64(201), result.OrphanedSize)
+}
+
+func TestManagedDatastoreFolder(t *testing.T) {
+	assert.True(t, managedDatastoreFolder("fcd"))
+	assert.True(t, managedDatastoreFolder("fcd/"))
+	assert.True(t, managedDatastoreFolder("contentlib-6f2c/b1a9"))
+	assert.False(t, managedDatastoreFolder(""))
+	assert.False(t, managedDatastoreFolder("fcd-backup"))
+	assert.False(t, managedDatastoreFolder("vm1/fcd"))
+}
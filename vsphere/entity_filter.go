@@ -0,0 +1,300 @@
+/*
+Copyright (c) 2018 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/vmware/govmomi/property"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+
+	config "github.com/dvonthenen/vsphere-metrics-prometheus/config"
+)
+
+//Attributes entity filter rules match on
+const (
+	entityRulePath    = "path"
+	entityRuleName    = "name"
+	entityRuleCluster = "cluster"
+	entityRuleFolder  = "folder"
+	entityRulePower   = "power"
+	entityRuleTag     = "tag"
+)
+
+var (
+	//ErrEntityFiltered - The entity is excluded by the entity filters
+	ErrEntityFiltered = errors.New("The entity is excluded by the entity filters")
+
+	//ErrTagsNotLoaded - The entity filters need tags which have not been loaded yet
+	ErrTagsNotLoaded = errors.New("The entity filters need tags which have not been loaded yet")
+)
+
+//entityInfo holds the attributes of an entity the filter rules match on
+type entityInfo struct {
+	Path       string
+	Name       string
+	Cluster    string
+	PowerState string
+	Tags       []tagInfo
+}
+
+//Folders returns the folders between the datacenter and the entity in the
+//inventory path
+func (e *entityInfo) Folders() []string {
+	segments := strings.Split(strings.Trim(e.Path, "/"), "/")
+	if len(segments) <= 2 {
+		return nil
+	}
+	return segments[1 : len(segments)-1]
+}
+
+//entityRule matches a single attribute of an entity
+type entityRule struct {
+	kind    string
+	pattern *regexp.Regexp
+}
+
+//Match tells whether the rule matches the entity
+func (r *entityRule) Match(info *entityInfo) bool {
+	switch r.kind {
+	case entityRulePath:
+		return r.pattern.MatchString(info.Path)
+	case entityRuleName:
+		return r.pattern.MatchString(info.Name)
+	case entityRuleCluster:
+		return r.pattern.MatchString(info.Cluster)
+	case entityRulePower:
+		return r.pattern.MatchString(info.PowerState)
+	case entityRuleFolder:
+		for _, folder := range info.Folders() {
+			if r.pattern.MatchString(folder) {
+				return true
+			}
+		}
+	case entityRuleTag:
+		for _, tag := range info.Tags {
+			if r.pattern.MatchString(tag.Category+"/"+tag.Name) || r.pattern.MatchString(tag.Name) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+//entityFilter decides which hosts, VMs and datastores are served
+type entityFilter struct {
+	include []entityRule
+	exclude []entityRule
+}
+
+//newEntityFilter builds the filter from comma separated kind:pattern rules.
+//Names are matched with a regular expression, everything else with a glob.
+func newEntityFilter(include string, exclude string) (*entityFilter, error) {
+	var err error
+	filter := &entityFilter{}
+
+	filter.include, err = parseEntityRules(include)
+	if err != nil {
+		return nil, err
+	}
+	filter.exclude, err = parseEntityRules(exclude)
+	if err != nil {
+		return nil, err
+	}
+
+	return filter, nil
+}
+
+func parseEntityRules(spec string) ([]entityRule, error) {
+	var rules []entityRule
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		i := strings.Index(entry, ":")
+		if i < 0 {
+			return nil, fmt.Errorf("invalid entity filter %q, expected kind:pattern", entry)
+		}
+		kind := strings.TrimSpace(entry[:i])
+		value := strings.TrimSpace(entry[i+1:])
+
+		expr := ""
+		switch kind {
+		case entityRuleName:
+			expr = value
+		case entityRulePath, entityRuleCluster, entityRuleFolder, entityRulePower, entityRuleTag:
+			expr = globToRegexp(value)
+		default:
+			return nil, fmt.Errorf("invalid entity filter %q, unknown kind %q", entry, kind)
+		}
+
+		pattern, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid entity filter %q: %v", entry, err)
+		}
+		rules = append(rules, entityRule{kind: kind, pattern: pattern})
+	}
+
+	return rules, nil
+}
+
+//globToRegexp turns a glob with * and ? wildcards into an anchored expression
+func globToRegexp(glob string) string {
+	expr := regexp.QuoteMeta(glob)
+	expr = strings.Replace(expr, `\*`, ".*", -1)
+	expr = strings.Replace(expr, `\?`, ".", -1)
+	return "^" + expr + "$"
+}
+
+//Uses tells whether any rule matches on the given kind
+func (f *entityFilter) Uses(kind string) bool {
+	if f == nil {
+		return false
+	}
+
+	for _, rules := range [][]entityRule{f.include, f.exclude} {
+		for _, rule := range rules {
+			if rule.kind == kind {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+//Allowed tells whether the entity is served. Entities need to match an
+//include rule, if there are any, and no exclude rule.
+func (f *entityFilter) Allowed(info *entityInfo) bool {
+	if f == nil {
+		return true
+	}
+
+	if len(f.include) > 0 {
+		included := false
+		for _, rule := range f.include {
+			if rule.Match(info) {
+				included = true
+				break
+			}
+		}
+		if !included {
+			return false
+		}
+	}
+
+	for _, rule := range f.exclude {
+		if rule.Match(info) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (c *Client) registerEntityFilter() error {
+	log.Debugln("registerEntityFilter ENTER")
+
+	filter, err := newEntityFilter(c.config.EntityInclude, c.config.EntityExclude)
+	if err != nil {
+		log.Debugln("registerEntityFilter LEAVE")
+		return err
+	}
+
+	err = checkTagRules(filter, c.config)
+	if err != nil {
+		log.Debugln("registerEntityFilter LEAVE")
+		return err
+	}
+
+	c.entityFilter = filter
+
+	log.Debugln("registerEntityFilter Succeeded")
+	log.Debugln("registerEntityFilter LEAVE")
+
+	return nil
+}
+
+//checkTagRules rejects tag rules the tags will never be loaded for. Without
+//tags every scrape would be answered with a 503.
+func checkTagRules(filter *entityFilter, cfg *config.Config) error {
+	if !filter.Uses(entityRuleTag) {
+		return nil
+	}
+	if cfg.TagRefreshInterval <= 0 {
+		return errors.New("entity filters on tags need the tag refresh interval to be set")
+	}
+	if !passwordCredentials(cfg) {
+		return fmt.Errorf("entity filters on tags need password auth with a password, the vAPI endpoint does not take %s auth", cfg.VSphereAuth)
+	}
+	return nil
+}
+
+//filterEntity answers the request with a 404 when the entity is filtered
+func (c *Client) filterEntity(w http.ResponseWriter, ref types.ManagedObjectReference, info *entityInfo) error {
+	if c.entityFilter.Uses(entityRuleTag) {
+		if !c.tags.Loaded() {
+			http.Error(w, "Tags have not been loaded yet", http.StatusServiceUnavailable)
+			return ErrTagsNotLoaded
+		}
+		info.Tags = c.tags.Tags(ref)
+	}
+
+	if !c.entityFilter.Allowed(info) {
+		http.Error(w, "Entity not found", http.StatusNotFound)
+		log.Infoln("Filtered:", info.Path)
+		return ErrEntityFiltered
+	}
+
+	return nil
+}
+
+//hostCluster returns the name of the cluster of the host, if it is in one
+func (c *Client) hostCluster(s *session, host *types.ManagedObjectReference) (string, error) {
+	if host == nil {
+		return "", nil
+	}
+
+	pc := property.DefaultCollector(s.client.Client)
+
+	var oHost mo.HostSystem
+	err := pc.RetrieveOne(s.ctx, *host, []string{"parent"}, &oHost)
+	if err != nil {
+		return "", err
+	}
+	if oHost.Parent == nil || oHost.Parent.Type != "ClusterComputeResource" {
+		return "", nil
+	}
+
+	var oCluster mo.ClusterComputeResource
+	err = pc.RetrieveOne(s.ctx, *oHost.Parent, []string{"name"}, &oCluster)
+	if err != nil {
+		return "", err
+	}
+
+	return oCluster.Name, nil
+}
@@ -0,0 +1,155 @@
+/*
+Copyright (c) 2018 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package vsphere
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	assert "github.com/stretchr/testify/assert"
+
+	"github.com/vmware/govmomi/vim25/types"
+
+	config "github.com/dvonthenen/vsphere-metrics-prometheus/config"
+)
+
+func TestEntityFilterAllowed(t *testing.T) {
+	web := &entityInfo{
+		Path:       "/DC1/vm/Prod/Web/web-01",
+		Name:       "web-01",
+		Cluster:    "Prod",
+		PowerState: "poweredOn",
+		Tags:       []tagInfo{{Category: "Environment", Name: "prod"}},
+	}
+	template := &entityInfo{
+		Path:       "/DC1/vm/Templates/tmpl-centos",
+		Name:       "tmpl-centos",
+		Cluster:    "Prod",
+		PowerState: "poweredOff",
+	}
+	tenant := &entityInfo{
+		Path:       "/DC1/vm/Tenants/Secret/db-01",
+		Name:       "db-01",
+		Cluster:    "Shared",
+		PowerState: "poweredOn",
+		Tags:       []tagInfo{{Category: "Tenant", Name: "secret"}},
+	}
+
+	assert.Equal(t, []string{"vm", "Prod", "Web"}, web.Folders())
+
+	var filter *entityFilter
+	assert.True(t, filter.Allowed(web))
+
+	filter, err := newEntityFilter("", "folder:Templates, name:^tmpl-, tag:Tenant/secret")
+	assert.NoError(t, err)
+	assert.True(t, filter.Allowed(web))
+	assert.False(t, filter.Allowed(template))
+	assert.False(t, filter.Allowed(tenant))
+	assert.True(t, filter.Uses(entityRuleTag))
+	assert.False(t, filter.Uses(entityRuleCluster))
+
+	filter, err = newEntityFilter("cluster:Prod", "power:poweredOff")
+	assert.NoError(t, err)
+	assert.True(t, filter.Allowed(web))
+	assert.False(t, filter.Allowed(template))
+	assert.False(t, filter.Allowed(tenant))
+
+	filter, err = newEntityFilter("path:/DC1/vm/Prod/*", "")
+	assert.NoError(t, err)
+	assert.True(t, filter.Allowed(web))
+	assert.False(t, filter.Allowed(tenant))
+
+	_, err = newEntityFilter("owner:me", "")
+	assert.Error(t, err)
+
+	_, err = newEntityFilter("Templates", "")
+	assert.Error(t, err)
+
+	_, err = newEntityFilter("name:[", "")
+	assert.Error(t, err)
+}
+
+func TestFilterEntity(t *testing.T) {
+	filter, err := newEntityFilter("", "tag:secret")
+	assert.NoError(t, err)
+
+	c := &Client{entityFilter: filter, tags: &tagCache{}}
+	ref := types.ManagedObjectReference{Type: "VirtualMachine", Value: "vm-1"}
+
+	w := httptest.NewRecorder()
+	err = c.filterEntity(w, ref, &entityInfo{Name: "db-01"})
+	assert.Equal(t, ErrTagsNotLoaded, err)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+
+	c.tags.update(map[string][]tagInfo{
+		tagKey("VirtualMachine", "vm-1"): {{Category: "Tenant", Name: "secret"}},
+	})
+
+	w = httptest.NewRecorder()
+	err = c.filterEntity(w, ref, &entityInfo{Name: "db-01"})
+	assert.Equal(t, ErrEntityFiltered, err)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = httptest.NewRecorder()
+	err = c.filterEntity(w, types.ManagedObjectReference{Type: "VirtualMachine", Value: "vm-2"}, &entityInfo{Name: "web-01"})
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestCheckTagRules(t *testing.T) {
+	tagFilter, err := newEntityFilter("", "tag:secret")
+	assert.NoError(t, err)
+	nameFilter, err := newEntityFilter("", "name:test-*")
+	assert.NoError(t, err)
+
+	password := &config.Config{
+		VSphereAuth:        string(config.VSphereAuthPassword),
+		VSpherePass:        "pass",
+		TagRefreshInterval: time.Hour,
+	}
+	assert.NoError(t, checkTagRules(tagFilter, password))
+
+	// Without a password the tags are never loaded and every scrape of the
+	// filtered role would fail
+	for _, cfg := range []config.Config{
+		{VSphereAuth: string(config.VSphereAuthPassword), VSpherePass: "pass"},
+		{VSphereAuth: string(config.VSphereAuthPassword), TagRefreshInterval: time.Hour},
+		{VSphereAuth: string(config.VSphereAuthExtension), TagRefreshInterval: time.Hour},
+		{VSphereAuth: string(config.VSphereAuthToken), VSpherePass: "pass", TagRefreshInterval: time.Hour},
+	} {
+		cfg := cfg
+		assert.Error(t, checkTagRules(tagFilter, &cfg), cfg.VSphereAuth)
+		assert.NoError(t, checkTagRules(nameFilter, &cfg), cfg.VSphereAuth)
+	}
+
+	// A reload to token auth is rejected while a tag rule is configured
+	c := NewClient(&config.Config{
+		VSphereType:        string(config.VSphereRoleVirtualMachine),
+		VSphereAuth:        string(config.VSphereAuthPassword),
+		VSpherePass:        "pass",
+		TagRefreshInterval: time.Hour,
+		EntityExclude:      "tag:secret",
+	})
+	assert.NoError(t, c.registerEntityFilter())
+
+	reloaded := *c.config
+	reloaded.VSphereAuth = string(config.VSphereAuthToken)
+	reloaded.VSpherePass = ""
+	assert.Error(t, c.Reload(&reloaded))
+	assert.Equal(t, string(config.VSphereAuthPassword), c.currentConfig().VSphereAuth)
+}
@@ -17,72 +17,68 @@ limitations under the License.
 package vsphere
 
 import (
-	"fmt"
+	"context"
 	"net/http"
-	"strings"
 
 	"github.com/gorilla/mux"
-	"github.com/iancoleman/strcase"
 	log "github.com/sirupsen/logrus"
 
-	"github.com/prometheus/client_golang/prometheus"
-
 	"github.com/vmware/govmomi/find"
-	"github.com/vmware/govmomi/vim25/methods"
 	"github.com/vmware/govmomi/vim25/mo"
-	"github.com/vmware/govmomi/vim25/types"
-)
-
-var (
-	metricsMapEsx = make(map[int]*prometheus.GaugeVec)
-	//metricsMapEsx = make(map[int]*prometheus.Desc)
 )
 
 func (c *Client) registerEsxMetrics() error {
 	log.Debugln("registerEsxMetrics ENTER")
 
 	// Create client
-	err := c.getClient()
+	s, err := c.getSession(context.Background())
 	if err != nil {
-		log.Errorln("getClient failed:", err)
+		log.Errorln("getSession failed:", err)
 		log.Debugln("registerEsxMetrics LEAVE")
 
 		return err
 	}
 
-	var performanceManager mo.PerformanceManager
-	err = c.vClient.RetrieveOne(*c.ctx, *c.vClient.ServiceContent.PerfManager, nil, &performanceManager)
+	err = c.registerPerfMetrics(s, "esx", c.config.EsxPerfSamples, c.metricsMapEsx, c.perfCountersEsx)
+	if err != nil {
+		log.Debugln("registerPerfMetrics Failed:", err)
+		log.Debugln("registerEsxMetrics LEAVE")
+		return err
+	}
+
+	/*
+		labels := []string{"datacenter", "esx"}
+		myMetric := prometheus.NewDesc(metricName, nameInfo.Summary, labels, nil)
+		c.metricsMapEsx[int(perfCounterInfo.Key)] = myMetric
+	*/
+
+	myMetric, err := c.registerInfoMetric("esx", esxInfo)
 	if err != nil {
-		log.Errorln("RetrieveOne failed:", err)
+		log.Debugln("registerInfoMetric Failed:", err)
 		log.Debugln("registerEsxMetrics LEAVE")
+		return err
+	}
+	c.metricsMapEsxHost[esxInfo] = myMetric
 
+	err = c.registerEsxCertificateMetrics()
+	if err != nil {
+		log.Debugln("registerEsxCertificateMetrics Failed:", err)
+		log.Debugln("registerEsxMetrics LEAVE")
 		return err
 	}
 
-	// As outline in https://code.vmware.com/doc/preview?id=6784#/doc/vim.PerformanceManager.CounterInfo.html
-	for _, perfCounterInfo := range performanceManager.PerfCounter {
-		nameInfo := perfCounterInfo.NameInfo.GetElementDescription()
-		keyTmp := strings.Join(strings.Split(nameInfo.Key, "."), "_")
-		metricName := fmt.Sprintf("%d_%s", perfCounterInfo.Key, strcase.ToSnake(keyTmp))
-		log.Debugln("Key:", metricName)
-
-		myMetric := prometheus.NewGaugeVec(
-			prometheus.GaugeOpts{
-				Namespace: "vsphere",
-				Subsystem: "esx",
-				Name:      metricName,
-				Help:      metricName,
-			},
-			[]string{"datacenter"},
-		)
-		metricsMapEsx[int(perfCounterInfo.Key)] = myMetric
-		prometheus.MustRegister(myMetric)
-
-		/*
-			labels := []string{"datacenter", "esx"}
-			myMetric := prometheus.NewDesc(metricName, nameInfo.Summary, labels, nil)
-			metricsMapEsx[int(perfCounterInfo.Key)] = myMetric
-		*/
+	err = c.registerEsxComplianceMetrics()
+	if err != nil {
+		log.Debugln("registerEsxComplianceMetrics Failed:", err)
+		log.Debugln("registerEsxMetrics LEAVE")
+		return err
+	}
+
+	err = c.registerEsxNtpMetrics()
+	if err != nil {
+		log.Debugln("registerEsxNtpMetrics Failed:", err)
+		log.Debugln("registerEsxMetrics LEAVE")
+		return err
 	}
 
 	log.Debugln("registerEsxMetrics Succeeded")
@@ -101,31 +97,34 @@ func (c *Client) GetVSphereEsxStats(w http.ResponseWriter, r *http.Request) erro
 	hostStr := vars["host"]
 	log.Infoln("Host:", hostStr)
 
+	ctx, cancel := c.scrapeContext(r)
+	defer c.endScrape(ctx, cancel)
+
 	// Create client
-	err := c.getClient()
+	s, err := c.getSession(ctx)
 	if err != nil {
-		http.Error(w, "Unable connect to the vCenter Server", http.StatusGone)
-		log.Errorln("getClient failed:", err)
+		err = scrapeError(ctx, w, err, "Unable connect to the vCenter Server", http.StatusGone)
+		log.Errorln("getSession failed:", err)
 		log.Debugln("GetVSphereEsxStats LEAVE")
 
 		return err
 	}
 
 	//find our objects
-	finder := find.NewFinder(c.vClient.Client, false)
+	finder := find.NewFinder(s.client.Client, false)
 
-	dc, err := finder.Datacenter(*c.ctx, datacenterStr)
+	dc, err := finder.Datacenter(s.ctx, datacenterStr)
 	if err != nil {
-		http.Error(w, "Unable find the Datacener", http.StatusGone)
+		err = scrapeError(ctx, w, err, "Unable find the Datacener", http.StatusGone)
 		log.Errorln("finder.Datacenter(", datacenterStr, "):", err)
 		log.Debugln("GetVSphereEsxStats LEAVE")
 		return err
 	}
 	finder.SetDatacenter(dc)
 
-	host, err := finder.HostSystem(*c.ctx, hostStr)
+	host, err := finder.HostSystem(s.ctx, hostStr)
 	if err != nil {
-		http.Error(w, "Unable find the HostSystem", http.StatusGone)
+		err = scrapeError(ctx, w, err, "Unable find the HostSystem", http.StatusGone)
 		log.Errorln("finder.HostSystem(", hostStr, "):", err)
 		log.Debugln("GetVSphereEsxStats LEAVE")
 		return err
@@ -135,9 +134,9 @@ func (c *Client) GetVSphereEsxStats(w http.ResponseWriter, r *http.Request) erro
 	log.Infoln("Host:", host.InventoryPath)
 
 	var oHost mo.HostSystem
-	err = host.Properties(*c.ctx, host.Reference(), []string{"summary"}, &oHost)
+	err = host.Properties(s.ctx, host.Reference(), []string{"summary", "customValue", "parent"}, &oHost)
 	if err != nil {
-		http.Error(w, "Unable get the HostSystem properties", http.StatusBadRequest)
+		err = scrapeError(ctx, w, err, "Unable get the HostSystem properties", http.StatusBadRequest)
 		log.Errorln("host.Properties(", hostStr, "):", err)
 		log.Debugln("GetVSphereEsxStats LEAVE")
 		return err
@@ -150,47 +149,63 @@ func (c *Client) GetVSphereEsxStats(w http.ResponseWriter, r *http.Request) erro
 	log.Infoln(string(oHost.Summary.OverallStatus))
 	log.Infoln(string(oHost.OverallStatus))
 
-	var performanceManager mo.PerformanceManager
-	err = c.vClient.RetrieveOne(*c.ctx, *c.vClient.ServiceContent.PerfManager, nil, &performanceManager)
+	entity := entityInfo{
+		Path: host.InventoryPath,
+		Name: host.Name(),
+	}
+	if oHost.Summary.Runtime != nil {
+		entity.PowerState = string(oHost.Summary.Runtime.PowerState)
+	}
+	if c.entityFilter.Uses(entityRuleCluster) && oHost.Parent != nil && oHost.Parent.Type == "ClusterComputeResource" {
+		entity.Cluster, err = c.hostCluster(s, &oHost.Self)
+		if err != nil {
+			// Without the cluster an exclude rule on it would not match
+			err = scrapeError(ctx, w, err, "Unable find the cluster for the entity filters", http.StatusServiceUnavailable)
+			log.Errorln("hostCluster(", hostStr, "):", err)
+			log.Debugln("GetVSphereEsxStats LEAVE")
+			return err
+		}
+	}
+
+	err = c.filterEntity(w, host.Reference(), &entity)
 	if err != nil {
-		log.Errorln("RetrieveOne failed:", err)
 		log.Debugln("GetVSphereEsxStats LEAVE")
-
 		return err
 	}
 
-	querySpec := types.PerfQuerySpec{
-		Entity:     host.Reference(),
-		MaxSample:  1,
-		IntervalId: 20,
+	c.setInfoMetric(s, c.metricsMapEsxHost[esxInfo], datacenterStr, host.Name(), host.Reference(), oHost.CustomValue)
+	c.setTagMetrics(host.Name(), host.Reference())
+
+	var variant string
+	if oHost.Summary.Config.Product != nil {
+		variant = oHost.Summary.Config.Product.Version + "-" + oHost.Summary.Config.Product.Build
 	}
-	query := types.QueryPerf{
-		This:      *c.vClient.ServiceContent.PerfManager,
-		QuerySpec: []types.PerfQuerySpec{querySpec},
+
+	// The certificate, compliance and NTP metrics do not depend on perf
+	// stats, so they are set on hosts without them as well
+	perfErr := c.setPerfMetrics(s, datacenterStr, host.Reference(), variant, c.config.EsxPerfInterval, c.config.EsxPerfSamples, c.metricsMapEsx, c.perfCountersEsx)
+	if perfErr != nil {
+		log.Errorln("setPerfMetrics failed:", perfErr)
 	}
 
-	response, err := methods.QueryPerf(*c.ctx, c.vClient, &query)
+	err = c.setEsxCertificateMetrics(s, datacenterStr, host)
 	if err != nil {
-		log.Errorln("QueryPerf failed:", err)
-		log.Debugln("GetVSphereEsxStats LEAVE")
-
-		return err
+		log.Errorln("setEsxCertificateMetrics(", hostStr, "):", err)
 	}
 
-	for _, base := range response.Returnval {
-		metric := base.(*types.PerfEntityMetric)
-		for _, baseSeries := range metric.Value {
-			series := baseSeries.(*types.PerfMetricIntSeries)
-			myMetric := metricsMapEsx[int(series.Id.CounterId)]
+	err = c.setEsxComplianceMetrics(s, datacenterStr, host)
+	if err != nil {
+		log.Errorln("setEsxComplianceMetrics(", hostStr, "):", err)
+	}
 
-			if myMetric == nil {
-				log.Errorln("Unable to find metric for", series.Id.CounterId)
-				continue
-			}
+	err = c.setEsxNtpMetrics(s, datacenterStr, host)
+	if err != nil {
+		log.Errorln("setEsxNtpMetrics(", hostStr, "):", err)
+	}
 
-			myMetric.WithLabelValues(datacenterStr).Set(float64(series.Value[0]))
-			//prometheus.MustNewConstMetric(myMetric, prometheus.GaugeValue, float64(series.Value[0]), datacenterStr, hostStr)
-		}
+	if perfErr != nil {
+		log.Debugln("GetVSphereEsxStats LEAVE")
+		return perfErr
 	}
 
 	log.Debugln("GetVSphereEsxStats Succeeded")
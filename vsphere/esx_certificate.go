@@ -0,0 +1,142 @@
+/*
+Copyright (c) 2018 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+const (
+	esxCertificateNotBefore = (iota + 256)
+	esxCertificateNotAfter
+	esxCertificateStatus
+)
+
+var (
+	esxCertificateStatuses = []types.HostCertificateManagerCertificateInfoCertificateStatus{
+		types.HostCertificateManagerCertificateInfoCertificateStatusUnknown,
+		types.HostCertificateManagerCertificateInfoCertificateStatusExpired,
+		types.HostCertificateManagerCertificateInfoCertificateStatusExpiring,
+		types.HostCertificateManagerCertificateInfoCertificateStatusExpiringShortly,
+		types.HostCertificateManagerCertificateInfoCertificateStatusExpirationImminent,
+		types.HostCertificateManagerCertificateInfoCertificateStatusGood,
+	}
+)
+
+func (c *Client) registerEsxCertificateMetrics() error {
+	log.Debugln("registerEsxCertificateMetrics ENTER")
+
+	//not before
+	metricName := fmt.Sprintf("%d_certificate_not_before_timestamp_seconds", esxCertificateNotBefore)
+	log.Debugln("Key:", metricName)
+
+	myMetric := prometheus.NewGaugeVec(
+		c.gaugeOpts("esx", metricName, "certificate_not_before_timestamp_seconds", "Start of the validity period of the host certificate"),
+		[]string{"datacenter", "issuer", "subject"},
+	)
+	c.metricsMapEsxHost[esxCertificateNotBefore] = myMetric
+	c.registry.MustRegister(myMetric)
+
+	//not after
+	metricName = fmt.Sprintf("%d_certificate_not_after_timestamp_seconds", esxCertificateNotAfter)
+	log.Debugln("Key:", metricName)
+
+	myMetric = prometheus.NewGaugeVec(
+		c.gaugeOpts("esx", metricName, "certificate_not_after_timestamp_seconds", "End of the validity period of the host certificate"),
+		[]string{"datacenter", "issuer", "subject"},
+	)
+	c.metricsMapEsxHost[esxCertificateNotAfter] = myMetric
+	c.registry.MustRegister(myMetric)
+
+	//status, one series per possible status with the current one set to 1
+	metricName = fmt.Sprintf("%d_certificate_status", esxCertificateStatus)
+	log.Debugln("Key:", metricName)
+
+	myMetric = prometheus.NewGaugeVec(
+		c.gaugeOpts("esx", metricName, "certificate_status", "Status of the host certificate, 1 for the current status"),
+		[]string{"datacenter", "status"},
+	)
+	c.metricsMapEsxHost[esxCertificateStatus] = myMetric
+	c.registry.MustRegister(myMetric)
+
+	log.Debugln("registerEsxCertificateMetrics Succeeded")
+	log.Debugln("registerEsxCertificateMetrics LEAVE")
+
+	return nil
+}
+
+func (c *Client) setEsxCertificateMetrics(s *session, datacenterStr string, host *object.HostSystem) error {
+	log.Debugln("setEsxCertificateMetrics ENTER")
+
+	// Issuer and subject differ between hosts so drop the previous host's series
+	for _, key := range []int{esxCertificateNotBefore, esxCertificateNotAfter, esxCertificateStatus} {
+		myMetric := c.metricsMapEsxHost[key]
+		if myMetric != nil {
+			myMetric.Reset()
+		}
+	}
+
+	certificateManager, err := host.ConfigManager().CertificateManager(s.ctx)
+	if err == object.ErrNotSupported {
+		log.Debugln("CertificateManager not supported on", host.Name())
+		log.Debugln("setEsxCertificateMetrics LEAVE")
+		return nil
+	}
+	if err != nil {
+		log.Debugln("setEsxCertificateMetrics LEAVE")
+		return err
+	}
+
+	info, err := certificateManager.CertificateInfo(s.ctx)
+	if err != nil {
+		log.Debugln("setEsxCertificateMetrics LEAVE")
+		return err
+	}
+
+	log.Debugln("Certificate:", info.Subject, info.Issuer, info.Status)
+
+	myMetric := c.metricsMapEsxHost[esxCertificateNotBefore]
+	if myMetric != nil && info.NotBefore != nil {
+		myMetric.WithLabelValues(datacenterStr, info.Issuer, info.Subject).Set(float64(info.NotBefore.Unix()))
+	}
+	myMetric = c.metricsMapEsxHost[esxCertificateNotAfter]
+	if myMetric != nil && info.NotAfter != nil {
+		myMetric.WithLabelValues(datacenterStr, info.Issuer, info.Subject).Set(float64(info.NotAfter.Unix()))
+	}
+	myMetric = c.metricsMapEsxHost[esxCertificateStatus]
+	if myMetric != nil {
+		for _, status := range esxCertificateStatuses {
+			value := 0.0
+			if info.Status == string(status) {
+				value = 1.0
+			}
+			myMetric.WithLabelValues(datacenterStr, string(status)).Set(value)
+		}
+	}
+
+	log.Debugln("setEsxCertificateMetrics Succeeded")
+	log.Debugln("setEsxCertificateMetrics LEAVE")
+
+	return nil
+}
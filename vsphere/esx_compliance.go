@@ -0,0 +1,328 @@
+/*
+Copyright (c) 2018 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+const (
+	esxServiceRunning = (iota + 272)
+	esxServicePolicy
+	esxFirewallRulesetEnabled
+	esxLockdownMode
+	esxCompliance
+	esxComplianceViolation
+)
+
+var (
+	esxLockdownModes = []types.HostLockdownMode{
+		types.HostLockdownModeLockdownDisabled,
+		types.HostLockdownModeLockdownNormal,
+		types.HostLockdownModeLockdownStrict,
+	}
+)
+
+//HostBaseline is the expected host configuration read from the baseline file.
+//Anything left out of the file is not checked.
+type HostBaseline struct {
+	Services     map[string]HostServiceBaseline `json:"services,omitempty"`
+	Firewall     map[string]bool                `json:"firewall,omitempty"`
+	LockdownMode string                         `json:"lockdownMode,omitempty"`
+}
+
+//HostServiceBaseline is the expected state of a single host service
+type HostServiceBaseline struct {
+	Running *bool  `json:"running,omitempty"`
+	Policy  string `json:"policy,omitempty"`
+}
+
+//hostConfigState is the part of the host configuration compared to the baseline
+type hostConfigState struct {
+	Services     []types.HostService
+	Rulesets     []types.HostFirewallRuleset
+	LockdownMode types.HostLockdownMode
+}
+
+//LoadHostBaseline reads a JSON host baseline from file
+func LoadHostBaseline(file string) (*HostBaseline, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	baseline := &HostBaseline{}
+	err = json.Unmarshal(data, baseline)
+	if err != nil {
+		return nil, fmt.Errorf("invalid host baseline %s: %v", file, err)
+	}
+
+	return baseline, nil
+}
+
+func (c *Client) registerEsxComplianceMetrics() error {
+	log.Debugln("registerEsxComplianceMetrics ENTER")
+
+	if c.config.EsxBaselineFile != "" {
+		baseline, err := LoadHostBaseline(c.config.EsxBaselineFile)
+		if err != nil {
+			log.Debugln("registerEsxComplianceMetrics LEAVE")
+			return err
+		}
+		c.baseline = baseline
+	}
+
+	//service running
+	metricName := fmt.Sprintf("%d_service_running", esxServiceRunning)
+	log.Debugln("Key:", metricName)
+
+	myMetric := prometheus.NewGaugeVec(
+		c.gaugeOpts("esx", metricName, "service_running", "Whether the host service is running"),
+		[]string{"datacenter", "service", "label"},
+	)
+	c.metricsMapEsxHost[esxServiceRunning] = myMetric
+	c.registry.MustRegister(myMetric)
+
+	//service policy
+	metricName = fmt.Sprintf("%d_service_policy", esxServicePolicy)
+	log.Debugln("Key:", metricName)
+
+	myMetric = prometheus.NewGaugeVec(
+		c.gaugeOpts("esx", metricName, "service_policy", "Startup policy of the host service"),
+		[]string{"datacenter", "service", "policy"},
+	)
+	c.metricsMapEsxHost[esxServicePolicy] = myMetric
+	c.registry.MustRegister(myMetric)
+
+	//firewall ruleset
+	metricName = fmt.Sprintf("%d_firewall_ruleset_enabled", esxFirewallRulesetEnabled)
+	log.Debugln("Key:", metricName)
+
+	myMetric = prometheus.NewGaugeVec(
+		c.gaugeOpts("esx", metricName, "firewall_ruleset_enabled", "Whether the host firewall ruleset is enabled"),
+		[]string{"datacenter", "ruleset", "label"},
+	)
+	c.metricsMapEsxHost[esxFirewallRulesetEnabled] = myMetric
+	c.registry.MustRegister(myMetric)
+
+	//lockdown mode, one series per mode with the current one set to 1
+	metricName = fmt.Sprintf("%d_lockdown_mode", esxLockdownMode)
+	log.Debugln("Key:", metricName)
+
+	myMetric = prometheus.NewGaugeVec(
+		c.gaugeOpts("esx", metricName, "lockdown_mode", "Lockdown mode of the host, 1 for the current mode"),
+		[]string{"datacenter", "mode"},
+	)
+	c.metricsMapEsxHost[esxLockdownMode] = myMetric
+	c.registry.MustRegister(myMetric)
+
+	if c.baseline != nil {
+		//compliance
+		metricName = fmt.Sprintf("%d_compliance", esxCompliance)
+		log.Debugln("Key:", metricName)
+
+		myMetric = prometheus.NewGaugeVec(
+			c.gaugeOpts("esx", metricName, "compliance", "Whether the host satisfies the baseline"),
+			[]string{"datacenter"},
+		)
+		c.metricsMapEsxHost[esxCompliance] = myMetric
+		c.registry.MustRegister(myMetric)
+
+		//violations
+		metricName = fmt.Sprintf("%d_compliance_violation", esxComplianceViolation)
+		log.Debugln("Key:", metricName)
+
+		myMetric = prometheus.NewGaugeVec(
+			c.gaugeOpts("esx", metricName, "compliance_violation", "Baseline rules the host violates"),
+			[]string{"datacenter", "rule"},
+		)
+		c.metricsMapEsxHost[esxComplianceViolation] = myMetric
+		c.registry.MustRegister(myMetric)
+	}
+
+	log.Debugln("registerEsxComplianceMetrics Succeeded")
+	log.Debugln("registerEsxComplianceMetrics LEAVE")
+
+	return nil
+}
+
+func (c *Client) setEsxComplianceMetrics(s *session, datacenterStr string, host *object.HostSystem) error {
+	log.Debugln("setEsxComplianceMetrics ENTER")
+
+	// Services and rulesets differ between hosts so drop the previous host's series
+	for _, key := range []int{esxServiceRunning, esxServicePolicy, esxFirewallRulesetEnabled, esxLockdownMode, esxCompliance, esxComplianceViolation} {
+		myMetric := c.metricsMapEsxHost[key]
+		if myMetric != nil {
+			myMetric.Reset()
+		}
+	}
+
+	state := hostConfigState{}
+
+	serviceSystem, err := host.ConfigManager().ServiceSystem(s.ctx)
+	if err != nil {
+		log.Debugln("setEsxComplianceMetrics LEAVE")
+		return err
+	}
+
+	state.Services, err = serviceSystem.Service(s.ctx)
+	if err != nil {
+		log.Debugln("setEsxComplianceMetrics LEAVE")
+		return err
+	}
+
+	firewallSystem, err := host.ConfigManager().FirewallSystem(s.ctx)
+	if err != nil {
+		log.Debugln("setEsxComplianceMetrics LEAVE")
+		return err
+	}
+
+	firewallInfo, err := firewallSystem.Info(s.ctx)
+	if err != nil {
+		log.Debugln("setEsxComplianceMetrics LEAVE")
+		return err
+	}
+	state.Rulesets = firewallInfo.Ruleset
+
+	var oHost mo.HostSystem
+	err = host.Properties(s.ctx, host.Reference(), []string{"config.lockdownMode", "config.adminDisabled"}, &oHost)
+	if err != nil {
+		log.Debugln("setEsxComplianceMetrics LEAVE")
+		return err
+	}
+	state.LockdownMode = types.HostLockdownModeLockdownDisabled
+	if oHost.Config != nil {
+		if oHost.Config.LockdownMode != "" {
+			state.LockdownMode = oHost.Config.LockdownMode
+		} else if oHost.Config.AdminDisabled != nil && *oHost.Config.AdminDisabled {
+			// Hosts before 6.0 only know about normal lockdown
+			state.LockdownMode = types.HostLockdownModeLockdownNormal
+		}
+	}
+
+	myMetric := c.metricsMapEsxHost[esxServiceRunning]
+	if myMetric != nil {
+		for _, service := range state.Services {
+			myMetric.WithLabelValues(datacenterStr, service.Key, service.Label).Set(boolToFloat(service.Running))
+		}
+	}
+	myMetric = c.metricsMapEsxHost[esxServicePolicy]
+	if myMetric != nil {
+		for _, service := range state.Services {
+			myMetric.WithLabelValues(datacenterStr, service.Key, service.Policy).Set(1)
+		}
+	}
+	myMetric = c.metricsMapEsxHost[esxFirewallRulesetEnabled]
+	if myMetric != nil {
+		for _, ruleset := range state.Rulesets {
+			myMetric.WithLabelValues(datacenterStr, ruleset.Key, ruleset.Label).Set(boolToFloat(ruleset.Enabled))
+		}
+	}
+	myMetric = c.metricsMapEsxHost[esxLockdownMode]
+	if myMetric != nil {
+		for _, mode := range esxLockdownModes {
+			myMetric.WithLabelValues(datacenterStr, string(mode)).Set(boolToFloat(mode == state.LockdownMode))
+		}
+	}
+
+	if c.baseline != nil {
+		violations := c.baseline.Evaluate(&state)
+		log.Debugln("Baseline violations:", violations)
+
+		myMetric = c.metricsMapEsxHost[esxCompliance]
+		if myMetric != nil {
+			myMetric.WithLabelValues(datacenterStr).Set(boolToFloat(len(violations) == 0))
+		}
+		myMetric = c.metricsMapEsxHost[esxComplianceViolation]
+		if myMetric != nil {
+			for _, violation := range violations {
+				myMetric.WithLabelValues(datacenterStr, violation).Set(1)
+			}
+		}
+	}
+
+	log.Debugln("setEsxComplianceMetrics Succeeded")
+	log.Debugln("setEsxComplianceMetrics LEAVE")
+
+	return nil
+}
+
+//Evaluate returns the rules of the baseline the host does not satisfy
+func (b *HostBaseline) Evaluate(state *hostConfigState) []string {
+	var violations []string
+
+	services := make(map[string]types.HostService)
+	for _, service := range state.Services {
+		services[service.Key] = service
+	}
+	for key, expected := range b.Services {
+		service, ok := services[key]
+		if !ok {
+			violations = append(violations, "service."+key+".missing")
+			continue
+		}
+		if expected.Running != nil && *expected.Running != service.Running {
+			violations = append(violations, "service."+key+".running")
+		}
+		if expected.Policy != "" && expected.Policy != service.Policy {
+			violations = append(violations, "service."+key+".policy")
+		}
+	}
+
+	rulesets := make(map[string]types.HostFirewallRuleset)
+	for _, ruleset := range state.Rulesets {
+		rulesets[ruleset.Key] = ruleset
+	}
+	for key, enabled := range b.Firewall {
+		ruleset, ok := rulesets[key]
+		if !ok {
+			if enabled {
+				violations = append(violations, "firewall."+key+".missing")
+			}
+			continue
+		}
+		if ruleset.Enabled != enabled {
+			violations = append(violations, "firewall."+key+".enabled")
+		}
+	}
+
+	if b.LockdownMode != "" && b.LockdownMode != string(state.LockdownMode) {
+		violations = append(violations, "lockdownMode")
+	}
+
+	sort.Strings(violations)
+
+	return violations
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1.0
+	}
+	return 0.0
+}
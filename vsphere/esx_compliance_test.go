@@ -0,0 +1,66 @@
+/*
+Copyright (c) 2018 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package vsphere
+
+import (
+	"testing"
+
+	assert "github.com/stretchr/testify/assert"
+
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+func TestHostBaselineEvaluate(t *testing.T) {
+	stopped := false
+	baseline := &HostBaseline{
+		Services: map[string]HostServiceBaseline{
+			"TSM-SSH": {Running: &stopped, Policy: "off"},
+			"ntpd":    {Policy: "on"},
+			"snmpd":   {Policy: "off"},
+		},
+		Firewall: map[string]bool{
+			"sshServer": false,
+			"ntpClient": true,
+		},
+		LockdownMode: "lockdownNormal",
+	}
+
+	state := &hostConfigState{
+		Services: []types.HostService{
+			{Key: "TSM-SSH", Running: true, Policy: "off"},
+			{Key: "ntpd", Running: true, Policy: "on"},
+		},
+		Rulesets: []types.HostFirewallRuleset{
+			{Key: "sshServer", Enabled: true},
+			{Key: "ntpClient", Enabled: true},
+		},
+		LockdownMode: types.HostLockdownModeLockdownDisabled,
+	}
+
+	violations := baseline.Evaluate(state)
+	assert.Equal(t, []string{
+		"firewall.sshServer.enabled",
+		"lockdownMode",
+		"service.TSM-SSH.running",
+		"service.snmpd.missing",
+	}, violations)
+
+	state.Services[0].Running = false
+	state.Services = append(state.Services, types.HostService{Key: "snmpd", Policy: "off"})
+	state.Rulesets[0].Enabled = false
+	state.LockdownMode = types.HostLockdownModeLockdownNormal
+	assert.Empty(t, baseline.Evaluate(state))
+}
@@ -0,0 +1,164 @@
+/*
+Copyright (c) 2018 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/vim25/mo"
+)
+
+const (
+	esxNtpServer = (iota + 288)
+	esxNtpRunning
+	esxClockOffset
+)
+
+//esxNtpServiceKey is the HostService key of the NTP daemon
+const esxNtpServiceKey = "ntpd"
+
+func (c *Client) registerEsxNtpMetrics() error {
+	log.Debugln("registerEsxNtpMetrics ENTER")
+
+	//configured servers
+	metricName := fmt.Sprintf("%d_ntp_server_info", esxNtpServer)
+	log.Debugln("Key:", metricName)
+
+	myMetric := prometheus.NewGaugeVec(
+		c.gaugeOpts("esx", metricName, "ntp_server_info", "NTP servers configured on the host"),
+		[]string{"datacenter", "server"},
+	)
+	c.metricsMapEsxHost[esxNtpServer] = myMetric
+	c.registry.MustRegister(myMetric)
+
+	//ntpd running
+	metricName = fmt.Sprintf("%d_ntp_running", esxNtpRunning)
+	log.Debugln("Key:", metricName)
+
+	myMetric = prometheus.NewGaugeVec(
+		c.gaugeOpts("esx", metricName, "ntp_running", "Whether the NTP daemon of the host is running"),
+		[]string{"datacenter"},
+	)
+	c.metricsMapEsxHost[esxNtpRunning] = myMetric
+	c.registry.MustRegister(myMetric)
+
+	//offset between the host clock and the exporter clock
+	metricName = fmt.Sprintf("%d_clock_offset_seconds", esxClockOffset)
+	log.Debugln("Key:", metricName)
+
+	myMetric = prometheus.NewGaugeVec(
+		c.gaugeOpts("esx", metricName, "clock_offset_seconds", "Offset of the host clock from the exporter clock"),
+		[]string{"datacenter"},
+	)
+	c.metricsMapEsxHost[esxClockOffset] = myMetric
+	c.registry.MustRegister(myMetric)
+
+	log.Debugln("registerEsxNtpMetrics Succeeded")
+	log.Debugln("registerEsxNtpMetrics LEAVE")
+
+	return nil
+}
+
+func (c *Client) setEsxNtpMetrics(s *session, datacenterStr string, host *object.HostSystem) error {
+	log.Debugln("setEsxNtpMetrics ENTER")
+
+	// Servers differ between hosts so drop the previous host's series
+	for _, key := range []int{esxNtpServer, esxNtpRunning, esxClockOffset} {
+		myMetric := c.metricsMapEsxHost[key]
+		if myMetric != nil {
+			myMetric.Reset()
+		}
+	}
+
+	dateTimeSystem, err := host.ConfigManager().DateTimeSystem(s.ctx)
+	if err != nil {
+		log.Debugln("setEsxNtpMetrics LEAVE")
+		return err
+	}
+
+	var oDateTimeSystem mo.HostDateTimeSystem
+	err = dateTimeSystem.Properties(s.ctx, dateTimeSystem.Reference(), []string{"dateTimeInfo"}, &oDateTimeSystem)
+	if err != nil {
+		log.Debugln("setEsxNtpMetrics LEAVE")
+		return err
+	}
+
+	myMetric := c.metricsMapEsxHost[esxNtpServer]
+	if myMetric != nil && oDateTimeSystem.DateTimeInfo.NtpConfig != nil {
+		for _, server := range oDateTimeSystem.DateTimeInfo.NtpConfig.Server {
+			myMetric.WithLabelValues(datacenterStr, server).Set(1)
+		}
+	}
+
+	serviceSystem, err := host.ConfigManager().ServiceSystem(s.ctx)
+	if err != nil {
+		log.Debugln("setEsxNtpMetrics LEAVE")
+		return err
+	}
+
+	services, err := serviceSystem.Service(s.ctx)
+	if err != nil {
+		log.Debugln("setEsxNtpMetrics LEAVE")
+		return err
+	}
+
+	myMetric = c.metricsMapEsxHost[esxNtpRunning]
+	if myMetric != nil {
+		running := false
+		for _, service := range services {
+			if service.Key == esxNtpServiceKey {
+				running = service.Running
+			}
+		}
+		myMetric.WithLabelValues(datacenterStr).Set(boolToFloat(running))
+	}
+
+	before := time.Now()
+	hostTime, err := dateTimeSystem.Query(s.ctx)
+	after := time.Now()
+	if err != nil {
+		log.Debugln("setEsxNtpMetrics LEAVE")
+		return err
+	}
+
+	offset := clockOffset(before, after, *hostTime)
+	log.Debugln("Clock offset:", offset)
+
+	myMetric = c.metricsMapEsxHost[esxClockOffset]
+	if myMetric != nil {
+		myMetric.WithLabelValues(datacenterStr).Set(offset.Seconds())
+	}
+
+	log.Debugln("setEsxNtpMetrics Succeeded")
+	log.Debugln("setEsxNtpMetrics LEAVE")
+
+	return nil
+}
+
+//clockOffset compares the remote time against the middle of the round trip
+//so the latency of the call itself does not show up as drift. A positive
+//offset means the host clock is ahead of the exporter.
+func clockOffset(before time.Time, after time.Time, remote time.Time) time.Duration {
+	local := before.Add(after.Sub(before) / 2)
+	return remote.Sub(local)
+}
@@ -0,0 +1,113 @@
+/*
+Copyright (c) 2018 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package vsphere
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	assert "github.com/stretchr/testify/assert"
+
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/vim25/methods"
+	"github.com/vmware/govmomi/vim25/soap"
+	"github.com/vmware/govmomi/vim25/types"
+
+	config "github.com/dvonthenen/vsphere-metrics-prometheus/config"
+)
+
+func TestClockOffset(t *testing.T) {
+	before := time.Date(2018, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		after  time.Time
+		remote time.Time
+		offset time.Duration
+	}{
+		{"in sync", before.Add(2 * time.Second), before.Add(time.Second), 0},
+		{"ahead", before.Add(2 * time.Second), before.Add(4 * time.Second), 3 * time.Second},
+		{"behind", before.Add(2 * time.Second), before.Add(-time.Minute), -time.Minute - time.Second},
+		{"instant", before, before.Add(500 * time.Millisecond), 500 * time.Millisecond},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.offset, clockOffset(before, test.after, test.remote), test.name)
+	}
+}
+
+func TestSetEsxNtpMetrics(t *testing.T) {
+	c := NewClient(&config.Config{})
+	c.metricsMapEsxHost[esxNtpServer] = prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "test"}, []string{"datacenter", "server"})
+	for _, key := range []int{esxNtpRunning, esxClockOffset} {
+		c.metricsMapEsxHost[key] = prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "test"}, []string{"datacenter"})
+	}
+
+	// host-1 syncs with two servers and its clock is an hour ahead. host-2
+	// has no NTP configured and does not answer QueryDateTime.
+	ref := func(kind string, value string) types.ManagedObjectReference {
+		return types.ManagedObjectReference{Type: kind, Value: value}
+	}
+	s := newFakeSession(&fakeVCenter{
+		properties: func(ctx context.Context, obj types.ManagedObjectReference) []types.DynamicProperty {
+			switch obj.Type {
+			case "HostSystem":
+				return []types.DynamicProperty{
+					{Name: "configManager.dateTimeSystem", Val: ref("HostDateTimeSystem", obj.Value)},
+					{Name: "configManager.serviceSystem", Val: ref("HostServiceSystem", obj.Value)},
+				}
+			case "HostDateTimeSystem":
+				info := types.HostDateTimeInfo{}
+				if obj.Value == "host-1" {
+					info.NtpConfig = &types.HostNtpConfig{Server: []string{"0.pool.ntp.org", "1.pool.ntp.org"}}
+				}
+				return []types.DynamicProperty{{Name: "dateTimeInfo", Val: info}}
+			case "HostServiceSystem":
+				services := []types.HostService{{Key: "TSM-SSH", Running: true}}
+				if obj.Value == "host-1" {
+					services = append(services, types.HostService{Key: esxNtpServiceKey, Running: true})
+				}
+				return []types.DynamicProperty{{Name: "serviceInfo.service", Val: types.ArrayOfHostService{HostService: services}}}
+			}
+			return nil
+		},
+		call: func(ctx context.Context, req, res soap.HasFault) error {
+			body, ok := req.(*methods.QueryDateTimeBody)
+			if !ok || body.Req.This.Value != "host-1" {
+				return errors.New("host not responding")
+			}
+			res.(*methods.QueryDateTimeBody).Res = &types.QueryDateTimeResponse{Returnval: time.Now().Add(time.Hour)}
+			return nil
+		},
+	})
+
+	host := object.NewHostSystem(s.client.Client, ref("HostSystem", "host-1"))
+	assert.NoError(t, c.setEsxNtpMetrics(s, "dc1", host))
+	assert.Equal(t, 2, seriesCount(c.metricsMapEsxHost[esxNtpServer]))
+	assert.Equal(t, 1.0, vecValue(t, c.metricsMapEsxHost[esxNtpServer], "dc1", "1.pool.ntp.org"))
+	assert.Equal(t, 1.0, vecValue(t, c.metricsMapEsxHost[esxNtpRunning], "dc1"))
+	assert.InDelta(t, time.Hour.Seconds(), vecValue(t, c.metricsMapEsxHost[esxClockOffset], "dc1"), 5)
+
+	host = object.NewHostSystem(s.client.Client, ref("HostSystem", "host-2"))
+	assert.Error(t, c.setEsxNtpMetrics(s, "dc1", host))
+	assert.Equal(t, 0, seriesCount(c.metricsMapEsxHost[esxNtpServer]))
+	assert.Equal(t, 1, seriesCount(c.metricsMapEsxHost[esxNtpRunning]))
+	assert.Equal(t, 0.0, vecValue(t, c.metricsMapEsxHost[esxNtpRunning], "dc1"))
+	assert.Equal(t, 0, seriesCount(c.metricsMapEsxHost[esxClockOffset]))
+}
@@ -0,0 +1,323 @@
+/*
+Copyright (c) 2018 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"context"
+	"crypto/x509"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/vim25/soap"
+)
+
+//SelfRegistry holds the metrics about the exporter itself. They are served on
+//their own endpoint, so reading them does not query vCenter.
+var SelfRegistry = prometheus.NewRegistry()
+
+var (
+	metricSoapDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "vsphere",
+			Subsystem: "exporter",
+			Name:      "soap_request_duration_seconds",
+			Help:      "Duration of the SOAP calls to vCenter by method",
+			Buckets:   []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60},
+		},
+		[]string{"method"},
+	)
+
+	metricSoapFaults = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "vsphere",
+			Subsystem: "exporter",
+			Name:      "soap_faults_total",
+			Help:      "SOAP calls to vCenter that failed, by method and fault type",
+		},
+		[]string{"method", "fault"},
+	)
+
+	metricLogins = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "vsphere",
+			Subsystem: "exporter",
+			Name:      "logins_total",
+			Help:      "Logins to vCenter by result",
+		},
+		[]string{"result"},
+	)
+
+	metricRelogins = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "vsphere",
+			Subsystem: "exporter",
+			Name:      "relogins_total",
+			Help:      "Logins to vCenter that replaced a session vCenter no longer knew",
+		},
+	)
+
+	metricScrapeDuration = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "vsphere",
+			Subsystem: "exporter",
+			Name:      "scrape_duration_seconds",
+			Help:      "Duration of the last scrape by vCenter and role",
+		},
+		[]string{"vcenter", "role"},
+	)
+
+	metricScrapeSuccess = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "vsphere",
+			Subsystem: "exporter",
+			Name:      "scrape_success",
+			Help:      "1 if the last scrape of the vCenter and role succeeded",
+		},
+		[]string{"vcenter", "role"},
+	)
+
+	metricVCenterCertificateNotAfter = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "vsphere",
+			Subsystem: "exporter",
+			Name:      "vcenter_certificate_not_after_timestamp_seconds",
+			Help:      "End of the validity period of the certificate vCenter presented on the last handshake",
+		},
+		[]string{"vcenter", "issuer", "subject"},
+	)
+
+	//vcenterCertificates holds the labels of the certificate series of each
+	//vCenter, vcenterCertificateMutex keeps concurrent handshakes apart
+	vcenterCertificates     = make(map[string][]string)
+	vcenterCertificateMutex sync.Mutex
+)
+
+//selfMetricsOnce registers the metrics shared by all clients
+var selfMetricsOnce sync.Once
+
+//sessionAges reports the session age of the client of each vCenter
+var sessionAges = &sessionAgeCollector{
+	desc: prometheus.NewDesc(
+		"vsphere_exporter_session_age_seconds",
+		"Age of the current vCenter session, 0 without a session",
+		[]string{"vcenter"},
+		nil,
+	),
+	clients: make(map[string][]*Client),
+}
+
+//registerSelfMetrics registers the metrics about the exporter itself and
+//reports the session of the client
+func (c *Client) registerSelfMetrics() {
+	selfMetricsOnce.Do(func() {
+		SelfRegistry.MustRegister(
+			prometheus.NewGoCollector(),
+			prometheus.NewProcessCollector(os.Getpid(), ""),
+			metricSoapDuration,
+			metricSoapFaults,
+			metricLogins,
+			metricRelogins,
+			metricScrapeDuration,
+			metricScrapeSuccess,
+			metricVCenterCertificateNotAfter,
+			sessionAges,
+		)
+	})
+
+	sessionAges.add(c)
+}
+
+//sessionAgeCollector collects the session age of the running clients. A
+//client built on a reload takes over the series of its vCenter, and hands
+//it back when it is closed without being used.
+type sessionAgeCollector struct {
+	desc    *prometheus.Desc
+	mutex   sync.Mutex
+	clients map[string][]*Client
+}
+
+//add reports the session of the client for its vCenter
+func (s *sessionAgeCollector) add(c *Client) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	hostname := c.config.VSphereHostname
+	s.clients[hostname] = append(s.clients[hostname], c)
+}
+
+//remove stops reporting the session of the client
+func (s *sessionAgeCollector) remove(c *Client) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	hostname := c.config.VSphereHostname
+	var clients []*Client
+	for _, client := range s.clients[hostname] {
+		if client != c {
+			clients = append(clients, client)
+		}
+	}
+
+	if len(clients) == 0 {
+		delete(s.clients, hostname)
+	} else {
+		s.clients[hostname] = clients
+	}
+}
+
+//Describe implements prometheus.Collector
+func (s *sessionAgeCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- s.desc
+}
+
+//Collect implements prometheus.Collector
+func (s *sessionAgeCollector) Collect(ch chan<- prometheus.Metric) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for vcenter, clients := range s.clients {
+		c := clients[len(clients)-1]
+		ch <- prometheus.MustNewConstMetric(s.desc, prometheus.GaugeValue, c.sessionAge(), vcenter)
+	}
+}
+
+//sessionAge returns the age of the current session in seconds
+func (c *Client) sessionAge() float64 {
+	c.sessions.mutex.Lock()
+	defer c.sessions.mutex.Unlock()
+
+	if c.sessions.current == nil {
+		return 0
+	}
+	return time.Since(c.sessions.current.created).Seconds()
+}
+
+//ObserveScrape records the duration and result of a scrape. An entity that
+//is filtered out counts as a successful scrape.
+func (c *Client) ObserveScrape(duration time.Duration, err error) {
+	vcenter := c.config.VSphereHostname
+	role := c.config.VSphereType
+
+	metricScrapeDuration.WithLabelValues(vcenter, role).Set(duration.Seconds())
+	if err == nil || err == ErrEntityFiltered {
+		metricScrapeSuccess.WithLabelValues(vcenter, role).Set(1)
+	} else {
+		metricScrapeSuccess.WithLabelValues(vcenter, role).Set(0)
+	}
+}
+
+//observeLogin counts a login and whether it replaced an expired session
+func observeLogin(relogin bool, err error) {
+	if err != nil {
+		metricLogins.WithLabelValues("failure").Inc()
+		return
+	}
+
+	metricLogins.WithLabelValues("success").Inc()
+	if relogin {
+		metricRelogins.Inc()
+	}
+}
+
+//instrumentedRoundTripper times the SOAP calls and counts their faults
+type instrumentedRoundTripper struct {
+	roundTripper soap.RoundTripper
+}
+
+//RoundTrip implements soap.RoundTripper
+func (i *instrumentedRoundTripper) RoundTrip(ctx context.Context, req, res soap.HasFault) error {
+	method := soapMethod(req)
+
+	start := time.Now()
+	err := i.roundTripper.RoundTrip(ctx, req, res)
+	metricSoapDuration.WithLabelValues(method).Observe(time.Since(start).Seconds())
+
+	if err != nil {
+		metricSoapFaults.WithLabelValues(method, faultType(ctx, err)).Inc()
+	}
+
+	return err
+}
+
+//soapMethod returns the method of a request body, e.g. QueryPerf for a
+//*methods.QueryPerfBody
+func soapMethod(req soap.HasFault) string {
+	if named, ok := req.(interface{ method() string }); ok {
+		return named.method()
+	}
+
+	t := reflect.TypeOf(req)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return strings.TrimSuffix(t.Name(), "Body")
+}
+
+//faultType names the fault of a failed call: the vim fault type like
+//NotAuthenticated, the SOAP fault code, or what ended the call otherwise
+func faultType(ctx context.Context, err error) string {
+	if soap.IsSoapFault(err) {
+		fault := soap.ToSoapFault(err)
+		if vimFault := fault.VimFault(); vimFault != nil {
+			return typeName(vimFault)
+		}
+		return fault.Code
+	}
+	if soap.IsVimFault(err) {
+		return typeName(soap.ToVimFault(err))
+	}
+
+	switch ctx.Err() {
+	case context.DeadlineExceeded:
+		return "DeadlineExceeded"
+	case context.Canceled:
+		return "Canceled"
+	}
+
+	return "TransportError"
+}
+
+func typeName(value interface{}) string {
+	t := reflect.TypeOf(value)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.Name()
+}
+
+//setVCenterCertificate replaces the certificate series of the vCenter, so a
+//rotated certificate does not leave the old issuer and subject behind
+func setVCenterCertificate(hostname string, cert *x509.Certificate) {
+	info := &object.HostCertificateInfo{}
+	info.FromCertificate(cert)
+	labels := []string{hostname, info.Issuer, info.Subject}
+
+	vcenterCertificateMutex.Lock()
+	defer vcenterCertificateMutex.Unlock()
+
+	if previous, ok := vcenterCertificates[hostname]; ok {
+		metricVCenterCertificateNotAfter.DeleteLabelValues(previous...)
+	}
+	vcenterCertificates[hostname] = labels
+	metricVCenterCertificateNotAfter.WithLabelValues(labels...).Set(float64(cert.NotAfter.Unix()))
+}
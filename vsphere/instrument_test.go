@@ -0,0 +1,161 @@
+/*
+Copyright (c) 2018 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package vsphere
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	assert "github.com/stretchr/testify/assert"
+
+	"github.com/vmware/govmomi/vim25/methods"
+	"github.com/vmware/govmomi/vim25/soap"
+	"github.com/vmware/govmomi/vim25/types"
+
+	config "github.com/dvonthenen/vsphere-metrics-prometheus/config"
+)
+
+type fakeRoundTripper struct {
+	err error
+}
+
+func (f *fakeRoundTripper) RoundTrip(ctx context.Context, req, res soap.HasFault) error {
+	return f.err
+}
+
+func counterValue(t *testing.T, collector prometheus.Collector) float64 {
+	var metric dto.Metric
+	ch := make(chan prometheus.Metric, 1)
+	collector.Collect(ch)
+	assert.NoError(t, (<-ch).Write(&metric))
+	if metric.GetCounter() != nil {
+		return metric.GetCounter().GetValue()
+	}
+	return metric.GetGauge().GetValue()
+}
+
+func TestInstrumentedRoundTripper(t *testing.T) {
+	notAuthenticated := soap.WrapSoapFault(&soap.Fault{
+		Code: "ServerFaultCode",
+		Detail: struct {
+			Fault types.AnyType `xml:",any,typeattr"`
+		}{Fault: types.NotAuthenticated{}},
+	})
+
+	rt := &instrumentedRoundTripper{roundTripper: &fakeRoundTripper{err: notAuthenticated}}
+	err := rt.RoundTrip(context.Background(), &methods.QueryPerfBody{}, &methods.QueryPerfBody{})
+	assert.Equal(t, notAuthenticated, err)
+	assert.Equal(t, 1.0, counterValue(t, metricSoapFaults.WithLabelValues("QueryPerf", "NotAuthenticated")))
+
+	rt.roundTripper = &fakeRoundTripper{}
+	assert.NoError(t, rt.RoundTrip(context.Background(), &methods.FindByInventoryPathBody{}, &methods.FindByInventoryPathBody{}))
+
+	var metric dto.Metric
+	assert.NoError(t, metricSoapDuration.WithLabelValues("FindByInventoryPath").(prometheus.Metric).Write(&metric))
+	assert.Equal(t, uint64(1), metric.GetHistogram().GetSampleCount())
+}
+
+func TestFaultType(t *testing.T) {
+	ctx := context.Background()
+
+	assert.Equal(t, "ServerFaultCode", faultType(ctx, soap.WrapSoapFault(&soap.Fault{Code: "ServerFaultCode"})))
+	assert.Equal(t, "InvalidLogin", faultType(ctx, soap.WrapVimFault(&types.InvalidLogin{})))
+	assert.Equal(t, "TransportError", faultType(ctx, errors.New("connection refused")))
+
+	expired, cancel := context.WithTimeout(ctx, time.Nanosecond)
+	defer cancel()
+	<-expired.Done()
+	assert.Equal(t, "DeadlineExceeded", faultType(expired, errors.New("context deadline exceeded")))
+}
+
+func TestSoapMethod(t *testing.T) {
+	assert.Equal(t, "RetrieveProperties", soapMethod(&methods.RetrievePropertiesBody{}))
+	assert.Equal(t, "QueryPerf", soapMethod(&methods.QueryPerfBody{}))
+}
+
+func TestObserveScrape(t *testing.T) {
+	c := &Client{config: &config.Config{VSphereHostname: "vcenter", VSphereType: string(config.VSphereRoleEsx)}}
+
+	c.ObserveScrape(2*time.Second, nil)
+	assert.Equal(t, 2.0, counterValue(t, metricScrapeDuration.WithLabelValues("vcenter", "esx")))
+	assert.Equal(t, 1.0, counterValue(t, metricScrapeSuccess.WithLabelValues("vcenter", "esx")))
+
+	c.ObserveScrape(time.Second, ErrScrapeTimeout)
+	assert.Equal(t, 0.0, counterValue(t, metricScrapeSuccess.WithLabelValues("vcenter", "esx")))
+
+	c.ObserveScrape(time.Second, ErrEntityFiltered)
+	assert.Equal(t, 1.0, counterValue(t, metricScrapeSuccess.WithLabelValues("vcenter", "esx")))
+
+	assert.Equal(t, 0.0, c.sessionAge())
+	c.sessions.current = &session{created: time.Now().Add(-time.Minute)}
+	assert.InDelta(t, 60.0, c.sessionAge(), 1.0)
+}
+
+func TestSessionAges(t *testing.T) {
+	ages := &sessionAgeCollector{desc: sessionAges.desc, clients: make(map[string][]*Client)}
+	running := &Client{config: &config.Config{VSphereHostname: "vcenter"}}
+	running.sessions.current = &session{created: time.Now().Add(-time.Minute)}
+	ages.add(running)
+	ages.add(&Client{config: &config.Config{VSphereHostname: "other"}})
+	assert.Equal(t, 2, seriesCount(ages))
+
+	// A client built on a reload takes over the vCenter of the running one,
+	// and hands it back when the reload fails
+	failed := &Client{config: &config.Config{VSphereHostname: "vcenter"}}
+	ages.add(failed)
+	assert.Equal(t, 2, seriesCount(ages))
+	ages.remove(failed)
+	assert.Equal(t, []*Client{running}, ages.clients["vcenter"])
+
+	next := &Client{config: &config.Config{VSphereHostname: "vcenter"}}
+	ages.add(next)
+	ages.remove(running)
+	assert.Equal(t, 2, seriesCount(ages))
+	assert.Equal(t, []*Client{next}, ages.clients["vcenter"])
+
+	ages.remove(next)
+	assert.Equal(t, 1, seriesCount(ages))
+}
+
+func TestSetVCenterCertificate(t *testing.T) {
+	metricVCenterCertificateNotAfter.Reset()
+	first, err := x509.ParseCertificate(newTestCertificate(t).Certificate[0])
+	assert.NoError(t, err)
+	second, err := x509.ParseCertificate(newTestCertificate(t).Certificate[0])
+	assert.NoError(t, err)
+
+	// Each vCenter keeps one series, the handshakes of another one leave it alone
+	setVCenterCertificate("vc1", first)
+	setVCenterCertificate("vc2", first)
+	setVCenterCertificate("vc1", second)
+	assert.Equal(t, 2, seriesCount(metricVCenterCertificateNotAfter))
+}
+
+func TestObserveLogin(t *testing.T) {
+	before := counterValue(t, metricRelogins)
+
+	observeLogin(true, nil)
+	observeLogin(false, nil)
+	observeLogin(true, errors.New("wrong password"))
+
+	assert.Equal(t, before+1, counterValue(t, metricRelogins))
+	assert.Equal(t, 1.0, counterValue(t, metricLogins.WithLabelValues("failure")))
+}
@@ -0,0 +1,250 @@
+/*
+Copyright (c) 2018 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/vmware/govmomi/vim25/methods"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+const (
+	licenseInfo = (iota + 3072)
+	licenseTotal
+	licenseUsed
+	licenseExpiration
+	licenseAssignment
+)
+
+func (c *Client) registerLicenseMetrics() error {
+	log.Debugln("registerLicenseMetrics ENTER")
+
+	labels := []string{"license", "name", "edition", "cost_unit"}
+
+	//info
+	metricName := fmt.Sprintf("%d_info", licenseInfo)
+	log.Debugln("Key:", metricName)
+
+	myMetric := prometheus.NewGaugeVec(
+		c.gaugeOpts("license", metricName, "info", "License known to vCenter"),
+		labels,
+	)
+	c.metricsMapLicense[licenseInfo] = myMetric
+	c.registry.MustRegister(myMetric)
+
+	//total
+	metricName = fmt.Sprintf("%d_total", licenseTotal)
+	log.Debugln("Key:", metricName)
+
+	myMetric = prometheus.NewGaugeVec(
+		c.gaugeOpts("license", metricName, "total", "Total capacity of the license in its cost unit"),
+		labels,
+	)
+	c.metricsMapLicense[licenseTotal] = myMetric
+	c.registry.MustRegister(myMetric)
+
+	//used
+	metricName = fmt.Sprintf("%d_used", licenseUsed)
+	log.Debugln("Key:", metricName)
+
+	myMetric = prometheus.NewGaugeVec(
+		c.gaugeOpts("license", metricName, "used", "Used capacity of the license in its cost unit"),
+		labels,
+	)
+	c.metricsMapLicense[licenseUsed] = myMetric
+	c.registry.MustRegister(myMetric)
+
+	//expiration
+	metricName = fmt.Sprintf("%d_expiration_timestamp_seconds", licenseExpiration)
+	log.Debugln("Key:", metricName)
+
+	myMetric = prometheus.NewGaugeVec(
+		c.gaugeOpts("license", metricName, "expiration_timestamp_seconds", "Expiration date of the license"),
+		labels,
+	)
+	c.metricsMapLicense[licenseExpiration] = myMetric
+	c.registry.MustRegister(myMetric)
+
+	//assignment
+	metricName = fmt.Sprintf("%d_assignment_info", licenseAssignment)
+	log.Debugln("Key:", metricName)
+
+	myMetric = prometheus.NewGaugeVec(
+		c.gaugeOpts("license", metricName, "assignment_info", "Entity the license is assigned to"),
+		[]string{"license", "name", "entity", "entity_name"},
+	)
+	c.metricsMapLicense[licenseAssignment] = myMetric
+	c.registry.MustRegister(myMetric)
+
+	log.Debugln("registerLicenseMetrics Succeeded")
+	log.Debugln("registerLicenseMetrics LEAVE")
+
+	return nil
+}
+
+//GetVSphereLicenseStats gets the license usage for the vCenter Server
+func (c *Client) GetVSphereLicenseStats(w http.ResponseWriter, r *http.Request) error {
+	log.Debugln("GetVSphereLicenseStats ENTER")
+
+	ctx, cancel := c.scrapeContext(r)
+	defer c.endScrape(ctx, cancel)
+
+	// Create client
+	s, err := c.getSession(ctx)
+	if err != nil {
+		err = scrapeError(ctx, w, err, "Unable connect to the vCenter Server", http.StatusGone)
+		log.Errorln("getSession failed:", err)
+		log.Debugln("GetVSphereLicenseStats LEAVE")
+
+		return err
+	}
+
+	if s.client.ServiceContent.LicenseManager == nil {
+		http.Error(w, "Unable find the LicenseManager", http.StatusGone)
+		log.Errorln("LicenseManager is nil")
+		log.Debugln("GetVSphereLicenseStats LEAVE")
+		return ErrLicenseManagerNil
+	}
+
+	var licenseManager mo.LicenseManager
+	err = s.client.RetrieveOne(s.ctx, *s.client.ServiceContent.LicenseManager, []string{"licenses", "licenseAssignmentManager"}, &licenseManager)
+	if err != nil {
+		err = scrapeError(ctx, w, err, "Unable get the LicenseManager properties", http.StatusBadRequest)
+		log.Errorln("RetrieveOne failed:", err)
+		log.Debugln("GetVSphereLicenseStats LEAVE")
+		return err
+	}
+
+	// Licenses come and go so start from a clean slate each time
+	for _, myMetric := range c.metricsMapLicense {
+		myMetric.Reset()
+	}
+
+	for _, license := range licenseManager.Licenses {
+		key := maskLicenseKey(license.LicenseKey)
+		log.Debugln("License:", key, license.Name, license.EditionKey)
+
+		myMetric := c.metricsMapLicense[licenseInfo]
+		if myMetric != nil {
+			myMetric.WithLabelValues(key, license.Name, license.EditionKey, license.CostUnit).Set(1)
+		}
+		myMetric = c.metricsMapLicense[licenseTotal]
+		if myMetric != nil {
+			myMetric.WithLabelValues(key, license.Name, license.EditionKey, license.CostUnit).Set(float64(license.Total))
+		}
+		myMetric = c.metricsMapLicense[licenseUsed]
+		if myMetric != nil {
+			myMetric.WithLabelValues(key, license.Name, license.EditionKey, license.CostUnit).Set(float64(license.Used))
+		}
+		myMetric = c.metricsMapLicense[licenseExpiration]
+		if myMetric != nil {
+			expiration, ok := licenseExpirationDate(license, time.Now())
+			if ok {
+				myMetric.WithLabelValues(key, license.Name, license.EditionKey, license.CostUnit).Set(float64(expiration.Unix()))
+			}
+		}
+	}
+
+	if licenseManager.LicenseAssignmentManager != nil {
+		req := types.QueryAssignedLicenses{
+			This: *licenseManager.LicenseAssignmentManager,
+		}
+
+		res, err := methods.QueryAssignedLicenses(s.ctx, s.client, &req)
+		if err != nil {
+			log.Errorln("QueryAssignedLicenses failed:", err)
+			log.Debugln("GetVSphereLicenseStats LEAVE")
+			return err
+		}
+
+		myMetric := c.metricsMapLicense[licenseAssignment]
+		if myMetric != nil {
+			for _, assignment := range res.Returnval {
+				key := maskLicenseKey(assignment.AssignedLicense.LicenseKey)
+				myMetric.WithLabelValues(key, assignment.AssignedLicense.Name, assignment.EntityId, assignment.EntityDisplayName).Set(1)
+			}
+		}
+	}
+
+	log.Debugln("GetVSphereLicenseStats Succeeded")
+	log.Debugln("GetVSphereLicenseStats LEAVE")
+
+	return nil
+}
+
+//licenseExpirationDate returns the expiration of evaluation and term
+//licenses. Term licenses carry an expirationDate, evaluation licenses only
+//the expirationHours left from now. Perpetual licenses have neither.
+func licenseExpirationDate(license types.LicenseManagerLicenseInfo, now time.Time) (time.Time, bool) {
+	for _, property := range license.Properties {
+		if property.Key != "expirationDate" {
+			continue
+		}
+		if expiration, ok := property.Value.(time.Time); ok {
+			return expiration, true
+		}
+	}
+
+	for _, property := range license.Properties {
+		if property.Key != "expirationHours" {
+			continue
+		}
+		switch hours := property.Value.(type) {
+		case int32:
+			return now.Add(time.Duration(hours) * time.Hour), true
+		case int64:
+			return now.Add(time.Duration(hours) * time.Hour), true
+		}
+	}
+
+	return time.Time{}, false
+}
+
+//maskLicenseKey hides all but the last group of the license key so the
+//series can be told apart without publishing the key itself. A key that is
+//not made of five groups of five is masked completely.
+func maskLicenseKey(key string) string {
+	groups := strings.Split(key, "-")
+	wellFormed := len(groups) == 5
+	for _, group := range groups {
+		if len(group) != 5 {
+			wellFormed = false
+		}
+	}
+
+	masked := []byte(key)
+	last := len(masked)
+	if wellFormed {
+		last -= 5
+	}
+	for i := 0; i < last; i++ {
+		if masked[i] != '-' {
+			masked[i] = 'X'
+		}
+	}
+
+	return string(masked)
+}
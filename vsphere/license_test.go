@@ -0,0 +1,73 @@
+/*
+Copyright (c) 2018 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package vsphere
+
+import (
+	"testing"
+	"time"
+
+	assert "github.com/stretchr/testify/assert"
+
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+func TestMaskLicenseKey(t *testing.T) {
+	tests := []struct {
+		key    string
+		masked string
+	}{
+		{"AAAAA-BBBBB-CCCCC-DDDDD-EEEEE", "XXXXX-XXXXX-XXXXX-XXXXX-EEEEE"},
+		{"00000-00000-00000-00000-00000", "XXXXX-XXXXX-XXXXX-XXXXX-00000"},
+		{"", ""},
+		{"ABC", "XXX"},
+		{"EEEEE", "XXXXX"},
+		{"AAAAA-BBBBB-CCCCC", "XXXXX-XXXXX-XXXXX"},
+		{"AAAA-BBBBBB-CCCCC-DDDDD-EEEEE", "XXXX-XXXXXX-XXXXX-XXXXX-XXXXX"},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.masked, maskLicenseKey(test.key), test.key)
+	}
+}
+
+func TestLicenseExpirationDate(t *testing.T) {
+	now := time.Date(2018, 6, 1, 0, 0, 0, 0, time.UTC)
+	date := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name       string
+		properties []types.KeyAnyValue
+		expiration time.Time
+		ok         bool
+	}{
+		{"perpetual", nil, time.Time{}, false},
+		{"other properties", []types.KeyAnyValue{{Key: "feature", Value: "vmotion"}}, time.Time{}, false},
+		{"term", []types.KeyAnyValue{{Key: "expirationDate", Value: date}}, date, true},
+		{"evaluation", []types.KeyAnyValue{{Key: "expirationHours", Value: int32(48)}}, now.Add(48 * time.Hour), true},
+		{"both", []types.KeyAnyValue{
+			{Key: "expirationHours", Value: int32(48)},
+			{Key: "expirationDate", Value: date},
+		}, date, true},
+		{"malformed", []types.KeyAnyValue{{Key: "expirationDate", Value: "tomorrow"}}, time.Time{}, false},
+	}
+
+	for _, test := range tests {
+		license := types.LicenseManagerLicenseInfo{Properties: test.properties}
+		expiration, ok := licenseExpirationDate(license, now)
+		assert.Equal(t, test.ok, ok, test.name)
+		assert.Equal(t, test.expiration, expiration, test.name)
+	}
+}
@@ -0,0 +1,169 @@
+/*
+Copyright (c) 2018 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+var (
+	//stableSubsystems renames the role based subsystems where the role name
+	//does not describe the entity
+	stableSubsystems = map[string]string{
+		"esx": "host",
+	}
+
+	//baseUnits maps the units vSphere reports in onto the Prometheus base unit
+	baseUnits = map[string]baseUnit{
+		// percentages are reported in hundredths of a percent
+		unitPercent:            {"ratio", 0.0001},
+		unitKiloBytes:          {"bytes", 1024},
+		unitMegaBytes:          {"bytes", 1024 * 1024},
+		unitTeraBytes:          {"bytes", 1024 * 1024 * 1024 * 1024},
+		unitKiloBytesPerSecond: {"bytes_per_second", 1024},
+		unitMegaBytesPerSecond: {"bytes_per_second", 1024 * 1024},
+		unitMegaHertz:          {"hertz", 1000 * 1000},
+		unitMicrosecond:        {"seconds", 0.000001},
+		unitMillisecond:        {"seconds", 0.001},
+		unitSecond:             {"seconds", 1},
+		unitWatt:               {"watts", 1},
+		unitJoule:              {"joules", 1},
+		unitCelsius:            {"celsius", 1},
+		unitNumber:             {"", 1},
+	}
+)
+
+//Units as reported by PerfCounterInfo.UnitInfo
+const (
+	unitPercent            = "percent"
+	unitKiloBytes          = "kiloBytes"
+	unitMegaBytes          = "megaBytes"
+	unitTeraBytes          = "teraBytes"
+	unitKiloBytesPerSecond = "kiloBytesPerSecond"
+	unitMegaBytesPerSecond = "megaBytesPerSecond"
+	unitMegaHertz          = "megaHertz"
+	unitMicrosecond        = "microsecond"
+	unitMillisecond        = "millisecond"
+	unitSecond             = "second"
+	unitWatt               = "watt"
+	unitJoule              = "joule"
+	unitCelsius            = "celsius"
+	unitNumber             = "number"
+)
+
+//baseUnit is the metric name suffix of a unit and the factor to convert a
+//value to it
+type baseUnit struct {
+	suffix string
+	scale  float64
+}
+
+//gaugeOpts returns the options of a metric. The legacy name is the
+//<key>_<name> scheme of earlier releases and is only used in legacy mode.
+func (c *Client) gaugeOpts(subsystem string, legacyName string, name string, help string) prometheus.GaugeOpts {
+	if c.config.LegacyMetricNames {
+		return prometheus.GaugeOpts{
+			Namespace: "vsphere",
+			Subsystem: subsystem,
+			Name:      legacyName,
+			Help:      legacyName,
+		}
+	}
+
+	if stable, ok := stableSubsystems[subsystem]; ok {
+		subsystem = stable
+	}
+
+	return prometheus.GaugeOpts{
+		Namespace: "vsphere",
+		Subsystem: subsystem,
+		Name:      name,
+		Help:      help,
+	}
+}
+
+//normalize converts a value reported in the given vSphere unit to its base
+//unit. Values are left untouched in legacy mode to match the legacy names.
+func (c *Client) normalize(value float64, unit string) float64 {
+	if c.config.LegacyMetricNames {
+		return value
+	}
+
+	if base, ok := baseUnits[unit]; ok {
+		return value * base.scale
+	}
+
+	return value
+}
+
+//perfCounterPerInterval tells whether the counter is the total of the
+//sampling interval, e.g. net.packetsRx.summation, rather than a level or rate
+func perfCounterPerInterval(info *types.PerfCounterInfo) bool {
+	return info.StatsType == types.PerfStatsTypeDelta || info.RollupType == types.PerfSummaryTypeSummation
+}
+
+//perfCounterValue converts a sample to the value of the metric. Totals of the
+//sampling interval are turned into per second rates: the scrapes of all
+//entities share the same series, so they cannot be accumulated into a counter.
+func (c *Client) perfCounterValue(info types.PerfCounterInfo, sample int64, interval int32) float64 {
+	value := c.normalize(float64(sample), info.UnitInfo.GetElementDescription().Key)
+	if c.config.LegacyMetricNames || !perfCounterPerInterval(&info) || interval <= 0 {
+		return value
+	}
+
+	return value / float64(interval)
+}
+
+//perfCounterName derives the metric name from the group, name, rollup and
+//base unit of a perf counter, e.g. cpu.usage.average in percent becomes
+//cpu_usage_average_ratio. Totals of the sampling interval get a _per_second
+//suffix. Unlike the counter key the name is the same on every vCenter.
+func perfCounterName(info *types.PerfCounterInfo) string {
+	parts := []string{
+		info.GroupInfo.GetElementDescription().Key,
+		info.NameInfo.GetElementDescription().Key,
+		string(info.RollupType),
+	}
+	if base := baseUnits[info.UnitInfo.GetElementDescription().Key]; base.suffix != "" {
+		parts = append(parts, base.suffix)
+	}
+	if perfCounterPerInterval(info) {
+		parts = append(parts, "per_second")
+	}
+
+	return sanitizeLabelName(strings.Join(parts, "_"))
+}
+
+//perfCounterHelp describes a perf counter using the summary vCenter provides
+func perfCounterHelp(info *types.PerfCounterInfo) string {
+	nameInfo := info.NameInfo.GetElementDescription()
+	help := nameInfo.Summary
+	if help == "" {
+		help = nameInfo.Label
+	}
+
+	help += " (" + info.GroupInfo.GetElementDescription().Key + "." + nameInfo.Key + "." + string(info.RollupType) + ")"
+	if perfCounterPerInterval(info) {
+		help += ", per second rate of the interval total"
+	}
+
+	return help
+}
@@ -0,0 +1,100 @@
+/*
+Copyright (c) 2018 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package vsphere
+
+import (
+	"testing"
+
+	assert "github.com/stretchr/testify/assert"
+
+	"github.com/vmware/govmomi/vim25/types"
+
+	config "github.com/dvonthenen/vsphere-metrics-prometheus/config"
+)
+
+func newPerfCounterInfo(group string, name string, summary string, rollup types.PerfSummaryType, stats types.PerfStatsType, unit string) *types.PerfCounterInfo {
+	return &types.PerfCounterInfo{
+		Key:        2,
+		GroupInfo:  &types.ElementDescription{Key: group},
+		NameInfo:   &types.ElementDescription{Key: name, Description: types.Description{Summary: summary}},
+		UnitInfo:   &types.ElementDescription{Key: unit},
+		RollupType: rollup,
+		StatsType:  stats,
+	}
+}
+
+func TestPerfCounterName(t *testing.T) {
+	info := newPerfCounterInfo("cpu", "usage", "CPU usage as a percentage during the interval", types.PerfSummaryTypeAverage, types.PerfStatsTypeRate, "percent")
+	assert.Equal(t, "cpu_usage_average_ratio", perfCounterName(info))
+	assert.Equal(t, "CPU usage as a percentage during the interval (cpu.usage.average)", perfCounterHelp(info))
+
+	info = newPerfCounterInfo("net", "packetsRx", "Number of packets received", types.PerfSummaryTypeSummation, types.PerfStatsTypeDelta, "number")
+	assert.Equal(t, "net_packets_rx_summation_per_second", perfCounterName(info))
+	assert.Equal(t, "Number of packets received (net.packetsRx.summation), per second rate of the interval total", perfCounterHelp(info))
+
+	info = newPerfCounterInfo("disk", "maxTotalLatency", "", types.PerfSummaryTypeLatest, types.PerfStatsTypeAbsolute, "millisecond")
+	assert.Equal(t, "disk_max_total_latency_latest_seconds", perfCounterName(info))
+}
+
+func TestGaugeOpts(t *testing.T) {
+	c := &Client{config: &config.Config{}}
+
+	opts := c.gaugeOpts("esx", "2_usage", "cpu_usage_average_percent", "CPU usage")
+	assert.Equal(t, "host", opts.Subsystem)
+	assert.Equal(t, "cpu_usage_average_percent", opts.Name)
+	assert.Equal(t, "CPU usage", opts.Help)
+
+	opts = c.gaugeOpts("vm", "2055_guest_memoruy_usage", "guest_memory_usage_megabytes", "Guest memory")
+	assert.Equal(t, "vm", opts.Subsystem)
+	assert.Equal(t, "guest_memory_usage_megabytes", opts.Name)
+
+	c.config.LegacyMetricNames = true
+
+	opts = c.gaugeOpts("esx", "2_usage", "cpu_usage_average_percent", "CPU usage")
+	assert.Equal(t, "esx", opts.Subsystem)
+	assert.Equal(t, "2_usage", opts.Name)
+	assert.Equal(t, "2_usage", opts.Help)
+}
+
+func TestNormalize(t *testing.T) {
+	c := &Client{config: &config.Config{}}
+
+	assert.InDelta(t, 0.2345, c.normalize(2345, unitPercent), 1e-9)
+	assert.Equal(t, float64(2*1024*1024), c.normalize(2, unitMegaBytes))
+	assert.Equal(t, float64(2400*1000*1000), c.normalize(2400, unitMegaHertz))
+	assert.Equal(t, 0.015, c.normalize(15, unitMillisecond))
+	assert.Equal(t, float64(42), c.normalize(42, unitNumber))
+	assert.Equal(t, float64(42), c.normalize(42, "unknown"))
+
+	c.config.LegacyMetricNames = true
+	assert.Equal(t, float64(2), c.normalize(2, unitMegaBytes))
+}
+
+func TestPerfCounterValue(t *testing.T) {
+	c := &Client{config: &config.Config{}}
+
+	usage := newPerfCounterInfo("cpu", "usage", "", types.PerfSummaryTypeAverage, types.PerfStatsTypeRate, "percent")
+	assert.InDelta(t, 0.5, c.perfCounterValue(*usage, 5000, 20), 1e-9)
+
+	ready := newPerfCounterInfo("cpu", "ready", "", types.PerfSummaryTypeSummation, types.PerfStatsTypeDelta, "millisecond")
+	assert.InDelta(t, 0.1, c.perfCounterValue(*ready, 2000, 20), 1e-9)
+
+	packets := newPerfCounterInfo("net", "packetsRx", "", types.PerfSummaryTypeSummation, types.PerfStatsTypeDelta, "number")
+	assert.Equal(t, float64(50), c.perfCounterValue(*packets, 1000, 20))
+
+	c.config.LegacyMetricNames = true
+	assert.Equal(t, float64(1000), c.perfCounterValue(*packets, 1000, 20))
+}
@@ -0,0 +1,337 @@
+/*
+Copyright (c) 2018 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/iancoleman/strcase"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/vmware/govmomi/vim25/methods"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+//historicalInterval is the 5 minute rollup every entity with perf stats has
+const historicalInterval = 300
+
+var (
+	//ErrPerfNotSupported - The entity does not provide any perf stats
+	ErrPerfNotSupported = errors.New("The entity does not provide any perf stats")
+)
+
+//registerPerfMetrics registers a metric for every perf counter of vCenter. When
+//more than one sample is queried per scrape the min, max, avg and last of the
+//samples are registered as well.
+func (c *Client) registerPerfMetrics(s *session, subsystem string, samples int, metrics map[int]*prometheus.GaugeVec, counters map[int]types.PerfCounterInfo) error {
+	log.Debugln("registerPerfMetrics ENTER")
+
+	filter, err := newPerfFilter(c.config.PerfInclude, c.config.PerfExclude, c.config.PerfMaxLevel, c.config.PerfMaxDeviceLevel)
+	if err != nil {
+		log.Debugln("registerPerfMetrics LEAVE")
+		return err
+	}
+	c.perfFilter = filter
+	c.perfAggregates = make(map[int]*perfAggregate)
+
+	var performanceManager mo.PerformanceManager
+	err = s.client.RetrieveOne(s.ctx, *s.client.ServiceContent.PerfManager, nil, &performanceManager)
+	if err != nil {
+		log.Errorln("RetrieveOne failed:", err)
+		log.Debugln("registerPerfMetrics LEAVE")
+
+		return err
+	}
+
+	// As outline in https://code.vmware.com/doc/preview?id=6784#/doc/vim.PerformanceManager.CounterInfo.html
+	registered := make(map[string]bool)
+	for _, perfCounterInfo := range performanceManager.PerfCounter {
+		if !c.perfFilter.Match(&perfCounterInfo) {
+			continue
+		}
+
+		nameInfo := perfCounterInfo.NameInfo.GetElementDescription()
+		keyTmp := strings.Join(strings.Split(nameInfo.Key, "."), "_")
+		metricName := fmt.Sprintf("%d_%s", perfCounterInfo.Key, strcase.ToSnake(keyTmp))
+		log.Debugln("Key:", metricName)
+
+		opts := c.gaugeOpts(subsystem, metricName, perfCounterName(&perfCounterInfo), perfCounterHelp(&perfCounterInfo))
+		if registered[opts.Name] {
+			log.Warnln("Skipping duplicate perf counter", perfCounterInfo.Key, "named", opts.Name)
+			continue
+		}
+		registered[opts.Name] = true
+
+		labels := []string{"datacenter"}
+		if c.perfFilter.PerDevice(&perfCounterInfo) {
+			labels = append(labels, "instance")
+		}
+
+		metrics[int(perfCounterInfo.Key)] = c.registerPerfGauge(opts, labels)
+		counters[int(perfCounterInfo.Key)] = perfCounterInfo
+
+		if perfSampleCount(samples) > 1 {
+			c.perfAggregates[int(perfCounterInfo.Key)] = &perfAggregate{
+				min:  c.registerPerfGauge(c.perfAggregateOpts(subsystem, metricName, &perfCounterInfo, "min", "minimum"), labels),
+				max:  c.registerPerfGauge(c.perfAggregateOpts(subsystem, metricName, &perfCounterInfo, "max", "maximum"), labels),
+				avg:  c.registerPerfGauge(c.perfAggregateOpts(subsystem, metricName, &perfCounterInfo, "avg", "average"), labels),
+				last: c.registerPerfGauge(c.perfAggregateOpts(subsystem, metricName, &perfCounterInfo, "last", "latest"), labels),
+			}
+		}
+	}
+	log.Infoln("Registered", len(counters), "of", len(performanceManager.PerfCounter), "perf counters")
+
+	c.registerSampleAgeMetric()
+
+	log.Debugln("registerPerfMetrics Succeeded")
+	log.Debugln("registerPerfMetrics LEAVE")
+
+	return nil
+}
+
+//perfAggregateOpts names the aggregate of a perf counter after the counter
+func (c *Client) perfAggregateOpts(subsystem string, metricName string, info *types.PerfCounterInfo, suffix string, description string) prometheus.GaugeOpts {
+	return c.gaugeOpts(subsystem, metricName+"_"+suffix, perfCounterName(info)+"_"+suffix,
+		perfCounterHelp(info)+", "+description+" of the samples of the scrape")
+}
+
+//registerPerfGauge creates and registers the metric of a perf counter. With
+//sample timestamps it is served with the sample time of each scrape instead.
+func (c *Client) registerPerfGauge(opts prometheus.GaugeOpts, labels []string) *prometheus.GaugeVec {
+	myMetric := prometheus.NewGaugeVec(
+		opts,
+		labels,
+	)
+	if c.config.SampleTimestamps {
+		c.timestamped = append(c.timestamped, myMetric)
+	} else {
+		c.registry.MustRegister(myMetric)
+	}
+	return myMetric
+}
+
+//perfInterval picks the interval to query for the entity. A configured
+//interval of 0 uses the real-time interval when the entity supports it and
+//the 5 minute historical rollup otherwise.
+func (c *Client) perfInterval(s *session, entity types.ManagedObjectReference, configured int) (int32, bool, error) {
+	summary, err := c.perfProviderSummary(s, entity)
+	if err != nil {
+		return 0, false, err
+	}
+
+	if configured > 0 {
+		interval := int32(configured)
+		return interval, summary.CurrentSupported && interval == summary.RefreshRate, nil
+	}
+
+	if summary.CurrentSupported && summary.RefreshRate > 0 {
+		return summary.RefreshRate, true, nil
+	}
+	if summary.SummarySupported {
+		return historicalInterval, false, nil
+	}
+
+	return 0, false, ErrPerfNotSupported
+}
+
+//perfProviderSummary returns the supported intervals of the entity. They only
+//depend on the type of the entity, so they are looked up once per type.
+func (c *Client) perfProviderSummary(s *session, entity types.ManagedObjectReference) (*types.PerfProviderSummary, error) {
+	c.perfSummariesMutex.Lock()
+	defer c.perfSummariesMutex.Unlock()
+
+	if summary, ok := c.perfSummaries[entity.Type]; ok {
+		return summary, nil
+	}
+
+	req := types.QueryPerfProviderSummary{
+		This:   *s.client.ServiceContent.PerfManager,
+		Entity: entity,
+	}
+	res, err := methods.QueryPerfProviderSummary(s.ctx, s.client, &req)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Infoln("Perf stats of", entity.Type, "current:", res.Returnval.CurrentSupported,
+		"summary:", res.Returnval.SummarySupported, "refresh rate:", res.Returnval.RefreshRate)
+
+	if c.perfSummaries == nil {
+		c.perfSummaries = make(map[string]*types.PerfProviderSummary)
+	}
+	c.perfSummaries[entity.Type] = &res.Returnval
+
+	return &res.Returnval, nil
+}
+
+//availablePerfMetrics returns the counters vCenter has for the entity in the
+//interval. They are cached by entity type and variant, e.g. the ESXi build, as
+//entities of the same kind provide the same counters.
+func (c *Client) availablePerfMetrics(s *session, entity types.ManagedObjectReference, variant string, interval int32) ([]types.PerfMetricId, error) {
+	key := fmt.Sprintf("%s/%s/%d", entity.Type, variant, interval)
+
+	c.perfAvailableMutex.Lock()
+	defer c.perfAvailableMutex.Unlock()
+
+	if available, ok := c.perfAvailable[key]; ok {
+		return available, nil
+	}
+
+	req := types.QueryAvailablePerfMetric{
+		This:       *s.client.ServiceContent.PerfManager,
+		Entity:     entity,
+		IntervalId: interval,
+	}
+	res, err := methods.QueryAvailablePerfMetric(s.ctx, s.client, &req)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Infoln(len(res.Returnval), "perf metrics available for", key)
+
+	if c.perfAvailable == nil {
+		c.perfAvailable = make(map[string][]types.PerfMetricId)
+	}
+	c.perfAvailable[key] = res.Returnval
+
+	return res.Returnval, nil
+}
+
+//setPerfMetrics queries the latest perf samples of the entity and sets the
+//metrics. Samples vCenter has no data for are skipped.
+func (c *Client) setPerfMetrics(s *session, datacenterStr string, entity types.ManagedObjectReference, variant string, configured int, samples int,
+	metrics map[int]*prometheus.GaugeVec, counters map[int]types.PerfCounterInfo) error {
+	log.Debugln("setPerfMetrics ENTER")
+
+	// Fall back to the scrape time until we have a sample of this entity
+	c.setSampleTime(s, datacenterStr, time.Time{})
+
+	interval, realtime, err := c.perfInterval(s, entity, configured)
+	if err != nil {
+		log.Debugln("setPerfMetrics LEAVE")
+		return err
+	}
+
+	available, err := c.availablePerfMetrics(s, entity, variant, interval)
+	if err != nil {
+		log.Debugln("setPerfMetrics LEAVE")
+		return err
+	}
+
+	// Only ask for counters that are both collected and available, an empty
+	// list would return everything
+	metricIds := c.perfFilter.MetricIds(counters, available)
+	if len(metricIds) == 0 {
+		log.Debugln("No perf counters to query for", entity)
+		log.Debugln("setPerfMetrics LEAVE")
+		return nil
+	}
+
+	querySpec := types.PerfQuerySpec{
+		Entity:     entity,
+		IntervalId: interval,
+		MetricId:   metricIds,
+	}
+	count := perfSampleCount(samples)
+	if realtime {
+		querySpec.MaxSample = count
+	} else {
+		// maxSample is ignored for historical intervals so only ask for the
+		// last few rollups, based on the clock of vCenter
+		now, err := methods.GetCurrentTime(s.ctx, s.client)
+		if err != nil {
+			log.Debugln("setPerfMetrics LEAVE")
+			return err
+		}
+		startTime := now.Add(-time.Duration(count+2) * time.Duration(interval) * time.Second)
+		querySpec.StartTime = &startTime
+	}
+	log.Debugln("Interval:", interval, "Realtime:", realtime, "Samples:", count)
+
+	query := types.QueryPerf{
+		This:      *s.client.ServiceContent.PerfManager,
+		QuerySpec: []types.PerfQuerySpec{querySpec},
+	}
+
+	response, err := methods.QueryPerf(s.ctx, s.client, &query)
+	if err != nil {
+		log.Debugln("setPerfMetrics LEAVE")
+		return err
+	}
+
+	for _, base := range response.Returnval {
+		metric := base.(*types.PerfEntityMetric)
+		if len(metric.SampleInfo) > 0 {
+			sampleTime := metric.SampleInfo[len(metric.SampleInfo)-1].Timestamp
+			log.Debugln("Sample:", sampleTime)
+			c.setSampleTime(s, datacenterStr, sampleTime)
+		}
+
+		for _, baseSeries := range metric.Value {
+			series := baseSeries.(*types.PerfMetricIntSeries)
+			if len(series.Value) == 0 {
+				continue
+			}
+
+			myMetric := metrics[int(series.Id.CounterId)]
+			if myMetric == nil {
+				log.Errorln("Unable to find metric for", series.Id.CounterId)
+				continue
+			}
+
+			// Samples are in chronological order, the last one is the latest
+			info := counters[int(series.Id.CounterId)]
+			var labels []string
+			if c.perfFilter.PerDevice(&info) {
+				labels = []string{datacenterStr, series.Id.Instance}
+			} else if series.Id.Instance == "" {
+				labels = []string{datacenterStr}
+			} else {
+				continue
+			}
+
+			var values []float64
+			for _, sample := range perfSamples(series.Value, count) {
+				values = append(values, c.perfCounterValue(info, sample, interval))
+			}
+			if len(values) == 0 {
+				log.Debugln("No data for", series.Id.CounterId, series.Id.Instance)
+				continue
+			}
+
+			min, max, avg, last := aggregatePerfValues(values)
+			myMetric.WithLabelValues(labels...).Set(last)
+			if aggregate := c.perfAggregates[int(series.Id.CounterId)]; aggregate != nil {
+				aggregate.min.WithLabelValues(labels...).Set(min)
+				aggregate.max.WithLabelValues(labels...).Set(max)
+				aggregate.avg.WithLabelValues(labels...).Set(avg)
+				aggregate.last.WithLabelValues(labels...).Set(last)
+			}
+		}
+	}
+
+	log.Debugln("setPerfMetrics Succeeded")
+	log.Debugln("setPerfMetrics LEAVE")
+
+	return nil
+}
@@ -0,0 +1,156 @@
+/*
+Copyright (c) 2018 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+//perfFilter selects the perf counters to register and query
+type perfFilter struct {
+	include        []*regexp.Regexp
+	exclude        []*regexp.Regexp
+	maxLevel       int32
+	maxDeviceLevel int32
+}
+
+//newPerfFilter builds the filter from comma separated patterns matched
+//against group.name.rollup of the counter, e.g. cpu.usage.average. Patterns
+//are globs unless enclosed in slashes, in which case they are regular
+//expressions.
+func newPerfFilter(include string, exclude string, maxLevel int, maxDeviceLevel int) (*perfFilter, error) {
+	var err error
+	filter := &perfFilter{
+		maxLevel:       int32(maxLevel),
+		maxDeviceLevel: int32(maxDeviceLevel),
+	}
+
+	filter.include, err = compilePerfPatterns(include)
+	if err != nil {
+		return nil, err
+	}
+	filter.exclude, err = compilePerfPatterns(exclude)
+	if err != nil {
+		return nil, err
+	}
+
+	return filter, nil
+}
+
+func compilePerfPatterns(spec string) ([]*regexp.Regexp, error) {
+	var patterns []*regexp.Regexp
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		expr := globToRegexp(entry)
+		if len(entry) > 1 && strings.HasPrefix(entry, "/") && strings.HasSuffix(entry, "/") {
+			expr = entry[1 : len(entry)-1]
+		}
+
+		pattern, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid perf counter pattern %q: %v", entry, err)
+		}
+		patterns = append(patterns, pattern)
+	}
+
+	return patterns, nil
+}
+
+//perfCounterPath is the group.name.rollup name the filter patterns match
+func perfCounterPath(info *types.PerfCounterInfo) string {
+	return info.GroupInfo.GetElementDescription().Key + "." +
+		info.NameInfo.GetElementDescription().Key + "." +
+		string(info.RollupType)
+}
+
+//Match tells whether the counter is collected
+func (f *perfFilter) Match(info *types.PerfCounterInfo) bool {
+	if f.maxLevel > 0 && info.Level > f.maxLevel {
+		return false
+	}
+
+	path := perfCounterPath(info)
+
+	if len(f.include) > 0 {
+		included := false
+		for _, pattern := range f.include {
+			if pattern.MatchString(path) {
+				included = true
+				break
+			}
+		}
+		if !included {
+			return false
+		}
+	}
+
+	for _, pattern := range f.exclude {
+		if pattern.MatchString(path) {
+			return false
+		}
+	}
+
+	return true
+}
+
+//PerDevice tells whether the instances of the counter, e.g. every vmnic or
+//CPU core, are collected in addition to the aggregate
+func (f *perfFilter) PerDevice(info *types.PerfCounterInfo) bool {
+	return f.maxDeviceLevel > 0 && info.PerDeviceLevel <= f.maxDeviceLevel
+}
+
+//MetricIds lists the registered counters that are available for the entity.
+//Instances are only requested for counters collected per device, other
+//counters only when the entity has an aggregate for them.
+func (f *perfFilter) MetricIds(counters map[int]types.PerfCounterInfo, available []types.PerfMetricId) []types.PerfMetricId {
+	aggregates := make(map[int]bool)
+	instances := make(map[int]bool)
+	for _, metricId := range available {
+		if metricId.Instance == "" {
+			aggregates[int(metricId.CounterId)] = true
+		} else {
+			instances[int(metricId.CounterId)] = true
+		}
+	}
+
+	var keys []int
+	for key := range counters {
+		keys = append(keys, key)
+	}
+	sort.Ints(keys)
+
+	var metricIds []types.PerfMetricId
+	for _, key := range keys {
+		info := counters[key]
+		if f.PerDevice(&info) && (aggregates[key] || instances[key]) {
+			metricIds = append(metricIds, types.PerfMetricId{CounterId: int32(key), Instance: "*"})
+		} else if aggregates[key] {
+			metricIds = append(metricIds, types.PerfMetricId{CounterId: int32(key), Instance: ""})
+		}
+	}
+
+	return metricIds
+}
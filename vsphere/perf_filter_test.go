@@ -0,0 +1,99 @@
+/*
+Copyright (c) 2018 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package vsphere
+
+import (
+	"testing"
+
+	assert "github.com/stretchr/testify/assert"
+
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+func TestPerfFilterMatch(t *testing.T) {
+	usage := newPerfCounterInfo("cpu", "usage", "", types.PerfSummaryTypeAverage, types.PerfStatsTypeRate, "percent")
+	usage.Level = 1
+	usage.PerDeviceLevel = 3
+	ready := newPerfCounterInfo("cpu", "ready", "", types.PerfSummaryTypeSummation, types.PerfStatsTypeDelta, "millisecond")
+	ready.Level = 1
+	ready.PerDeviceLevel = 3
+	packets := newPerfCounterInfo("net", "packetsRx", "", types.PerfSummaryTypeSummation, types.PerfStatsTypeDelta, "number")
+	packets.Level = 2
+	packets.PerDeviceLevel = 3
+	latency := newPerfCounterInfo("disk", "maxTotalLatency", "", types.PerfSummaryTypeLatest, types.PerfStatsTypeAbsolute, "millisecond")
+	latency.Level = 1
+	latency.PerDeviceLevel = 3
+
+	filter, err := newPerfFilter("", "", 4, 0)
+	assert.NoError(t, err)
+	assert.True(t, filter.Match(usage))
+	assert.True(t, filter.Match(packets))
+
+	filter, err = newPerfFilter("cpu.*, /^disk\\.max/", "cpu.ready.*", 4, 0)
+	assert.NoError(t, err)
+	assert.True(t, filter.Match(usage))
+	assert.False(t, filter.Match(ready))
+	assert.False(t, filter.Match(packets))
+	assert.True(t, filter.Match(latency))
+
+	filter, err = newPerfFilter("", "", 1, 0)
+	assert.NoError(t, err)
+	assert.True(t, filter.Match(usage))
+	assert.False(t, filter.Match(packets))
+
+	_, err = newPerfFilter("/[/", "", 4, 0)
+	assert.Error(t, err)
+}
+
+func TestPerfFilterMetricIds(t *testing.T) {
+	usage := newPerfCounterInfo("cpu", "usage", "", types.PerfSummaryTypeAverage, types.PerfStatsTypeRate, "percent")
+	usage.PerDeviceLevel = 3
+	packets := newPerfCounterInfo("net", "packetsRx", "", types.PerfSummaryTypeSummation, types.PerfStatsTypeDelta, "number")
+	packets.PerDeviceLevel = 4
+
+	latency := newPerfCounterInfo("disk", "maxTotalLatency", "", types.PerfSummaryTypeLatest, types.PerfStatsTypeAbsolute, "millisecond")
+	latency.PerDeviceLevel = 3
+
+	counters := map[int]types.PerfCounterInfo{
+		6:   *usage,
+		150: *packets,
+		200: *latency,
+	}
+	available := []types.PerfMetricId{
+		{CounterId: 6, Instance: ""},
+		{CounterId: 6, Instance: "0"},
+		{CounterId: 6, Instance: "1"},
+		{CounterId: 150, Instance: ""},
+		{CounterId: 150, Instance: "vmnic0"},
+		{CounterId: 300, Instance: ""},
+	}
+
+	filter, err := newPerfFilter("", "", 4, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, []types.PerfMetricId{
+		{CounterId: 6, Instance: ""},
+		{CounterId: 150, Instance: ""},
+	}, filter.MetricIds(counters, available))
+
+	filter, err = newPerfFilter("", "", 4, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, []types.PerfMetricId{
+		{CounterId: 6, Instance: "*"},
+		{CounterId: 150, Instance: ""},
+	}, filter.MetricIds(counters, available))
+
+	assert.Empty(t, filter.MetricIds(counters, nil))
+}
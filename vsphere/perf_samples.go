@@ -0,0 +1,77 @@
+/*
+Copyright (c) 2018 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package vsphere
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+//perfNoData is the value vCenter returns for a sample it has no data for
+const perfNoData = -1
+
+//perfAggregate holds the metrics aggregated over the samples of a scrape
+type perfAggregate struct {
+	min  *prometheus.GaugeVec
+	max  *prometheus.GaugeVec
+	avg  *prometheus.GaugeVec
+	last *prometheus.GaugeVec
+}
+
+//perfSampleCount returns the number of samples to query, at least one
+func perfSampleCount(configured int) int32 {
+	if configured < 1 {
+		return 1
+	}
+	return int32(configured)
+}
+
+//perfSamples returns the last count samples of a series that have data
+func perfSamples(values []int64, count int32) []int64 {
+	if len(values) > int(count) {
+		values = values[len(values)-int(count):]
+	}
+
+	samples := make([]int64, 0, len(values))
+	for _, value := range values {
+		if value == perfNoData {
+			continue
+		}
+		samples = append(samples, value)
+	}
+	return samples
+}
+
+//aggregatePerfValues returns the minimum, maximum, average and last of values
+func aggregatePerfValues(values []float64) (min, max, avg, last float64) {
+	if len(values) == 0 {
+		return
+	}
+
+	min = values[0]
+	max = values[0]
+	sum := 0.0
+	for _, value := range values {
+		if value < min {
+			min = value
+		}
+		if value > max {
+			max = value
+		}
+		sum += value
+	}
+
+	return min, max, sum / float64(len(values)), values[len(values)-1]
+}
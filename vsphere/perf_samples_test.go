@@ -0,0 +1,50 @@
+/*
+Copyright (c) 2018 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package vsphere
+
+import (
+	"testing"
+
+	assert "github.com/stretchr/testify/assert"
+)
+
+func TestPerfSampleCount(t *testing.T) {
+	assert.Equal(t, int32(1), perfSampleCount(0))
+	assert.Equal(t, int32(1), perfSampleCount(-3))
+	assert.Equal(t, int32(3), perfSampleCount(3))
+}
+
+func TestPerfSamples(t *testing.T) {
+	assert.Equal(t, []int64{5, 7}, perfSamples([]int64{1, 3, 5, 7}, 2))
+	assert.Equal(t, []int64{1, 7}, perfSamples([]int64{1, -1, 7}, 3))
+	assert.Equal(t, []int64{3}, perfSamples([]int64{1, 3, -1}, 2))
+	assert.Empty(t, perfSamples([]int64{-1}, 1))
+	assert.Empty(t, perfSamples(nil, 3))
+}
+
+func TestAggregatePerfValues(t *testing.T) {
+	min, max, avg, last := aggregatePerfValues([]float64{4, 10, 1})
+	assert.Equal(t, float64(1), min)
+	assert.Equal(t, float64(10), max)
+	assert.Equal(t, float64(5), avg)
+	assert.Equal(t, float64(1), last)
+
+	min, max, avg, last = aggregatePerfValues([]float64{2})
+	assert.Equal(t, []float64{2, 2, 2, 2}, []float64{min, max, avg, last})
+
+	min, max, avg, last = aggregatePerfValues(nil)
+	assert.Equal(t, []float64{0, 0, 0, 0}, []float64{min, max, avg, last})
+}
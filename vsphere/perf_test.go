@@ -0,0 +1,143 @@
+/*
+Copyright (c) 2018 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package vsphere
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	assert "github.com/stretchr/testify/assert"
+
+	"github.com/vmware/govmomi/vim25/methods"
+	"github.com/vmware/govmomi/vim25/soap"
+	"github.com/vmware/govmomi/vim25/types"
+
+	config "github.com/dvonthenen/vsphere-metrics-prometheus/config"
+)
+
+func TestPerfInterval(t *testing.T) {
+	c := &Client{
+		config: &config.Config{},
+		perfSummaries: map[string]*types.PerfProviderSummary{
+			"HostSystem": {CurrentSupported: true, SummarySupported: true, RefreshRate: 20},
+			"Datastore":  {CurrentSupported: false, SummarySupported: true, RefreshRate: -1},
+			"Folder":     {},
+		},
+	}
+
+	host := types.ManagedObjectReference{Type: "HostSystem", Value: "host-1"}
+	datastore := types.ManagedObjectReference{Type: "Datastore", Value: "datastore-1"}
+	folder := types.ManagedObjectReference{Type: "Folder", Value: "group-d1"}
+
+	interval, realtime, err := c.perfInterval(nil, host, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(20), interval)
+	assert.True(t, realtime)
+
+	interval, realtime, err = c.perfInterval(nil, host, 1800)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(1800), interval)
+	assert.False(t, realtime)
+
+	interval, realtime, err = c.perfInterval(nil, datastore, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(historicalInterval), interval)
+	assert.False(t, realtime)
+
+	_, _, err = c.perfInterval(nil, folder, 0)
+	assert.Equal(t, ErrPerfNotSupported, err)
+}
+
+func TestSetPerfMetricsResetsPreviousEntity(t *testing.T) {
+	usage := newPerfCounterInfo("cpu", "usage", "", types.PerfSummaryTypeAverage, types.PerfStatsTypeRate, "percent")
+	usage.Key = 6
+	usage.PerDeviceLevel = 3
+	packets := newPerfCounterInfo("net", "packetsRx", "", types.PerfSummaryTypeSummation, types.PerfStatsTypeDelta, "number")
+	packets.Key = 150
+	packets.PerDeviceLevel = 4
+	counters := map[int]types.PerfCounterInfo{6: *usage, 150: *packets}
+
+	filter, err := newPerfFilter("", "", 4, 3)
+	assert.NoError(t, err)
+
+	newVec := func(labels ...string) *prometheus.GaugeVec {
+		return prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "test"}, labels)
+	}
+	c := &Client{
+		config:     &config.Config{},
+		perfFilter: filter,
+		metricsMapEsx: map[int]*prometheus.GaugeVec{
+			6:   newVec("datacenter", "instance"),
+			150: newVec("datacenter"),
+		},
+		perfAggregates: map[int]*perfAggregate{
+			6: {min: newVec("datacenter", "instance"), max: newVec("datacenter", "instance"), avg: newVec("datacenter", "instance"), last: newVec("datacenter", "instance")},
+		},
+		perfSummaries: map[string]*types.PerfProviderSummary{
+			"HostSystem": {CurrentSupported: true, RefreshRate: 20},
+		},
+		perfAvailable: map[string][]types.PerfMetricId{
+			"HostSystem/6.5/20": {{CounterId: 6, Instance: "0"}, {CounterId: 6, Instance: "1"}, {CounterId: 150}},
+		},
+	}
+
+	series := func(counter int32, instance string, values ...int64) types.BasePerfMetricSeries {
+		return &types.PerfMetricIntSeries{
+			PerfMetricSeries: types.PerfMetricSeries{Id: types.PerfMetricId{CounterId: counter, Instance: instance}},
+			Value:            values,
+		}
+	}
+
+	// host-1 has two CPUs and packets, host-2 one CPU and no packet samples
+	samples := map[string][]types.BasePerfMetricSeries{
+		"host-1": {series(6, "0", 5000), series(6, "1", 2500), series(150, "", 100)},
+		"host-2": {series(6, "0", 1000), series(150, "", -1)},
+	}
+	s := newFakeSession(&fakeVCenter{
+		call: func(ctx context.Context, req, res soap.HasFault) error {
+			query, ok := req.(*methods.QueryPerfBody)
+			if !ok {
+				return errors.New("unexpected call")
+			}
+			entity := query.Req.QuerySpec[0].Entity
+			res.(*methods.QueryPerfBody).Res = &types.QueryPerfResponse{
+				Returnval: []types.BasePerfEntityMetricBase{
+					&types.PerfEntityMetric{
+						PerfEntityMetricBase: types.PerfEntityMetricBase{Entity: entity},
+						SampleInfo:           []types.PerfSampleInfo{{Timestamp: time.Now(), Interval: 20}},
+						Value:                samples[entity.Value],
+					},
+				},
+			}
+			return nil
+		},
+	})
+
+	for _, host := range []string{"host-1", "host-2"} {
+		c.resetMetrics()
+		entity := types.ManagedObjectReference{Type: "HostSystem", Value: host}
+		assert.NoError(t, c.setPerfMetrics(s, "dc1", entity, "6.5", 0, 1, c.metricsMapEsx, counters))
+	}
+
+	assert.Equal(t, 1, seriesCount(c.metricsMapEsx[6]))
+	assert.Equal(t, 0, seriesCount(c.metricsMapEsx[150]))
+	assert.Equal(t, 1, seriesCount(c.perfAggregates[6].max))
+	assert.Equal(t, 1, seriesCount(c.perfAggregates[6].last))
+	assert.Equal(t, vecValue(t, c.metricsMapEsx[6], "dc1", "0"), vecValue(t, c.perfAggregates[6].last, "dc1", "0"))
+}
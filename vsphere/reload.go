@@ -0,0 +1,237 @@
+/*
+Copyright (c) 2018 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	config "github.com/dvonthenen/vsphere-metrics-prometheus/config"
+)
+
+var (
+	//ErrNewClientRequired - The new config changes settings a running client cannot apply
+	ErrNewClientRequired = errors.New("The config changes settings that need a new client")
+)
+
+//newClientSettings returns the settings that differ between the configs and
+//shape the registered metrics or the background scans, so they cannot be
+//changed on a running client
+func newClientSettings(old *config.Config, cfg *config.Config) []string {
+	var changed []string
+	compare := func(name string, before interface{}, after interface{}) {
+		if before != after {
+			changed = append(changed, name)
+		}
+	}
+
+	compare("vsphere.hostname", old.VSphereHostname, cfg.VSphereHostname)
+	compare("vsphere.port", old.VSpherePort, cfg.VSpherePort)
+	compare("vsphere.type", old.VSphereType, cfg.VSphereType)
+	compare("vsphere.custom_attributes", old.CustomAttributes, cfg.CustomAttributes)
+	compare("vsphere.tag_refresh_interval", old.TagRefreshInterval, cfg.TagRefreshInterval)
+	compare("vsphere.tag_categories", old.TagCategories, cfg.TagCategories)
+	compare("vsphere.legacy_metric_names", old.LegacyMetricNames, cfg.LegacyMetricNames)
+	// The compliance metrics are only registered with a baseline
+	compare("esx.baseline_file", old.EsxBaselineFile == "", cfg.EsxBaselineFile == "")
+	compare("esx.perf_samples", old.EsxPerfSamples, cfg.EsxPerfSamples)
+	compare("datastore.scan_interval", old.DatastoreScanInterval, cfg.DatastoreScanInterval)
+	compare("datastore.perf", old.DatastorePerf, cfg.DatastorePerf)
+	compare("datastore.perf_samples", old.DatastorePerfSamples, cfg.DatastorePerfSamples)
+	compare("perf.include", old.PerfInclude, cfg.PerfInclude)
+	compare("perf.exclude", old.PerfExclude, cfg.PerfExclude)
+	compare("perf.max_level", old.PerfMaxLevel, cfg.PerfMaxLevel)
+	compare("perf.max_device_level", old.PerfMaxDeviceLevel, cfg.PerfMaxDeviceLevel)
+	compare("perf.sample_timestamps", old.SampleTimestamps, cfg.SampleTimestamps)
+
+	return changed
+}
+
+//NewClientSettings returns the settings of cfg a running client cannot
+//apply. A config that changes them needs a new client.
+func (c *Client) NewClientSettings(cfg *config.Config) []string {
+	return newClientSettings(c.currentConfig(), cfg)
+}
+
+//Reload applies the settings of a new config that can change while running:
+//credentials, perf intervals, entity filters, the scan delay and the host
+//baseline. The caller has to make sure no scrape is in flight. Nothing is
+//applied when the new config is invalid or needs a new client.
+func (c *Client) Reload(cfg *config.Config) error {
+	apply, err := c.PrepareReload(cfg)
+	if err != nil {
+		return err
+	}
+
+	apply()
+	return nil
+}
+
+//PrepareReload checks a new config like Reload and returns the function
+//that applies it, so the configs of several clients are all checked before
+//any is applied. The caller has to make sure no scrape is in flight when
+//calling it.
+func (c *Client) PrepareReload(cfg *config.Config) (func(), error) {
+	log.Debugln("PrepareReload ENTER")
+
+	changed := newClientSettings(c.config, cfg)
+	if len(changed) > 0 {
+		log.Debugln("PrepareReload LEAVE")
+		return nil, fmt.Errorf("%v: %s", ErrNewClientRequired, strings.Join(changed, ", "))
+	}
+
+	filter, err := newEntityFilter(cfg.EntityInclude, cfg.EntityExclude)
+	if err != nil {
+		log.Debugln("PrepareReload LEAVE")
+		return nil, err
+	}
+	err = checkTagRules(filter, cfg)
+	if err != nil {
+		log.Debugln("PrepareReload LEAVE")
+		return nil, err
+	}
+
+	baseline := c.baseline
+	if cfg.VSphereType == string(config.VSphereRoleEsx) {
+		baseline = nil
+		if cfg.EsxBaselineFile != "" {
+			baseline, err = LoadHostBaseline(cfg.EsxBaselineFile)
+			if err != nil {
+				log.Debugln("PrepareReload LEAVE")
+				return nil, err
+			}
+		}
+	}
+
+	reconnect := credentialsChanged(c.config, cfg)
+
+	// Swap in a new config instead of changing the running one, the
+	// background loops and logins may be reading it
+	next := *c.config
+	next.ConfigFile = cfg.ConfigFile
+	next.LogLevel = cfg.LogLevel
+	next.Debug = cfg.Debug
+	next.VSphereInsecure = cfg.VSphereInsecure
+	next.VSphereCAFile = cfg.VSphereCAFile
+	next.VSphereThumbprint = cfg.VSphereThumbprint
+	next.VSphereUser = cfg.VSphereUser
+	next.VSphereUserFile = cfg.VSphereUserFile
+	next.VSpherePass = cfg.VSpherePass
+	next.VSpherePassFile = cfg.VSpherePassFile
+	next.VSphereAuth = cfg.VSphereAuth
+	next.VSphereCertFile = cfg.VSphereCertFile
+	next.VSphereKeyFile = cfg.VSphereKeyFile
+	next.VSphereExtensionKey = cfg.VSphereExtensionKey
+	next.VSphereTokenFile = cfg.VSphereTokenFile
+	next.VSphereCertificate = cfg.VSphereCertificate
+	next.VSphereToken = cfg.VSphereToken
+	next.EntityInclude = cfg.EntityInclude
+	next.EntityExclude = cfg.EntityExclude
+	next.EsxBaselineFile = cfg.EsxBaselineFile
+	next.EsxPerfInterval = cfg.EsxPerfInterval
+	next.DatastoreScanDelay = cfg.DatastoreScanDelay
+	next.DatastorePerfInterval = cfg.DatastorePerfInterval
+	next.ScrapeTimeoutOffset = cfg.ScrapeTimeoutOffset
+
+	log.Debugln("PrepareReload Succeeded")
+	log.Debugln("PrepareReload LEAVE")
+
+	return func() {
+		c.configMutex.Lock()
+		c.config = &next
+		c.entityFilter = filter
+		c.baseline = baseline
+		c.configMutex.Unlock()
+
+		if reconnect {
+			c.logoutAll()
+		}
+	}, nil
+}
+
+//UpdateCredentials applies the secrets of cfg, e.g. read again after their
+//files were rotated, and leaves the other settings alone. The caller has to
+//make sure no scrape is in flight.
+func (c *Client) UpdateCredentials(cfg *config.Config) {
+	log.Debugln("UpdateCredentials ENTER")
+
+	next := *c.config
+	next.VSphereUser = cfg.VSphereUser
+	next.VSpherePass = cfg.VSpherePass
+	next.VSphereToken = cfg.VSphereToken
+	next.VSphereCertificate = cfg.VSphereCertificate
+
+	reconnect := credentialsChanged(c.config, &next)
+
+	c.configMutex.Lock()
+	c.config = &next
+	c.configMutex.Unlock()
+
+	if reconnect {
+		c.logoutAll()
+	}
+
+	log.Debugln("UpdateCredentials LEAVE")
+}
+
+//credentialsChanged tells whether the configs log in differently
+func credentialsChanged(old *config.Config, cfg *config.Config) bool {
+	return old.VSphereUser != cfg.VSphereUser ||
+		old.VSpherePass != cfg.VSpherePass ||
+		old.VSphereInsecure != cfg.VSphereInsecure ||
+		old.VSphereCAFile != cfg.VSphereCAFile ||
+		old.VSphereThumbprint != cfg.VSphereThumbprint ||
+		old.VSphereAuth != cfg.VSphereAuth ||
+		old.VSphereExtensionKey != cfg.VSphereExtensionKey ||
+		old.VSphereToken != cfg.VSphereToken ||
+		!sameCertificate(old.VSphereCertificate, cfg.VSphereCertificate)
+}
+
+//logoutAll drops the sessions, so the next scrape logs in with the new
+//credentials
+func (c *Client) logoutAll() {
+	log.Infoln("vCenter credentials changed, logging in again on the next scrape")
+	c.Logout()
+	if c.tagClient != nil {
+		c.tagClient.Logout()
+	}
+}
+
+//currentConfig returns the running config. A reload swaps it for a new one
+//instead of changing it, so it can be read while the reload is applied.
+func (c *Client) currentConfig() *config.Config {
+	c.configMutex.RLock()
+	defer c.configMutex.RUnlock()
+
+	return c.config
+}
+
+//sameCertificate tells whether both client certificates are the same
+func sameCertificate(a *tls.Certificate, b *tls.Certificate) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	if len(a.Certificate) == 0 || len(b.Certificate) == 0 {
+		return len(a.Certificate) == len(b.Certificate)
+	}
+	return bytes.Equal(a.Certificate[0], b.Certificate[0])
+}
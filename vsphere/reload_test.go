@@ -0,0 +1,191 @@
+/*
+Copyright (c) 2018 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package vsphere
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"sync"
+	"testing"
+
+	assert "github.com/stretchr/testify/assert"
+
+	config "github.com/dvonthenen/vsphere-metrics-prometheus/config"
+)
+
+const loginResponse = `<?xml version="1.0" encoding="UTF-8"?>
+<soapenv:Envelope xmlns:soapenv="http://schemas.xmlsoap.org/soap/envelope/">
+<soapenv:Body><LoginResponse xmlns="urn:vim25"><returnval>
+<key>52a4</key><userName>VSPHERE.LOCAL\monitoring</userName><fullName>monitoring</fullName>
+<loginTime>2018-06-01T12:00:00Z</loginTime><lastActiveTime>2018-06-01T12:00:00Z</lastActiveTime>
+<locale>en</locale><messageLocale>en</messageLocale>
+</returnval></LoginResponse></soapenv:Body></soapenv:Envelope>`
+
+var (
+	serviceContentRequest = regexp.MustCompile(`<RetrieveServiceContent[ >]`)
+	loginRequest          = regexp.MustCompile(`<Login[ >]`)
+	loginPassword         = regexp.MustCompile(`<password>([^<]*)</password>`)
+)
+
+func TestReloadRotatedPassword(t *testing.T) {
+	// vCenter records the password of every SOAP and vAPI login
+	var mutex sync.Mutex
+	var soapLogins, tagLogins []string
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		switch r.URL.Path {
+		case "/sdk":
+			data, err := ioutil.ReadAll(r.Body)
+			assert.NoError(t, err)
+			body := string(data)
+
+			switch {
+			case serviceContentRequest.MatchString(body):
+				fmt.Fprint(w, serviceContentResponse)
+			case loginRequest.MatchString(body):
+				soapLogins = append(soapLogins, loginPassword.FindStringSubmatch(body)[1])
+				fmt.Fprint(w, loginResponse)
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		case "/rest" + tagSessionPath:
+			if r.Method == http.MethodPost {
+				_, pass, _ := r.BasicAuth()
+				tagLogins = append(tagLogins, pass)
+			}
+			fmt.Fprint(w, `{"value":"session"}`)
+		default:
+			fmt.Fprint(w, `{"value":[]}`)
+		}
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "reload")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "password")
+	assert.NoError(t, ioutil.WriteFile(file, []byte("old\n"), 0600))
+
+	u, _ := url.Parse(server.URL)
+	port, _ := strconv.Atoi(u.Port())
+	args := []string{"-vsphere.type", "datastore", "-vsphere.hostname", u.Hostname(), "-vsphere.port", u.Port(),
+		"-vsphere.insecure", "-vsphere.username", "monitoring", "-vsphere.password-file", file}
+	cfg, err := config.Load(args)
+	assert.NoError(t, err)
+
+	c := NewClient(cfg)
+	c.tagClient = newTagClient(tagURL(u.Hostname(), port), c.tagCredentials, true)
+	c.tags = &tagCache{}
+
+	_, err = c.getSession(context.Background())
+	assert.NoError(t, err)
+	assert.NoError(t, c.refreshTags())
+
+	// The secret is rotated and the watcher reloads the config
+	assert.NoError(t, ioutil.WriteFile(file, []byte("new\n"), 0600))
+	cfg, err = config.Load(args)
+	assert.NoError(t, err)
+	assert.NoError(t, c.Reload(cfg))
+
+	_, err = c.getSession(context.Background())
+	assert.NoError(t, err)
+	assert.NoError(t, c.refreshTags())
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	assert.Equal(t, []string{"old", "new"}, soapLogins)
+	assert.Equal(t, []string{"old", "new"}, tagLogins)
+}
+
+func TestUpdateCredentials(t *testing.T) {
+	running := &config.Config{
+		VSphereType: string(config.VSphereRoleDatastore),
+		VSphereUser: "monitoring",
+		VSpherePass: "old",
+	}
+	c := NewClient(running)
+
+	// Only the secrets are applied
+	rotated := *running
+	rotated.VSpherePass = "new"
+	rotated.EntityInclude = "name:ds-*"
+	rotated.VSphereType = string(config.VSphereRoleEsx)
+	c.UpdateCredentials(&rotated)
+
+	assert.Equal(t, "new", c.currentConfig().VSpherePass)
+	assert.Equal(t, "", c.currentConfig().EntityInclude)
+	assert.Equal(t, string(config.VSphereRoleDatastore), c.currentConfig().VSphereType)
+	assert.Equal(t, 1, c.sessions.generation)
+
+	// Unchanged secrets keep the session
+	c.UpdateCredentials(&rotated)
+	assert.Equal(t, 1, c.sessions.generation)
+}
+
+func TestReloadSwapsConfig(t *testing.T) {
+	running := &config.Config{
+		VSphereType: string(config.VSphereRoleDatastore),
+		VSphereUser: "monitoring",
+		VSpherePass: "old",
+	}
+	c := NewClient(running)
+
+	// A background loop keeps reading the config while it is reloaded
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				_ = c.currentConfig().VSpherePass
+			}
+		}
+	}()
+
+	reloaded := *running
+	reloaded.VSpherePass = "new"
+	reloaded.EntityInclude = "name:ds-*"
+	assert.NoError(t, c.Reload(&reloaded))
+
+	close(stop)
+	<-done
+
+	assert.Equal(t, "new", c.currentConfig().VSpherePass)
+	assert.Equal(t, "name:ds-*", c.currentConfig().EntityInclude)
+	assert.Equal(t, "old", running.VSpherePass)
+
+	// Settings that need a new client leave the running config alone
+	reloaded.VSphereType = string(config.VSphereRoleEsx)
+	reloaded.VSpherePass = "newer"
+	err := c.Reload(&reloaded)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "vsphere.type")
+	assert.Equal(t, []string{"vsphere.type"}, c.NewClientSettings(&reloaded))
+	assert.Equal(t, "new", c.currentConfig().VSpherePass)
+}
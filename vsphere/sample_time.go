@@ -0,0 +1,115 @@
+/*
+Copyright (c) 2018 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package vsphere
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+//timestampCollector exposes the metrics of collectors with the time of the
+//sample instead of the time of the scrape
+type timestampCollector struct {
+	collectors []prometheus.Collector
+	timestamp  time.Time
+}
+
+//Describe implements prometheus.Collector
+func (t *timestampCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, collector := range t.collectors {
+		collector.Describe(ch)
+	}
+}
+
+//Collect implements prometheus.Collector
+func (t *timestampCollector) Collect(ch chan<- prometheus.Metric) {
+	if t.timestamp.IsZero() {
+		for _, collector := range t.collectors {
+			collector.Collect(ch)
+		}
+		return
+	}
+
+	metrics := make(chan prometheus.Metric)
+	go func() {
+		for _, collector := range t.collectors {
+			collector.Collect(metrics)
+		}
+		close(metrics)
+	}()
+
+	for metric := range metrics {
+		ch <- &timestampedMetric{
+			Metric:      metric,
+			timestampMs: t.timestamp.UnixNano() / int64(time.Millisecond),
+		}
+	}
+}
+
+//timestampedMetric adds an explicit timestamp to a metric
+type timestampedMetric struct {
+	prometheus.Metric
+	timestampMs int64
+}
+
+//Write implements prometheus.Metric
+func (m *timestampedMetric) Write(pb *dto.Metric) error {
+	err := m.Metric.Write(pb)
+	if err != nil {
+		return err
+	}
+
+	timestampMs := m.timestampMs
+	pb.TimestampMs = &timestampMs
+
+	return nil
+}
+
+func (c *Client) registerSampleAgeMetric() {
+	if c.metricSampleAge != nil {
+		return
+	}
+
+	c.metricSampleAge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "vsphere",
+			Name:      "sample_age_seconds",
+			Help:      "Age of the perf sample of the entity at the time of the scrape",
+		},
+		[]string{"datacenter"},
+	)
+	c.registry.MustRegister(c.metricSampleAge)
+}
+
+//setSampleTime records the time of the perf sample of the scraped entity
+func (c *Client) setSampleTime(s *session, datacenterStr string, timestamp time.Time) {
+	if c.config.SampleTimestamps {
+		if state := scrapeStateOf(s.ctx); state != nil {
+			state.sampleTime = timestamp
+		}
+	}
+
+	if c.metricSampleAge == nil {
+		return
+	}
+	if timestamp.IsZero() {
+		c.metricSampleAge.Reset()
+		return
+	}
+	c.metricSampleAge.WithLabelValues(datacenterStr).Set(time.Since(timestamp).Seconds())
+}
@@ -0,0 +1,101 @@
+/*
+Copyright (c) 2018 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package vsphere
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	assert "github.com/stretchr/testify/assert"
+
+	config "github.com/dvonthenen/vsphere-metrics-prometheus/config"
+)
+
+func collectTimestamps(collector prometheus.Collector) []*dto.Metric {
+	ch := make(chan prometheus.Metric)
+	go func() {
+		collector.Collect(ch)
+		close(ch)
+	}()
+
+	var metrics []*dto.Metric
+	for metric := range ch {
+		pb := &dto.Metric{}
+		metric.Write(pb)
+		metrics = append(metrics, pb)
+	}
+	return metrics
+}
+
+func TestTimestampCollector(t *testing.T) {
+	vec := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "test"}, []string{"datacenter"})
+	vec.WithLabelValues("dc1").Set(42)
+	other := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "other"}, []string{"datacenter"})
+	other.WithLabelValues("dc1").Set(1)
+
+	// No sample, the scrape time applies
+	collector := &timestampCollector{collectors: []prometheus.Collector{vec, other}}
+	metrics := collectTimestamps(collector)
+	assert.Len(t, metrics, 2)
+	assert.Nil(t, metrics[0].TimestampMs)
+
+	sampleTime := time.Date(2018, 6, 1, 12, 0, 20, 0, time.UTC)
+	collector = &timestampCollector{collectors: []prometheus.Collector{vec, other}, timestamp: sampleTime}
+
+	metrics = collectTimestamps(collector)
+	assert.Len(t, metrics, 2)
+	for _, metric := range metrics {
+		assert.Equal(t, sampleTime.UnixNano()/int64(time.Millisecond), metric.GetTimestampMs())
+	}
+	assert.Equal(t, float64(42), metrics[0].GetGauge().GetValue())
+}
+
+func TestSampleTimePerScrape(t *testing.T) {
+	vec := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "vsphere_test_sample", Help: "Test"}, []string{"datacenter"})
+	c := NewClient(&config.Config{SampleTimestamps: true})
+	c.timestamped = []prometheus.Collector{vec}
+
+	// Each scrape serves the sample time it collected, whatever the others did
+	first := &scrapeState{}
+	second := &scrapeState{}
+	firstTime := time.Date(2018, 6, 1, 12, 0, 20, 0, time.UTC)
+	secondTime := firstTime.Add(time.Minute)
+	c.setSampleTime(&session{ctx: context.WithValue(context.Background(), scrapeStateKey{}, first)}, "dc1", firstTime)
+	c.setSampleTime(&session{ctx: context.WithValue(context.Background(), scrapeStateKey{}, second)}, "dc1", secondTime)
+	vec.WithLabelValues("dc1").Set(1)
+
+	for state, sampleTime := range map[*scrapeState]time.Time{first: firstTime, second: secondTime} {
+		families, err := c.gatherer(state).Gather()
+		assert.NoError(t, err)
+
+		found := false
+		for _, family := range families {
+			if family.GetName() == "vsphere_test_sample" {
+				found = true
+				assert.Equal(t, sampleTime.UnixNano()/int64(time.Millisecond), family.Metric[0].GetTimestampMs())
+			}
+		}
+		assert.True(t, found)
+	}
+
+	// Without a scrape state the sample time has nowhere to go
+	assert.NotPanics(t, func() {
+		c.setSampleTime(&session{ctx: context.Background()}, "dc1", firstTime)
+	})
+}
@@ -0,0 +1,264 @@
+/*
+Copyright (c) 2018 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	log "github.com/sirupsen/logrus"
+)
+
+//scrapeTimeoutHeader is set by Prometheus to the scrape timeout
+const scrapeTimeoutHeader = "X-Prometheus-Scrape-Timeout-Seconds"
+
+var (
+	//ErrScrapeTimeout - The scrape hit its deadline and serves partial results
+	ErrScrapeTimeout = errors.New("The scrape ran out of time, serving partial results")
+)
+
+var scrapeTimeoutOpts = prometheus.GaugeOpts{
+	Namespace: "vsphere",
+	Name:      "scrape_timeout",
+	Help:      "1 if the last scrape hit its deadline and returned partial results",
+}
+
+//roleMetrics returns the metrics of the entity a scrape collects
+func (c *Client) roleMetrics() []map[int]*prometheus.GaugeVec {
+	return []map[int]*prometheus.GaugeVec{
+		c.metricsMapEsx,
+		c.metricsMapEsxHost,
+		c.metricsMapDatastore,
+		c.metricsMapDatastorePerf,
+		c.metricsMapVM,
+		c.metricsMapLicense,
+	}
+}
+
+//scrapeTimeout returns the deadline for the vCenter calls of a scrape, the
+//timeout Prometheus sent minus the offset. 0 means no deadline.
+func scrapeTimeout(r *http.Request, offset time.Duration) time.Duration {
+	header := r.Header.Get(scrapeTimeoutHeader)
+	if header == "" {
+		return 0
+	}
+
+	seconds, err := strconv.ParseFloat(header, 64)
+	if err != nil || seconds <= 0 {
+		log.Warnln("Ignoring invalid", scrapeTimeoutHeader, "header:", header)
+		return 0
+	}
+
+	timeout := time.Duration(seconds * float64(time.Second))
+	if timeout > offset {
+		timeout -= offset
+	}
+	return timeout
+}
+
+//Serve collects the metrics of an entity and serves them, unless collect
+//answered the scrape itself, e.g. with an error. The metrics belong to the
+//client, so one scrape collects and serves at a time and the others wait.
+//A scrape that runs out of time serves what it collected of its entity, or
+//only the timeout gauge when that is nothing.
+func (c *Client) Serve(w http.ResponseWriter, r *http.Request, collect func(http.ResponseWriter, *http.Request) error) error {
+	state := &scrapeState{}
+	ctx, cancel := c.scrapeContext(r.WithContext(context.WithValue(r.Context(), scrapeStateKey{}, state)))
+	defer cancel()
+
+	select {
+	case c.scrapes <- struct{}{}:
+	case <-ctx.Done():
+		if ctx.Err() == context.DeadlineExceeded {
+			log.Warnln(ErrScrapeTimeout)
+			promhttp.HandlerFor(timeoutGatherer(), promhttp.HandlerOpts{}).ServeHTTP(w, r)
+			return fmt.Errorf("%v: waiting for another scrape", ErrScrapeTimeout)
+		}
+		return ctx.Err()
+	}
+	defer func() { <-c.scrapes }()
+
+	c.resetMetrics()
+
+	writer := &scrapeWriter{ResponseWriter: w}
+	start := time.Now()
+	err := collect(writer, r.WithContext(ctx))
+	c.ObserveScrape(time.Since(start), err)
+	if writer.answered {
+		return err
+	}
+
+	gatherer := c.gatherer(state)
+	if ctx.Err() == context.DeadlineExceeded && !c.collectedMetrics() {
+		gatherer = timeoutGatherer()
+	}
+	promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+	return err
+}
+
+//scrapeState holds what a scrape collected besides the metrics. It travels
+//with the context of the scrape.
+type scrapeState struct {
+	//sampleTime is the time of the perf sample of the entity
+	sampleTime time.Time
+}
+
+type scrapeStateKey struct{}
+
+//scrapeStateOf returns the state of the scrape ctx belongs to, if any
+func scrapeStateOf(ctx context.Context) *scrapeState {
+	state, _ := ctx.Value(scrapeStateKey{}).(*scrapeState)
+	return state
+}
+
+//gatherer returns the metrics to serve for a scrape. With sample timestamps
+//the perf metrics carry the sample time of this scrape.
+func (c *Client) gatherer(state *scrapeState) prometheus.Gatherer {
+	if len(c.timestamped) == 0 {
+		return c.registry
+	}
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(&timestampCollector{collectors: c.timestamped, timestamp: state.sampleTime})
+	return prometheus.Gatherers{c.registry, registry}
+}
+
+//timeoutGatherer serves only the timeout gauge, for a scrape that ran out of
+//time before it collected anything of its entity
+func timeoutGatherer() prometheus.Gatherer {
+	gauge := prometheus.NewGauge(scrapeTimeoutOpts)
+	gauge.Set(1)
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(gauge)
+	return registry
+}
+
+//collectedMetrics tells whether the scrape set any metric of its entity
+func (c *Client) collectedMetrics() bool {
+	for _, metrics := range c.roleMetrics() {
+		for _, myMetric := range metrics {
+			if seriesCount(myMetric) > 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+//seriesCount returns the number of series of a collector
+func seriesCount(collector prometheus.Collector) int {
+	ch := make(chan prometheus.Metric)
+	go func() {
+		collector.Collect(ch)
+		close(ch)
+	}()
+
+	count := 0
+	for range ch {
+		count++
+	}
+	return count
+}
+
+//resetMetrics clears the series of the previous scrape. An instance, counter
+//or sample the entity does not have is left out instead of reporting the
+//value of the entity scraped before.
+func (c *Client) resetMetrics() {
+	for _, metrics := range c.roleMetrics() {
+		for _, myMetric := range metrics {
+			myMetric.Reset()
+		}
+	}
+
+	for _, aggregate := range c.perfAggregates {
+		aggregate.min.Reset()
+		aggregate.max.Reset()
+		aggregate.avg.Reset()
+		aggregate.last.Reset()
+	}
+
+	if c.metricSampleAge != nil {
+		c.metricSampleAge.Reset()
+	}
+	if c.metricTagInfo != nil {
+		c.metricTagInfo.Reset()
+	}
+}
+
+//scrapeWriter tells whether collect answered the scrape itself
+type scrapeWriter struct {
+	http.ResponseWriter
+	answered bool
+}
+
+//WriteHeader implements http.ResponseWriter
+func (s *scrapeWriter) WriteHeader(code int) {
+	s.answered = true
+	s.ResponseWriter.WriteHeader(code)
+}
+
+//Write implements http.ResponseWriter
+func (s *scrapeWriter) Write(data []byte) (int, error) {
+	s.answered = true
+	return s.ResponseWriter.Write(data)
+}
+
+//scrapeContext derives the context of a scrape from the request, so the
+//vCenter calls stop when Prometheus gives up or disconnects
+func (c *Client) scrapeContext(r *http.Request) (context.Context, context.CancelFunc) {
+	timeout := scrapeTimeout(r, c.config.ScrapeTimeoutOffset)
+	if timeout <= 0 {
+		return context.WithCancel(r.Context())
+	}
+	return context.WithTimeout(r.Context(), timeout)
+}
+
+//endScrape records whether the scrape timed out and releases its context
+func (c *Client) endScrape(ctx context.Context, cancel context.CancelFunc) {
+	if ctx.Err() == context.DeadlineExceeded {
+		log.Warnln(ErrScrapeTimeout)
+		c.metricScrapeTimeout.Set(1)
+	} else {
+		c.metricScrapeTimeout.Set(0)
+	}
+	cancel()
+}
+
+//scrapeError answers a failed scrape. Once the deadline hit, the metrics
+//collected so far are served instead of the error.
+func scrapeError(ctx context.Context, w http.ResponseWriter, err error, message string, code int) error {
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("%v: %v", ErrScrapeTimeout, err)
+	}
+
+	http.Error(w, message, code)
+	return err
+}
+
+//withContext returns the session with the context of a scrape
+func (s *session) withContext(ctx context.Context) *session {
+	scrape := *s
+	scrape.ctx = ctx
+	return &scrape
+}
@@ -0,0 +1,187 @@
+/*
+Copyright (c) 2018 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package vsphere
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	assert "github.com/stretchr/testify/assert"
+
+	"github.com/vmware/govmomi/vim25/soap"
+	"github.com/vmware/govmomi/vim25/types"
+
+	config "github.com/dvonthenen/vsphere-metrics-prometheus/config"
+)
+
+//scrapeIDKey carries the number of a scrape to the fake vCenter
+type scrapeIDKey struct{}
+
+func TestScrapeTimeout(t *testing.T) {
+	r := httptest.NewRequest("GET", "/metrics", nil)
+	assert.Equal(t, time.Duration(0), scrapeTimeout(r, 500*time.Millisecond))
+
+	r.Header.Set(scrapeTimeoutHeader, "10")
+	assert.Equal(t, 9500*time.Millisecond, scrapeTimeout(r, 500*time.Millisecond))
+
+	r.Header.Set(scrapeTimeoutHeader, "0.25")
+	assert.Equal(t, 250*time.Millisecond, scrapeTimeout(r, 500*time.Millisecond))
+
+	r.Header.Set(scrapeTimeoutHeader, "soon")
+	assert.Equal(t, time.Duration(0), scrapeTimeout(r, 500*time.Millisecond))
+}
+
+func TestScrapeContext(t *testing.T) {
+	c := NewClient(&config.Config{ScrapeTimeoutOffset: time.Second})
+
+	r := httptest.NewRequest("GET", "/metrics", nil)
+	r.Header.Set(scrapeTimeoutHeader, "3")
+	ctx, cancel := c.scrapeContext(r)
+	deadline, ok := ctx.Deadline()
+	assert.True(t, ok)
+	assert.InDelta(t, 2*time.Second, time.Until(deadline), float64(100*time.Millisecond))
+
+	c.endScrape(ctx, cancel)
+	assert.Equal(t, context.Canceled, ctx.Err())
+	assert.Equal(t, 0.0, gaugeValue(t, c))
+}
+
+func TestScrapeError(t *testing.T) {
+	c := NewClient(&config.Config{})
+	failure := errors.New("connection reset")
+
+	w := httptest.NewRecorder()
+	err := scrapeError(context.Background(), w, failure, "Unable find the Datacener", http.StatusGone)
+	assert.Equal(t, failure, err)
+	assert.Equal(t, http.StatusGone, w.Code)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Nanosecond)
+	<-ctx.Done()
+
+	w = httptest.NewRecorder()
+	err = scrapeError(ctx, w, failure, "Unable find the Datacener", http.StatusGone)
+	assert.Contains(t, err.Error(), ErrScrapeTimeout.Error())
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Body.String())
+
+	c.endScrape(ctx, cancel)
+	assert.Equal(t, 1.0, gaugeValue(t, c))
+}
+
+func gaugeValue(t *testing.T, c *Client) float64 {
+	var metric dto.Metric
+	assert.NoError(t, c.metricScrapeTimeout.Write(&metric))
+	return metric.GetGauge().GetValue()
+}
+
+func TestConcurrentScrapes(t *testing.T) {
+	c := NewClient(&config.Config{VSphereType: string(config.VSphereRoleLicense)})
+	assert.NoError(t, c.registerLicenseMetrics())
+
+	// Every scrape sees its own license, slowly enough for the scrapes to overlap
+	c.sessions.current = newFakeSession(&fakeVCenter{
+		properties: func(ctx context.Context, obj types.ManagedObjectReference) []types.DynamicProperty {
+			if obj.Type != "LicenseManager" {
+				return nil
+			}
+			time.Sleep(time.Millisecond)
+			id, _ := ctx.Value(scrapeIDKey{}).(int)
+			licenses := types.ArrayOfLicenseManagerLicenseInfo{
+				LicenseManagerLicenseInfo: []types.LicenseManagerLicenseInfo{
+					{LicenseKey: "AAAAA-BBBBB-CCCCC-DDDDD-EEEEE", Name: fmt.Sprintf("license-%d", id), Total: int32(id)},
+				},
+			}
+			return []types.DynamicProperty{{Name: "licenses", Val: licenses}}
+		},
+		call: func(ctx context.Context, req, res soap.HasFault) error {
+			return errors.New("unexpected call")
+		},
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+
+			r := httptest.NewRequest("GET", "/license/metrics", nil)
+			r = r.WithContext(context.WithValue(r.Context(), scrapeIDKey{}, id))
+			w := httptest.NewRecorder()
+			assert.NoError(t, c.Serve(w, r, c.GetVSphereLicenseStats))
+
+			body, err := ioutil.ReadAll(w.Body)
+			assert.NoError(t, err)
+			assert.Equal(t, 3, strings.Count(string(body), "license-"), "scrape %d", id)
+			assert.Contains(t, string(body), fmt.Sprintf(`name="license-%d"`, id))
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestServeTimeout(t *testing.T) {
+	c := NewClient(&config.Config{VSphereType: string(config.VSphereRoleVirtualMachine)})
+
+	serve := func(collect func(http.ResponseWriter, *http.Request) error) (string, error) {
+		r := httptest.NewRequest("GET", "/datacenter/dc1/vm/vm1/metrics", nil)
+		r.Header.Set(scrapeTimeoutHeader, "0.05")
+		w := httptest.NewRecorder()
+		err := c.Serve(w, r, collect)
+		return w.Body.String(), err
+	}
+	timeout := func(w http.ResponseWriter, r *http.Request) error {
+		<-r.Context().Done()
+		return scrapeError(r.Context(), w, r.Context().Err(), "Unable find the VM", http.StatusBadRequest)
+	}
+
+	// Nothing of the entity was collected, only the timeout is served
+	body, err := serve(timeout)
+	assert.Contains(t, err.Error(), ErrScrapeTimeout.Error())
+	assert.Contains(t, body, "vsphere_scrape_timeout 1")
+	assert.NotContains(t, body, "vsphere_test_partial")
+
+	// The partial results of the entity are served
+	myMetric := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "vsphere_test_partial", Help: "Test"}, []string{"datacenter"})
+	c.metricsMapVM[-1] = myMetric
+	c.registry.MustRegister(myMetric)
+
+	body, err = serve(func(w http.ResponseWriter, r *http.Request) error {
+		myMetric.WithLabelValues("dc1").Set(1)
+		return timeout(w, r)
+	})
+	assert.Contains(t, err.Error(), ErrScrapeTimeout.Error())
+	assert.Contains(t, body, `vsphere_test_partial{datacenter="dc1"} 1`)
+
+	// A scrape that waits too long for another one only serves the timeout
+	c.scrapes <- struct{}{}
+	body, err = serve(func(w http.ResponseWriter, r *http.Request) error {
+		t.Error("collected while another scrape was running")
+		return nil
+	})
+	<-c.scrapes
+	assert.Contains(t, err.Error(), ErrScrapeTimeout.Error())
+	assert.Contains(t, body, "vsphere_scrape_timeout 1")
+	assert.NotContains(t, body, "vsphere_test_partial")
+}
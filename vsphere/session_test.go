@@ -0,0 +1,144 @@
+/*
+Copyright (c) 2018 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package vsphere
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	assert "github.com/stretchr/testify/assert"
+
+	config "github.com/dvonthenen/vsphere-metrics-prometheus/config"
+)
+
+func TestSessionManagerSingleflight(t *testing.T) {
+	var m sessionManager
+	var logins int32
+
+	release := make(chan struct{})
+	connect := func(current *session) (*session, error) {
+		if current != nil {
+			return current, nil
+		}
+		atomic.AddInt32(&logins, 1)
+		<-release
+		return &session{created: time.Now()}, nil
+	}
+
+	var wg sync.WaitGroup
+	sessions := make([]*session, 20)
+	for i := range sessions {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			s, err := m.get(context.Background(), connect)
+			assert.NoError(t, err)
+			sessions[i] = s
+		}(i)
+	}
+
+	// Let the callers pile up behind the first login
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&logins))
+	for _, s := range sessions {
+		assert.True(t, s == sessions[0])
+	}
+
+	// A valid session is handed out again
+	s, err := m.get(context.Background(), connect)
+	assert.NoError(t, err)
+	assert.True(t, s == sessions[0])
+	assert.Equal(t, int32(1), atomic.LoadInt32(&logins))
+}
+
+func TestSessionManagerBackoff(t *testing.T) {
+	var m sessionManager
+	var logins int32
+
+	failure := errors.New("wrong password")
+	connect := func(current *session) (*session, error) {
+		atomic.AddInt32(&logins, 1)
+		return nil, failure
+	}
+
+	_, err := m.get(context.Background(), connect)
+	assert.Equal(t, failure, err)
+
+	// The next login waits for the backoff
+	_, err = m.get(context.Background(), connect)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), ErrLoginBackoff.Error())
+	assert.Contains(t, err.Error(), "wrong password")
+	assert.Equal(t, int32(1), atomic.LoadInt32(&logins))
+
+	m.mutex.Lock()
+	m.retryAt = time.Now()
+	m.mutex.Unlock()
+
+	_, err = m.get(context.Background(), func(current *session) (*session, error) {
+		return &session{}, nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, m.failures)
+}
+
+func TestLoginBackoff(t *testing.T) {
+	assert.Equal(t, time.Second, loginBackoff(1))
+	assert.Equal(t, 2*time.Second, loginBackoff(2))
+	assert.Equal(t, 8*time.Second, loginBackoff(4))
+	assert.Equal(t, loginBackoffMax, loginBackoff(100))
+}
+
+func TestLoginTimeout(t *testing.T) {
+	hang := make(chan struct{})
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-hang
+	}))
+	defer server.Close()
+	defer close(hang)
+
+	defer func(timeout time.Duration) { loginTimeout = timeout }(loginTimeout)
+	loginTimeout = 100 * time.Millisecond
+
+	u, _ := url.Parse(server.URL)
+	port, _ := strconv.Atoi(u.Port())
+	c := &Client{config: &config.Config{
+		VSphereHostname: u.Hostname(),
+		VSpherePort:     port,
+		VSphereInsecure: true,
+	}}
+
+	// The login in flight gives up, so the next scrape is not stuck behind it
+	start := time.Now()
+	_, err := c.getSession(context.Background())
+	assert.Error(t, err)
+	assert.True(t, time.Since(start) < 5*time.Second)
+
+	c.sessions.mutex.Lock()
+	assert.Nil(t, c.sessions.inflight)
+	c.sessions.mutex.Unlock()
+}
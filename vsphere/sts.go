@@ -0,0 +1,322 @@
+/*
+Copyright (c) 2018 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"reflect"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/vmware/govmomi"
+	"github.com/vmware/govmomi/vim25/methods"
+	"github.com/vmware/govmomi/vim25/soap"
+	"github.com/vmware/govmomi/vim25/types"
+	"github.com/vmware/govmomi/vim25/xml"
+)
+
+//stsPath is where vCenter serves the Security Token Service. govmomi falls
+//back to the same path without a lookup service.
+const stsPath = "/sts/STSService"
+
+//The namespaces and URIs of the signed STS and LoginByToken requests
+const (
+	nsSoapEnv  = "http://schemas.xmlsoap.org/soap/envelope/"
+	nsWsse     = "http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-wssecurity-secext-1.0.xsd"
+	nsWsse11   = "http://docs.oasis-open.org/wss/oasis-wss-wssecurity-secext-1.1.xsd"
+	nsWsu      = "http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-wssecurity-utility-1.0.xsd"
+	nsDsig     = "http://www.w3.org/2000/09/xmldsig#"
+	nsWsTrust  = "http://docs.oasis-open.org/ws-sx/ws-trust/200512"
+	uriExcC14N = "http://www.w3.org/2001/10/xml-exc-c14n#"
+	uriRSA256  = "http://www.w3.org/2001/04/xmldsig-more#rsa-sha256"
+	uriSHA256  = "http://www.w3.org/2001/04/xmlenc#sha256"
+	uriX509v3  = "http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-x509-token-profile-1.0#X509v3"
+	uriBase64  = "http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-soap-message-security-1.0#Base64Binary"
+	uriSAML2   = "http://docs.oasis-open.org/wss/oasis-wss-saml-token-profile-1.1#SAMLV2.0"
+	uriSAMLID  = "http://docs.oasis-open.org/wss/oasis-wss-saml-token-profile-1.1#SAMLID"
+)
+
+var (
+	//ErrCertificateKey - The client certificate of certificate auth has no RSA key
+	ErrCertificateKey = errors.New("Certificate auth needs an RSA private key")
+
+	//ErrNoAssertion - STS answered without a SAML assertion
+	ErrNoAssertion = errors.New("STS returned no holder-of-key token")
+)
+
+//stsIssueRequest is the WS-Trust request for a SAML 2.0 holder-of-key token
+//bound to the key of the signing certificate
+type stsIssueRequest struct {
+	XMLName            xml.Name    `xml:"http://docs.oasis-open.org/ws-sx/ws-trust/200512 RequestSecurityToken"`
+	TokenType          string      `xml:"TokenType"`
+	RequestType        string      `xml:"RequestType"`
+	Lifetime           stsLifetime `xml:"Lifetime"`
+	Renewing           stsRenewing `xml:"Renewing"`
+	Delegatable        bool        `xml:"Delegatable"`
+	KeyType            string      `xml:"KeyType"`
+	SignatureAlgorithm string      `xml:"SignatureAlgorithm"`
+	UseKey             stsUseKey   `xml:"UseKey"`
+}
+
+type stsLifetime struct {
+	Created string `xml:"http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-wssecurity-utility-1.0.xsd Created"`
+	Expires string `xml:"http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-wssecurity-utility-1.0.xsd Expires"`
+}
+
+type stsRenewing struct {
+	Allow bool `xml:"Allow,attr"`
+	OK    bool `xml:"OK,attr"`
+}
+
+type stsUseKey struct {
+	Sig string `xml:"Sig,attr"`
+}
+
+//stsIssueResponse holds the assertion as STS sent it, its signature covers
+//the exact bytes
+type stsIssueResponse struct {
+	Response struct {
+		Token struct {
+			Assertion string `xml:",innerxml"`
+		} `xml:"RequestedSecurityToken"`
+	} `xml:"RequestSecurityTokenResponse"`
+}
+
+//stsIssueBody is the SOAP body of the STS Issue call
+type stsIssueBody struct {
+	Req    *stsIssueRequest  `xml:"http://docs.oasis-open.org/ws-sx/ws-trust/200512 RequestSecurityToken,omitempty"`
+	Res    *stsIssueResponse `xml:"http://docs.oasis-open.org/ws-sx/ws-trust/200512 RequestSecurityTokenResponseCollection,omitempty"`
+	Fault_ *soap.Fault       `xml:"http://schemas.xmlsoap.org/soap/envelope/ Fault,omitempty"`
+}
+
+//Fault implements soap.HasFault
+func (b *stsIssueBody) Fault() *soap.Fault { return b.Fault_ }
+
+//method names the call in the SOAP metrics
+func (b *stsIssueBody) method() string { return "Issue" }
+
+//loginByCertificate logs in with a SAML holder-of-key token. STS issues the
+//token for the client certificate, and the LoginByToken request is signed
+//with its key to prove the exporter holds the key the token is bound to.
+func loginByCertificate(ctx context.Context, soapClient *soap.Client, cert *tls.Certificate) (*govmomi.Client, error) {
+	key, ok := cert.PrivateKey.(*rsa.PrivateKey)
+	if !ok || len(cert.Certificate) == 0 {
+		return nil, ErrCertificateKey
+	}
+
+	client, err := newGovmomiClient(ctx, soapClient)
+	if err != nil {
+		return nil, err
+	}
+
+	stsURL := soapClient.URL()
+	stsURL.Path = stsPath
+
+	assertion, err := issueHolderOfKeyToken(ctx, soapClient, stsURL, cert.Certificate[0], key)
+	if err != nil {
+		return nil, err
+	}
+
+	var token struct {
+		ID string `xml:"ID,attr"`
+	}
+	err = xml.Unmarshal([]byte(assertion), &token)
+	if err != nil || token.ID == "" {
+		return nil, ErrNoAssertion
+	}
+
+	roundTripper := &instrumentedRoundTripper{
+		roundTripper: &signedRoundTripper{
+			client: soapClient,
+			url:    soapClient.URL(),
+			action: fmt.Sprintf("%s/%s", soapClient.Namespace, soapClient.Version),
+			key:    key,
+			token:  assertion,
+			keyInfo: fmt.Sprintf(`<wsse:SecurityTokenReference xmlns:wsse11="%s" wsse11:TokenType="%s"><wsse:KeyIdentifier ValueType="%s">%s</wsse:KeyIdentifier></wsse:SecurityTokenReference>`,
+				nsWsse11, uriSAML2, uriSAMLID, token.ID),
+		},
+	}
+	res, err := methods.LoginByToken(ctx, roundTripper, &types.LoginByToken{
+		This: *client.ServiceContent.SessionManager,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	log.Debugln("Logged in by holder-of-key token as", res.Returnval.UserName)
+
+	return client, nil
+}
+
+//issueHolderOfKeyToken asks STS for a SAML token bound to the certificate.
+//The request carries the certificate and is signed with its key.
+func issueHolderOfKeyToken(ctx context.Context, soapClient *soap.Client, stsURL *url.URL, cert []byte, key *rsa.PrivateKey) (string, error) {
+	certID, err := newSecurityID()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now().UTC()
+	body := &stsIssueBody{
+		Req: &stsIssueRequest{
+			TokenType:   "urn:oasis:names:tc:SAML:2.0:assertion",
+			RequestType: nsWsTrust + "/Issue",
+			Lifetime: stsLifetime{
+				Created: now.Format(wsTimeFormat),
+				Expires: now.Add(tokenLifetime).Format(wsTimeFormat),
+			},
+			Delegatable:        true,
+			KeyType:            nsWsTrust + "/PublicKey",
+			SignatureAlgorithm: uriRSA256,
+			UseKey:             stsUseKey{Sig: certID},
+		},
+	}
+
+	roundTripper := &instrumentedRoundTripper{
+		roundTripper: &signedRoundTripper{
+			client: soapClient,
+			url:    stsURL,
+			action: nsWsTrust + "/RST/Issue",
+			key:    key,
+			token: fmt.Sprintf(`<wsse:BinarySecurityToken EncodingType="%s" ValueType="%s" wsu:Id="%s">%s</wsse:BinarySecurityToken>`,
+				uriBase64, uriX509v3, certID, base64.StdEncoding.EncodeToString(cert)),
+			keyInfo: fmt.Sprintf(`<wsse:SecurityTokenReference><wsse:Reference URI="#%s" ValueType="%s"></wsse:Reference></wsse:SecurityTokenReference>`,
+				certID, uriX509v3),
+		},
+	}
+
+	res := &stsIssueBody{}
+	err = roundTripper.RoundTrip(ctx, body, res)
+	if err != nil {
+		return "", err
+	}
+	if res.Res == nil {
+		return "", ErrNoAssertion
+	}
+
+	assertion := strings.TrimSpace(res.Res.Response.Token.Assertion)
+	if assertion == "" {
+		return "", ErrNoAssertion
+	}
+
+	return assertion, nil
+}
+
+//signedRoundTripper sends a SOAP call with a WS-Security header holding a
+//token and a signature over the timestamp and the body. The signed parts are
+//written in their exclusive canonical form, so they are digested as sent.
+type signedRoundTripper struct {
+	client  *soap.Client
+	url     *url.URL
+	action  string
+	key     *rsa.PrivateKey
+	token   string
+	keyInfo string
+}
+
+//RoundTrip implements soap.RoundTripper
+func (t *signedRoundTripper) RoundTrip(ctx context.Context, reqBody, resBody soap.HasFault) error {
+	timestampID, err := newSecurityID()
+	if err != nil {
+		return err
+	}
+	bodyID, err := newSecurityID()
+	if err != nil {
+		return err
+	}
+
+	call, err := marshalCall(reqBody)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	timestamp := fmt.Sprintf(`<wsu:Timestamp xmlns:wsu="%s" wsu:Id="%s"><wsu:Created>%s</wsu:Created><wsu:Expires>%s</wsu:Expires></wsu:Timestamp>`,
+		nsWsu, timestampID, now.Format(wsTimeFormat), now.Add(tokenLifetime).Format(wsTimeFormat))
+	body := fmt.Sprintf(`<soapenv:Body xmlns:soapenv="%s" xmlns:wsu="%s" wsu:Id="%s">%s</soapenv:Body>`,
+		nsSoapEnv, nsWsu, bodyID, call)
+
+	signedInfo := fmt.Sprintf(`<ds:SignedInfo xmlns:ds="%s"><ds:CanonicalizationMethod Algorithm="%s"></ds:CanonicalizationMethod><ds:SignatureMethod Algorithm="%s"></ds:SignatureMethod>%s%s</ds:SignedInfo>`,
+		nsDsig, uriExcC14N, uriRSA256, signedReference(timestampID, timestamp), signedReference(bodyID, body))
+
+	digest := sha256.Sum256([]byte(signedInfo))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, t.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return err
+	}
+
+	envelope := fmt.Sprintf(`%s<soapenv:Envelope xmlns:soapenv="%s"><soapenv:Header><wsse:Security xmlns:wsse="%s" xmlns:wsu="%s">%s%s<ds:Signature xmlns:ds="%s">%s<ds:SignatureValue>%s</ds:SignatureValue><ds:KeyInfo>%s</ds:KeyInfo></ds:Signature></wsse:Security></soapenv:Header>%s</soapenv:Envelope>`,
+		xml.Header, nsSoapEnv, nsWsse, nsWsu, timestamp, t.token, nsDsig, signedInfo, base64.StdEncoding.EncodeToString(signature), t.keyInfo, body)
+
+	return postEnvelope(ctx, t.client, t.url.String(), t.action, envelope, resBody)
+}
+
+//marshalCall encodes the Req field of a SOAP body, like those of the methods
+//package, under the element name and namespace of its tag
+func marshalCall(reqBody soap.HasFault) ([]byte, error) {
+	value := reflect.ValueOf(reqBody).Elem()
+	field, ok := value.Type().FieldByName("Req")
+	if !ok {
+		return nil, fmt.Errorf("unexpected SOAP body %T", reqBody)
+	}
+
+	start := xml.StartElement{}
+	name := strings.Split(field.Tag.Get("xml"), ",")[0]
+	if i := strings.LastIndex(name, " "); i >= 0 {
+		start.Name.Space, start.Name.Local = name[:i], name[i+1:]
+	} else {
+		start.Name.Local = name
+	}
+
+	var buf bytes.Buffer
+	err := xml.NewEncoder(&buf).EncodeElement(value.FieldByIndex(field.Index).Interface(), start)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+//signedReference is the reference of the signature to the element with the
+//id, with the digest of its canonical form
+func signedReference(id string, canonical string) string {
+	digest := sha256.Sum256([]byte(canonical))
+	return fmt.Sprintf(`<ds:Reference URI="#%s"><ds:Transforms><ds:Transform Algorithm="%s"></ds:Transform></ds:Transforms><ds:DigestMethod Algorithm="%s"></ds:DigestMethod><ds:DigestValue>%s</ds:DigestValue></ds:Reference>`,
+		id, uriExcC14N, uriSHA256, base64.StdEncoding.EncodeToString(digest[:]))
+}
+
+//newSecurityID returns a random id for the signed parts of a request
+func newSecurityID() (string, error) {
+	id := make([]byte, 16)
+	_, err := rand.Read(id)
+	if err != nil {
+		return "", err
+	}
+	return "_" + hex.EncodeToString(id), nil
+}
@@ -0,0 +1,206 @@
+/*
+Copyright (c) 2018 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	assert "github.com/stretchr/testify/assert"
+
+	"github.com/vmware/govmomi/vim25/soap"
+)
+
+const testAssertion = `<saml2:Assertion xmlns:saml2="urn:oasis:names:tc:SAML:2.0:assertion" ID="_hok" Version="2.0"><saml2:Issuer>https://vcenter/websso/SAML2/Metadata/vsphere.local</saml2:Issuer></saml2:Assertion>`
+
+const issueResponse = `<?xml version="1.0" encoding="UTF-8"?>
+<S:Envelope xmlns:S="http://schemas.xmlsoap.org/soap/envelope/"><S:Body>
+<wst:RequestSecurityTokenResponseCollection xmlns:wst="http://docs.oasis-open.org/ws-sx/ws-trust/200512">
+<wst:RequestSecurityTokenResponse><wst:TokenType>urn:oasis:names:tc:SAML:2.0:assertion</wst:TokenType>
+<wst:RequestedSecurityToken>` + testAssertion + `</wst:RequestedSecurityToken>
+</wst:RequestSecurityTokenResponse></wst:RequestSecurityTokenResponseCollection></S:Body></S:Envelope>`
+
+const issueFaultResponse = `<?xml version="1.0" encoding="UTF-8"?>
+<S:Envelope xmlns:S="http://schemas.xmlsoap.org/soap/envelope/"><S:Body><S:Fault>
+<faultcode xmlns:ns0="http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-wssecurity-secext-1.0.xsd">ns0:FailedAuthentication</faultcode>
+<faultstring>Unknown certificate</faultstring>
+</S:Fault></S:Body></S:Envelope>`
+
+var (
+	signedInfoPattern = regexp.MustCompile(`<ds:SignedInfo .*?</ds:SignedInfo>`)
+	referencePattern  = regexp.MustCompile(`<ds:Reference URI="#([^"]+)">.*?<ds:DigestValue>([^<]+)</ds:DigestValue>`)
+	signaturePattern  = regexp.MustCompile(`<ds:SignatureValue>([^<]+)</ds:SignatureValue>`)
+	timestampPattern  = regexp.MustCompile(`<wsu:Timestamp [^>]*wsu:Id="([^"]+)">.*?</wsu:Timestamp>`)
+	bodyPattern       = regexp.MustCompile(`<soapenv:Body [^>]*wsu:Id="([^"]+)">.*</soapenv:Body>`)
+	certIDPattern     = regexp.MustCompile(`<wsse:BinarySecurityToken [^>]*wsu:Id="([^"]+)">([^<]+)<`)
+	useKeyPattern     = regexp.MustCompile(`<UseKey Sig="([^"]+)">`)
+)
+
+func newTestCertificate(t *testing.T) tls.Certificate {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "vsphere-metrics-prometheus"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+//verifySignedRequest checks that the timestamp and the body of the request
+//are signed by the key
+func verifySignedRequest(t *testing.T, request string, key *rsa.PublicKey) {
+	signed := make(map[string]string)
+	for _, pattern := range []*regexp.Regexp{timestampPattern, bodyPattern} {
+		match := pattern.FindStringSubmatch(request)
+		if assert.Len(t, match, 2) {
+			signed[match[1]] = match[0]
+			assert.NotContains(t, match[0], "/>")
+		}
+	}
+
+	signedInfo := signedInfoPattern.FindString(request)
+	references := referencePattern.FindAllStringSubmatch(signedInfo, -1)
+	assert.Len(t, references, 2)
+	for _, reference := range references {
+		element, ok := signed[reference[1]]
+		assert.True(t, ok, "reference to unknown element %s", reference[1])
+		digest := sha256.Sum256([]byte(element))
+		assert.Equal(t, base64.StdEncoding.EncodeToString(digest[:]), reference[2])
+	}
+
+	match := signaturePattern.FindStringSubmatch(request)
+	if !assert.Len(t, match, 2) {
+		return
+	}
+	signature, err := base64.StdEncoding.DecodeString(match[1])
+	assert.NoError(t, err)
+	digest := sha256.Sum256([]byte(signedInfo))
+	assert.NoError(t, rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature))
+}
+
+func newSTSServer(t *testing.T, cert tls.Certificate, issue func(body string) (int, string)) *httptest.Server {
+	key := &cert.PrivateKey.(*rsa.PrivateKey).PublicKey
+
+	return httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, err := ioutil.ReadAll(r.Body)
+		assert.NoError(t, err)
+		body := string(data)
+
+		switch {
+		case r.URL.Path == stsPath:
+			assert.Equal(t, "http://docs.oasis-open.org/ws-sx/ws-trust/200512/RST/Issue", r.Header.Get("SOAPAction"))
+			verifySignedRequest(t, body, key)
+
+			// The token is requested for the key of the certificate in the header
+			token := certIDPattern.FindStringSubmatch(body)
+			useKey := useKeyPattern.FindStringSubmatch(body)
+			if assert.Len(t, token, 3) && assert.Len(t, useKey, 2) {
+				assert.Equal(t, token[1], useKey[1])
+				assert.Equal(t, base64.StdEncoding.EncodeToString(cert.Certificate[0]), token[2])
+			}
+			assert.Contains(t, body, "/PublicKey</KeyType>")
+
+			status, response := issue(body)
+			w.WriteHeader(status)
+			fmt.Fprint(w, response)
+		case strings.Contains(body, "RetrieveServiceContent"):
+			fmt.Fprint(w, serviceContentResponse)
+		case strings.Contains(body, "LoginByToken"):
+			verifySignedRequest(t, body, key)
+
+			// The assertion is passed as issued and named as the signing key
+			assert.Contains(t, body, testAssertion)
+			assert.Contains(t, body, `#SAMLID">_hok</wsse:KeyIdentifier>`)
+			assert.Contains(t, body, `<LoginByToken xmlns="urn:vim25"><_this type="SessionManager">SessionManager</_this></LoginByToken>`)
+
+			http.SetCookie(w, &http.Cookie{Name: "vmware_soap_session", Value: "session"})
+			fmt.Fprint(w, loginByTokenResponse)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func TestLoginByCertificate(t *testing.T) {
+	cert := newTestCertificate(t)
+	server := newSTSServer(t, cert, func(body string) (int, string) {
+		return http.StatusOK, issueResponse
+	})
+	defer server.Close()
+
+	var before dto.Metric
+	assert.NoError(t, metricSoapDuration.WithLabelValues("Issue").(prometheus.Metric).Write(&before))
+
+	u, _ := url.Parse(server.URL + "/sdk")
+	client, err := loginByCertificate(context.Background(), soap.NewClient(u, true), &cert)
+	assert.NoError(t, err)
+	assert.NotNil(t, client)
+
+	// The STS call is timed like the calls of the session
+	var after dto.Metric
+	assert.NoError(t, metricSoapDuration.WithLabelValues("Issue").(prometheus.Metric).Write(&after))
+	assert.Equal(t, before.GetHistogram().GetSampleCount()+1, after.GetHistogram().GetSampleCount())
+
+	assert.Len(t, client.Client.Client.Jar.Cookies(u), 1)
+}
+
+func TestLoginByCertificateFault(t *testing.T) {
+	cert := newTestCertificate(t)
+	server := newSTSServer(t, cert, func(body string) (int, string) {
+		return http.StatusInternalServerError, issueFaultResponse
+	})
+	defer server.Close()
+
+	u, _ := url.Parse(server.URL + "/sdk")
+	_, err := loginByCertificate(context.Background(), soap.NewClient(u, true), &cert)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "Unknown certificate")
+	assert.Equal(t, 1.0, counterValue(t, metricSoapFaults.WithLabelValues("Issue", "ns0:FailedAuthentication")))
+
+	// STS only signs with RSA keys
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	_, err = loginByCertificate(context.Background(), soap.NewClient(u, true), &tls.Certificate{Certificate: cert.Certificate, PrivateKey: key})
+	assert.Equal(t, ErrCertificateKey, err)
+}
@@ -0,0 +1,459 @@
+/*
+Copyright (c) 2018 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/vmware/govmomi/vim25/types"
+
+	config "github.com/dvonthenen/vsphere-metrics-prometheus/config"
+)
+
+const (
+	//vAPI session header returned by the session service
+	tagSessionHeader = "vmware-api-session-id"
+
+	tagSessionPath     = "/com/vmware/cis/session"
+	tagListPath        = "/com/vmware/cis/tagging/tag"
+	tagCategoryPath    = "/com/vmware/cis/tagging/category"
+	tagAssociationPath = "/com/vmware/cis/tagging/tag-association"
+)
+
+var (
+	//ErrTagSessionUnauthorized - The vAPI endpoint rejected the credentials
+	ErrTagSessionUnauthorized = errors.New("The vAPI endpoint rejected the credentials")
+)
+
+//tagInfo is a tag attached to an entity
+type tagInfo struct {
+	Category string
+	Name     string
+}
+
+//tagCache holds the tags of every tagged object keyed by object type and id
+type tagCache struct {
+	mutex     sync.RWMutex
+	tags      map[string][]tagInfo
+	timestamp time.Time
+}
+
+func tagKey(objType string, id string) string {
+	return objType + ":" + id
+}
+
+//Tags returns the tags attached to the referenced object
+func (t *tagCache) Tags(ref types.ManagedObjectReference) []tagInfo {
+	if t == nil {
+		return nil
+	}
+
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+
+	return t.tags[tagKey(ref.Type, ref.Value)]
+}
+
+//Loaded tells whether the tags have been fetched at least once
+func (t *tagCache) Loaded() bool {
+	if t == nil {
+		return false
+	}
+
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+
+	return !t.timestamp.IsZero()
+}
+
+func (t *tagCache) update(tags map[string][]tagInfo) {
+	t.mutex.Lock()
+	t.tags = tags
+	t.timestamp = time.Now()
+	t.mutex.Unlock()
+}
+
+//tagClient is a minimal client for the vAPI REST tagging service
+type tagClient struct {
+	url         *url.URL
+	credentials func() (string, string)
+	client      *http.Client
+	session     string
+	mutex       sync.Mutex
+}
+
+//newTagClient creates a client for the vAPI endpoint found at base, e.g.
+//https://vcenter/rest. The credentials are asked for on every login so a
+//rotated password is picked up.
+func newTagClient(base *url.URL, credentials func() (string, string), insecure bool) *tagClient {
+	return &tagClient{
+		url:         base,
+		credentials: credentials,
+		client: &http.Client{
+			Timeout: 60 * time.Second,
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: insecure},
+			},
+		},
+	}
+}
+
+func (t *tagClient) login() error {
+	req, err := http.NewRequest(http.MethodPost, t.url.String()+tagSessionPath, nil)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(t.credentials())
+
+	res, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusUnauthorized {
+		return ErrTagSessionUnauthorized
+	}
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("vAPI login failed: %s", res.Status)
+	}
+
+	var session struct {
+		Value string `json:"value"`
+	}
+	err = json.NewDecoder(res.Body).Decode(&session)
+	if err != nil {
+		return err
+	}
+
+	t.session = session.Value
+
+	return nil
+}
+
+//Logout ends the vAPI session
+func (t *tagClient) Logout() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.session == "" {
+		return
+	}
+
+	req, err := http.NewRequest(http.MethodDelete, t.url.String()+tagSessionPath, nil)
+	if err == nil {
+		req.Header.Set(tagSessionHeader, t.session)
+		res, err := t.client.Do(req)
+		if err == nil {
+			res.Body.Close()
+		}
+	}
+
+	t.session = ""
+}
+
+//do runs the request and decodes the value of the response into out. The
+//session is (re)established when missing or expired.
+func (t *tagClient) do(method string, path string, query url.Values, out interface{}) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	for attempt := 0; attempt < 2; attempt++ {
+		if t.session == "" {
+			err := t.login()
+			if err != nil {
+				return err
+			}
+		}
+
+		u := t.url.String() + path
+		if len(query) > 0 {
+			u += "?" + query.Encode()
+		}
+
+		req, err := http.NewRequest(method, u, nil)
+		if err != nil {
+			return err
+		}
+		req.Header.Set(tagSessionHeader, t.session)
+		req.Header.Set("Accept", "application/json")
+
+		res, err := t.client.Do(req)
+		if err != nil {
+			return err
+		}
+
+		if res.StatusCode == http.StatusUnauthorized {
+			io.Copy(ioutil.Discard, res.Body)
+			res.Body.Close()
+			t.session = ""
+			continue
+		}
+
+		if res.StatusCode != http.StatusOK {
+			res.Body.Close()
+			return fmt.Errorf("%s %s failed: %s", method, path, res.Status)
+		}
+
+		value := struct {
+			Value interface{} `json:"value"`
+		}{Value: out}
+		err = json.NewDecoder(res.Body).Decode(&value)
+		res.Body.Close()
+
+		return err
+	}
+
+	return ErrTagSessionUnauthorized
+}
+
+//Fetch walks every tag, its category and the objects it is attached to
+func (t *tagClient) Fetch() (map[string][]tagInfo, error) {
+	var tagIDs []string
+	err := t.do(http.MethodGet, tagListPath, nil, &tagIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	categories := make(map[string]string)
+	tags := make(map[string][]tagInfo)
+
+	for _, tagID := range tagIDs {
+		var tag struct {
+			ID         string `json:"id"`
+			Name       string `json:"name"`
+			CategoryID string `json:"category_id"`
+		}
+		err = t.do(http.MethodGet, tagListPath+"/id:"+url.PathEscape(tagID), nil, &tag)
+		if err != nil {
+			return nil, err
+		}
+
+		category, ok := categories[tag.CategoryID]
+		if !ok {
+			var cat struct {
+				Name string `json:"name"`
+			}
+			err = t.do(http.MethodGet, tagCategoryPath+"/id:"+url.PathEscape(tag.CategoryID), nil, &cat)
+			if err != nil {
+				return nil, err
+			}
+			category = cat.Name
+			categories[tag.CategoryID] = category
+		}
+
+		var objects []struct {
+			ID   string `json:"id"`
+			Type string `json:"type"`
+		}
+		query := url.Values{"~action": []string{"list-attached-objects"}}
+		err = t.do(http.MethodPost, tagAssociationPath+"/id:"+url.PathEscape(tagID), query, &objects)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, object := range objects {
+			key := tagKey(object.Type, object.ID)
+			tags[key] = append(tags[key], tagInfo{Category: category, Name: tag.Name})
+		}
+	}
+
+	return tags, nil
+}
+
+//tagURL derives the vAPI REST endpoint from the vCenter connection settings
+func tagURL(hostname string, port int) *url.URL {
+	host := hostname
+	if port > 0 {
+		host = hostname + ":" + strconv.Itoa(port)
+	}
+
+	return &url.URL{
+		Scheme: "https",
+		Host:   host,
+		Path:   "/rest",
+	}
+}
+
+func (c *Client) registerTagMetrics() error {
+	log.Debugln("registerTagMetrics ENTER")
+
+	if c.config.TagRefreshInterval <= 0 {
+		log.Infoln("vSphere tags disabled")
+		log.Debugln("registerTagMetrics LEAVE")
+		return nil
+	}
+
+	c.metricTagInfo = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "vsphere",
+			Name:      "tag_info",
+			Help:      "vSphere tags attached to the entity",
+		},
+		[]string{"entity", "category", "tag"},
+	)
+	c.registry.MustRegister(c.metricTagInfo)
+
+	c.tags = &tagCache{}
+	c.tagClient = newTagClient(tagURL(c.config.VSphereHostname, c.config.VSpherePort),
+		c.tagCredentials, c.config.VSphereInsecure)
+	err := configureTransport(c.tagClient.client.Transport.(*http.Transport), c.config)
+	if err != nil {
+		log.Debugln("registerTagMetrics LEAVE")
+		return err
+	}
+
+	c.loops.Add(1)
+	go c.refreshTagsLoop()
+
+	log.Debugln("registerTagMetrics Succeeded")
+	log.Debugln("registerTagMetrics LEAVE")
+
+	return nil
+}
+
+//refreshTagsLoop refreshes the tags on the configured interval until Close
+func (c *Client) refreshTagsLoop() {
+	defer c.loops.Done()
+	defer c.tagClient.Logout()
+
+	for {
+		err := c.refreshTags()
+		if err != nil {
+			log.Errorln("refreshTags failed:", err)
+		}
+
+		select {
+		case <-c.stop:
+			return
+		case <-time.After(c.currentConfig().TagRefreshInterval):
+		}
+	}
+}
+
+//passwordCredentials tells whether cfg has a password to log in to the vAPI
+//endpoint with. The vAPI session service only takes a username and password.
+func passwordCredentials(cfg *config.Config) bool {
+	return config.Auth(cfg.VSphereAuth) == config.VSphereAuthPassword && cfg.VSpherePass != ""
+}
+
+//tagCredentials returns the vCenter credentials of the current config
+func (c *Client) tagCredentials() (string, string) {
+	cfg := c.currentConfig()
+	return cfg.VSphereUser, cfg.VSpherePass
+}
+
+func (c *Client) refreshTags() error {
+	log.Debugln("refreshTags ENTER")
+
+	// Logging in with the empty password of extension or token auth would
+	// count towards locking the account
+	if !passwordCredentials(c.currentConfig()) {
+		log.Debugln("refreshTags skipped. No password credentials.")
+		log.Debugln("refreshTags LEAVE")
+		return nil
+	}
+
+	tags, err := c.tagClient.Fetch()
+	if err != nil {
+		log.Debugln("refreshTags LEAVE")
+		return err
+	}
+
+	c.tags.update(tags)
+	log.Infoln("Refreshed tags for", len(tags), "objects")
+
+	log.Debugln("refreshTags Succeeded")
+	log.Debugln("refreshTags LEAVE")
+
+	return nil
+}
+
+//setTagMetrics publishes the tags of the scraped entity
+func (c *Client) setTagMetrics(name string, ref types.ManagedObjectReference) {
+	if c.metricTagInfo == nil {
+		return
+	}
+
+	c.metricTagInfo.Reset()
+	for _, tag := range c.tags.Tags(ref) {
+		c.metricTagInfo.WithLabelValues(name, tag.Category, tag.Name).Set(1)
+	}
+}
+
+//parseTagCategories parses the comma separated tag categories that become
+//tag_<category> labels on the info metrics
+func parseTagCategories(spec string) ([]customAttribute, error) {
+	var categories []customAttribute
+	seen := make(map[string]bool)
+
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		category := customAttribute{
+			Name:  entry,
+			Label: "tag_" + sanitizeLabelName(entry),
+		}
+		if category.Label == "tag_" {
+			return nil, fmt.Errorf("invalid tag category %q", entry)
+		}
+		if seen[category.Label] {
+			return nil, fmt.Errorf("tag category %q maps to duplicate label %q", entry, category.Label)
+		}
+		seen[category.Label] = true
+
+		categories = append(categories, category)
+	}
+
+	return categories, nil
+}
+
+//tagValues returns the tags of each category joined by a comma
+func tagValues(tags []tagInfo) map[string]string {
+	byCategory := make(map[string][]string)
+	for _, tag := range tags {
+		byCategory[tag.Category] = append(byCategory[tag.Category], tag.Name)
+	}
+
+	values := make(map[string]string)
+	for category, names := range byCategory {
+		sort.Strings(names)
+		values[category] = strings.Join(names, ",")
+	}
+
+	return values
+}
@@ -0,0 +1,199 @@
+/*
+Copyright (c) 2018 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package vsphere
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	assert "github.com/stretchr/testify/assert"
+
+	"github.com/vmware/govmomi/vim25/types"
+
+	config "github.com/dvonthenen/vsphere-metrics-prometheus/config"
+)
+
+func staticCredentials(user string, pass string) func() (string, string) {
+	return func() (string, string) {
+		return user, pass
+	}
+}
+
+func newTagServer(t *testing.T) (*httptest.Server, *int) {
+	logins := 0
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/rest"+tagSessionPath, func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		if !ok || user != "user" || pass != "pass" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		logins++
+		fmt.Fprintf(w, `{"value":"session-%d"}`, logins)
+	})
+	mux.HandleFunc("/rest/", func(w http.ResponseWriter, r *http.Request) {
+		// Expire the first session to exercise the re-login
+		if r.Header.Get(tagSessionHeader) != "session-2" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		switch r.URL.Path {
+		case "/rest" + tagListPath:
+			fmt.Fprint(w, `{"value":["tag-1","tag-2"]}`)
+		case "/rest" + tagListPath + "/id:tag-1":
+			fmt.Fprint(w, `{"value":{"id":"tag-1","name":"prod","category_id":"cat-1"}}`)
+		case "/rest" + tagListPath + "/id:tag-2":
+			fmt.Fprint(w, `{"value":{"id":"tag-2","name":"db","category_id":"cat-1"}}`)
+		case "/rest" + tagCategoryPath + "/id:cat-1":
+			fmt.Fprint(w, `{"value":{"id":"cat-1","name":"Environment"}}`)
+		case "/rest" + tagAssociationPath + "/id:tag-1":
+			assert.Equal(t, "list-attached-objects", r.URL.Query().Get("~action"))
+			fmt.Fprint(w, `{"value":[{"id":"vm-1","type":"VirtualMachine"},{"id":"host-1","type":"HostSystem"}]}`)
+		case "/rest" + tagAssociationPath + "/id:tag-2":
+			fmt.Fprint(w, `{"value":[{"id":"vm-1","type":"VirtualMachine"}]}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+
+	return httptest.NewServer(mux), &logins
+}
+
+func TestTagClientFetch(t *testing.T) {
+	server, logins := newTagServer(t)
+	defer server.Close()
+
+	u, _ := url.Parse(server.URL + "/rest")
+	client := newTagClient(u, staticCredentials("user", "pass"), true)
+
+	tags, err := client.Fetch()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, *logins)
+
+	cache := &tagCache{}
+	cache.update(tags)
+
+	vm := types.ManagedObjectReference{Type: "VirtualMachine", Value: "vm-1"}
+	assert.Equal(t, []tagInfo{
+		{Category: "Environment", Name: "prod"},
+		{Category: "Environment", Name: "db"},
+	}, cache.Tags(vm))
+	assert.Equal(t, map[string]string{"Environment": "db,prod"}, tagValues(cache.Tags(vm)))
+
+	host := types.ManagedObjectReference{Type: "HostSystem", Value: "host-1"}
+	assert.Equal(t, []tagInfo{{Category: "Environment", Name: "prod"}}, cache.Tags(host))
+
+	datastore := types.ManagedObjectReference{Type: "Datastore", Value: "datastore-1"}
+	assert.Empty(t, cache.Tags(datastore))
+
+	var nilCache *tagCache
+	assert.Empty(t, nilCache.Tags(vm))
+}
+
+func TestTagClientUnauthorized(t *testing.T) {
+	server, _ := newTagServer(t)
+	defer server.Close()
+
+	u, _ := url.Parse(server.URL + "/rest")
+	client := newTagClient(u, staticCredentials("user", "wrong"), true)
+
+	_, err := client.Fetch()
+	assert.Equal(t, ErrTagSessionUnauthorized, err)
+}
+
+func TestRefreshTagsCredentials(t *testing.T) {
+	server, logins := newTagServer(t)
+	defer server.Close()
+
+	c := NewClient(&config.Config{
+		VSphereAuth: string(config.VSphereAuthPassword),
+		VSphereUser: "user",
+		VSpherePass: "pass",
+	})
+	u, _ := url.Parse(server.URL + "/rest")
+	c.tagClient = newTagClient(u, c.tagCredentials, true)
+	c.tags = &tagCache{}
+
+	assert.NoError(t, c.refreshTags())
+	assert.Equal(t, 2, *logins)
+	assert.True(t, c.tags.Loaded())
+
+	// Certificate and token auth have no password for the vAPI endpoint, so
+	// the refresh must not log in with an empty one
+	for _, cfg := range []*config.Config{
+		{VSphereAuth: string(config.VSphereAuthExtension), VSphereUser: "user"},
+		{VSphereAuth: string(config.VSphereAuthToken), VSphereUser: "user", VSpherePass: "pass"},
+		{VSphereAuth: string(config.VSphereAuthPassword), VSphereUser: "user"},
+	} {
+		c.tagClient.session = ""
+		c.configMutex.Lock()
+		c.config = cfg
+		c.configMutex.Unlock()
+
+		assert.NoError(t, c.refreshTags(), cfg.VSphereAuth)
+		assert.Equal(t, 2, *logins, cfg.VSphereAuth)
+	}
+}
+
+func TestRefreshTagsLoopStops(t *testing.T) {
+	c := NewClient(&config.Config{
+		VSphereAuth:        string(config.VSphereAuthToken),
+		TagRefreshInterval: time.Hour,
+	})
+	u, _ := url.Parse("https://vcenter/rest")
+	c.tagClient = newTagClient(u, c.tagCredentials, true)
+	c.tags = &tagCache{}
+
+	done := make(chan struct{})
+	c.loops.Add(1)
+	go func() {
+		c.refreshTagsLoop()
+		close(done)
+	}()
+
+	c.Close()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("refreshTagsLoop did not stop")
+	}
+}
+
+func TestParseTagCategories(t *testing.T) {
+	categories, err := parseTagCategories("Environment, Cost Center")
+	assert.NoError(t, err)
+	assert.Equal(t, []customAttribute{
+		{Name: "Environment", Label: "tag_environment"},
+		{Name: "Cost Center", Label: "tag_cost_center"},
+	}, categories)
+
+	_, err = parseTagCategories("Owner,owner")
+	assert.Error(t, err)
+
+	_, err = parseTagCategories("---")
+	assert.Error(t, err)
+}
+
+func TestTagURL(t *testing.T) {
+	assert.Equal(t, "https://vcenter/rest", tagURL("vcenter", 0).String())
+	assert.Equal(t, "https://vcenter:8443/rest", tagURL("vcenter", 8443).String())
+}
@@ -0,0 +1,204 @@
+/*
+Copyright (c) 2018 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/vmware/govmomi/vim25/soap"
+
+	config "github.com/dvonthenen/vsphere-metrics-prometheus/config"
+)
+
+var (
+	//ErrThumbprintMismatch - The vCenter certificate does not match a pinned thumbprint
+	ErrThumbprintMismatch = errors.New("The vCenter certificate does not match any of the pinned thumbprints")
+
+	//ErrNoCertificates - The CA file holds no PEM certificates
+	ErrNoCertificates = errors.New("The CA file holds no PEM certificates")
+)
+
+//configureTLS sets up how the SOAP client verifies the vCenter certificate.
+//The chain is verified by the VerifyPeerCertificate callback instead of the
+//TLS stack, so the error can show the presented chain, the thumbprints can be
+//pinned and the same checks apply to the sdkTunnel of extension auth.
+func configureTLS(soapClient *soap.Client, cfg *config.Config) error {
+	transport, ok := soapClient.Client.Transport.(*http.Transport)
+	if !ok {
+		return fmt.Errorf("unexpected SOAP transport %T", soapClient.Client.Transport)
+	}
+
+	err := configureTransport(transport, cfg)
+	if err != nil {
+		return err
+	}
+	// The thumbprint fallback of govmomi only kicks in on verification
+	// errors, which the callback takes care of now
+	transport.DialTLS = nil
+
+	watchCertificate(transport, soapClient.URL().Hostname())
+
+	return nil
+}
+
+//watchCertificate publishes the expiry of the certificate vCenter presents on
+//every handshake of the session. It runs ahead of the verification, so an
+//expired certificate still shows up.
+func watchCertificate(transport *http.Transport, hostname string) {
+	if transport.TLSClientConfig == nil {
+		transport.TLSClientConfig = &tls.Config{}
+	}
+
+	verify := transport.TLSClientConfig.VerifyPeerCertificate
+	transport.TLSClientConfig.VerifyPeerCertificate = func(rawCerts [][]byte, chains [][]*x509.Certificate) error {
+		if len(rawCerts) > 0 {
+			cert, err := x509.ParseCertificate(rawCerts[0])
+			if err == nil {
+				setVCenterCertificate(hostname, cert)
+			}
+		}
+		if verify == nil {
+			return nil
+		}
+		return verify(rawCerts, chains)
+	}
+}
+
+//configureTransport applies the vCenter certificate checks to a transport
+//that talks to vCenter
+func configureTransport(transport *http.Transport, cfg *config.Config) error {
+	if cfg.VSphereInsecure {
+		return nil
+	}
+
+	var roots *x509.CertPool
+	if cfg.VSphereCAFile != "" {
+		pool, err := loadCAFile(cfg.VSphereCAFile)
+		if err != nil {
+			return err
+		}
+		roots = pool
+	}
+
+	pins, err := config.ParseThumbprints(cfg.VSphereThumbprint)
+	if err != nil {
+		return err
+	}
+
+	if transport.TLSClientConfig == nil {
+		transport.TLSClientConfig = &tls.Config{}
+	}
+	transport.TLSClientConfig.InsecureSkipVerify = true
+	transport.TLSClientConfig.VerifyPeerCertificate = newCertificateVerifier(cfg.VSphereHostname, roots, pins)
+
+	return nil
+}
+
+//loadCAFile reads a PEM bundle
+func loadCAFile(file string) (*x509.CertPool, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("%v: %s", ErrNoCertificates, file)
+	}
+
+	return pool, nil
+}
+
+//newCertificateVerifier checks the chain vCenter presents. With pinned
+//thumbprints the leaf certificate has to match one of them, otherwise the
+//chain has to verify against the roots (the system roots if nil) for the
+//hostname.
+func newCertificateVerifier(hostname string, roots *x509.CertPool, pins []string) func([][]byte, [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		var certs []*x509.Certificate
+		for _, raw := range rawCerts {
+			cert, err := x509.ParseCertificate(raw)
+			if err != nil {
+				return fmt.Errorf("unable to parse the vCenter certificate: %v", err)
+			}
+			certs = append(certs, cert)
+		}
+		if len(certs) == 0 {
+			return errors.New("vCenter presented no certificate")
+		}
+
+		if len(pins) > 0 {
+			sha1, sha256 := thumbprintSHA1(certs[0]), thumbprintSHA256(certs[0])
+			for _, pin := range pins {
+				if pin == sha1 || pin == sha256 {
+					return nil
+				}
+			}
+			return fmt.Errorf("%v\npresented chain:\n%s", ErrThumbprintMismatch, describeChain(certs))
+		}
+
+		intermediates := x509.NewCertPool()
+		for _, cert := range certs[1:] {
+			intermediates.AddCert(cert)
+		}
+
+		_, err := certs[0].Verify(x509.VerifyOptions{
+			DNSName:       hostname,
+			Roots:         roots,
+			Intermediates: intermediates,
+		})
+		if err != nil {
+			return fmt.Errorf("unable to verify the vCenter certificate: %v\npresented chain:\n%s", err, describeChain(certs))
+		}
+
+		return nil
+	}
+}
+
+//describeChain lists the certificates of a chain with their thumbprints, so
+//a failed verification shows what to pin or which CA is missing
+func describeChain(certs []*x509.Certificate) string {
+	var lines []string
+	for i, cert := range certs {
+		lines = append(lines, fmt.Sprintf("  %d: subject=%q issuer=%q notAfter=%s\n     sha1=%s\n     sha256=%s",
+			i, cert.Subject.String(), cert.Issuer.String(), cert.NotAfter.UTC().Format("2006-01-02T15:04:05Z"),
+			thumbprintSHA1(cert), thumbprintSHA256(cert)))
+	}
+	return strings.Join(lines, "\n")
+}
+
+//thumbprintSHA1 returns the thumbprint in the format vSphere shows
+func thumbprintSHA1(cert *x509.Certificate) string {
+	return soap.ThumbprintSHA1(cert)
+}
+
+//thumbprintSHA256 returns the thumbprint in the format vSphere shows
+func thumbprintSHA256(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	hex := make([]string, len(sum))
+	for i, b := range sum {
+		hex[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(hex, ":")
+}
@@ -0,0 +1,129 @@
+/*
+Copyright (c) 2018 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package vsphere
+
+import (
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+
+	assert "github.com/stretchr/testify/assert"
+
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/vim25/soap"
+
+	config "github.com/dvonthenen/vsphere-metrics-prometheus/config"
+)
+
+func newTLSTestClient(t *testing.T, server *httptest.Server, cfg *config.Config) *soap.Client {
+	u, err := url.Parse(server.URL)
+	assert.NoError(t, err)
+
+	cfg.VSphereHostname = u.Hostname()
+	soapClient := soap.NewClient(u, cfg.VSphereInsecure)
+	assert.NoError(t, configureTLS(soapClient, cfg))
+	return soapClient
+}
+
+func tlsTestGet(soapClient *soap.Client, server *httptest.Server) error {
+	res, err := soapClient.Client.Get(server.URL)
+	if err != nil {
+		return err
+	}
+	return res.Body.Close()
+}
+
+func TestConfigureTLSThumbprint(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	cert := server.Certificate()
+
+	for _, pin := range []string{thumbprintSHA1(cert), thumbprintSHA256(cert)} {
+		cfg := config.NewConfig()
+		cfg.VSphereThumbprint = pin
+		assert.NoError(t, tlsTestGet(newTLSTestClient(t, server, cfg), server))
+	}
+
+	cfg := config.NewConfig()
+	cfg.VSphereThumbprint = "00:11:22:33:44:55:66:77:88:99:AA:BB:CC:DD:EE:FF:00:11:22:33"
+	err := tlsTestGet(newTLSTestClient(t, server, cfg), server)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), ErrThumbprintMismatch.Error())
+	assert.Contains(t, err.Error(), thumbprintSHA256(cert))
+}
+
+func TestConfigureTLSCAFile(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	// Without the CA the chain does not verify against the system roots
+	cfg := config.NewConfig()
+	err := tlsTestGet(newTLSTestClient(t, server, cfg), server)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "presented chain")
+	assert.Contains(t, err.Error(), thumbprintSHA1(server.Certificate()))
+
+	file, err := ioutil.TempFile("", "ca")
+	assert.NoError(t, err)
+	defer os.Remove(file.Name())
+	assert.NoError(t, pem.Encode(file, &pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}))
+	assert.NoError(t, file.Close())
+
+	cfg = config.NewConfig()
+	cfg.VSphereCAFile = file.Name()
+	assert.NoError(t, tlsTestGet(newTLSTestClient(t, server, cfg), server))
+}
+
+func TestConfigureTLSCertificateMetric(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	cert := server.Certificate()
+	info := (&object.HostCertificateInfo{}).FromCertificate(cert)
+
+	// Also published when the certificate does not verify
+	for _, insecure := range []bool{true, false} {
+		metricVCenterCertificateNotAfter.Reset()
+
+		cfg := config.NewConfig()
+		cfg.VSphereInsecure = insecure
+		soapClient := newTLSTestClient(t, server, cfg)
+		err := tlsTestGet(soapClient, server)
+		assert.Equal(t, insecure, err == nil)
+
+		assert.Equal(t, 1, seriesCount(metricVCenterCertificateNotAfter))
+		assert.Equal(t, float64(cert.NotAfter.Unix()), vecValue(t, metricVCenterCertificateNotAfter, cfg.VSphereHostname, info.Issuer, info.Subject))
+	}
+}
+
+func TestLoadCAFile(t *testing.T) {
+	file, err := ioutil.TempFile("", "ca")
+	assert.NoError(t, err)
+	defer os.Remove(file.Name())
+	assert.NoError(t, file.Close())
+
+	_, err = loadCAFile(file.Name())
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), ErrNoCertificates.Error())
+
+	_, err = loadCAFile(file.Name() + ".missing")
+	assert.Error(t, err)
+}
@@ -0,0 +1,77 @@
+/*
+Copyright (c) 2018 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package vsphere
+
+import (
+	"context"
+	"time"
+
+	"github.com/vmware/govmomi"
+	"github.com/vmware/govmomi/vim25"
+	"github.com/vmware/govmomi/vim25/methods"
+	"github.com/vmware/govmomi/vim25/soap"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+//fakeVCenter answers the SOAP calls of a session without a vCenter. It
+//returns the properties of the objects and the response of the other calls.
+type fakeVCenter struct {
+	properties func(ctx context.Context, obj types.ManagedObjectReference) []types.DynamicProperty
+	call       func(ctx context.Context, req soap.HasFault, res soap.HasFault) error
+}
+
+//RoundTrip implements soap.RoundTripper
+func (f *fakeVCenter) RoundTrip(ctx context.Context, req, res soap.HasFault) error {
+	if body, ok := req.(*methods.RetrievePropertiesBody); ok {
+		var contents []types.ObjectContent
+		for _, spec := range body.Req.SpecSet {
+			for _, object := range spec.ObjectSet {
+				content := types.ObjectContent{Obj: object.Obj}
+				if f.properties != nil {
+					content.PropSet = f.properties(ctx, object.Obj)
+				}
+				contents = append(contents, content)
+			}
+		}
+		res.(*methods.RetrievePropertiesBody).Res = &types.RetrievePropertiesResponse{Returnval: contents}
+		return nil
+	}
+
+	return f.call(ctx, req, res)
+}
+
+//newFakeSession returns a logged in session to the fake vCenter
+func newFakeSession(vcenter *fakeVCenter) *session {
+	ref := func(kind string) *types.ManagedObjectReference {
+		return &types.ManagedObjectReference{Type: kind, Value: kind}
+	}
+
+	client := &vim25.Client{
+		RoundTripper: vcenter,
+		ServiceContent: types.ServiceContent{
+			PropertyCollector: *ref("PropertyCollector"),
+			SessionManager:    ref("SessionManager"),
+			PerfManager:       ref("PerformanceManager"),
+			LicenseManager:    ref("LicenseManager"),
+		},
+	}
+
+	return &session{
+		ctx:     context.Background(),
+		client:  &govmomi.Client{Client: client},
+		created: time.Now(),
+	}
+}
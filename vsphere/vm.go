@@ -53,8 +53,27 @@ const (
 )
 
 var (
-	metricsMapVM = make(map[int]*prometheus.GaugeVec)
-	//metricsMapVM = make(map[int]*prometheus.Desc)
+	//vmQuickStatsUnits holds the unit vSphere reports each quick stat in
+	vmQuickStatsUnits = map[int]string{
+		vmBalloonedMemory:              unitMegaBytes,
+		vmCompressedMemory:             unitKiloBytes,
+		vmConsumedOverheadMemory:       unitMegaBytes,
+		vmDistributedCpuEntitlement:    unitMegaHertz,
+		vmDistributedMemoryEntitlement: unitMegaBytes,
+		vmFtLogBandwidth:               unitKiloBytesPerSecond,
+		vmFtSecondaryLatency:           unitMillisecond,
+		vmGuestMemoryUsage:             unitMegaBytes,
+		vmHostMemoryUsage:              unitMegaBytes,
+		vmOverallCpuDemand:             unitMegaHertz,
+		vmOverallCpuUsage:              unitMegaHertz,
+		vmPrivateMemory:                unitMegaBytes,
+		vmSharedMemory:                 unitMegaBytes,
+		vmSsdSwappedMemory:             unitKiloBytes,
+		vmStaticCpuEntitlement:         unitMegaHertz,
+		vmStaticMemoryEntitlement:      unitMegaBytes,
+		vmSwappedMemory:                unitMegaBytes,
+		vmUptimeSeconds:                unitSecond,
+	}
 )
 
 func (c *Client) registerVMMetrics() error {
@@ -64,76 +83,51 @@ func (c *Client) registerVMMetrics() error {
 	log.Debugln("Key:", metricName)
 
 	myMetric := prometheus.NewGaugeVec(
-		prometheus.GaugeOpts{
-			Namespace: "vsphere",
-			Subsystem: "vm",
-			Name:      metricName,
-			Help:      metricName,
-		},
+		c.gaugeOpts("vm", metricName, "ballooned_memory_bytes", "Memory reclaimed from the VM by the balloon driver"),
 		[]string{"datacenter"},
 	)
-	metricsMapVM[int(vmBalloonedMemory)] = myMetric
-	prometheus.MustRegister(myMetric)
+	c.metricsMapVM[int(vmBalloonedMemory)] = myMetric
+	c.registry.MustRegister(myMetric)
 
 	metricName = fmt.Sprintf("%d_compressed_memory", vmCompressedMemory)
 	log.Debugln("Key:", metricName)
 
 	myMetric = prometheus.NewGaugeVec(
-		prometheus.GaugeOpts{
-			Namespace: "vsphere",
-			Subsystem: "vm",
-			Name:      metricName,
-			Help:      metricName,
-		},
+		c.gaugeOpts("vm", metricName, "compressed_memory_bytes", "Memory of the VM held in the compression cache"),
 		[]string{"datacenter"},
 	)
-	metricsMapVM[int(vmCompressedMemory)] = myMetric
-	prometheus.MustRegister(myMetric)
+	c.metricsMapVM[int(vmCompressedMemory)] = myMetric
+	c.registry.MustRegister(myMetric)
 
 	metricName = fmt.Sprintf("%d_consumed_overhead_memory", vmConsumedOverheadMemory)
 	log.Debugln("Key:", metricName)
 
 	myMetric = prometheus.NewGaugeVec(
-		prometheus.GaugeOpts{
-			Namespace: "vsphere",
-			Subsystem: "vm",
-			Name:      metricName,
-			Help:      metricName,
-		},
+		c.gaugeOpts("vm", metricName, "consumed_overhead_memory_bytes", "Host memory consumed by the virtualization overhead of the VM"),
 		[]string{"datacenter"},
 	)
-	metricsMapVM[int(vmConsumedOverheadMemory)] = myMetric
-	prometheus.MustRegister(myMetric)
+	c.metricsMapVM[int(vmConsumedOverheadMemory)] = myMetric
+	c.registry.MustRegister(myMetric)
 
 	metricName = fmt.Sprintf("%d_distributed_cpu_entitlement", vmDistributedCpuEntitlement)
 	log.Debugln("Key:", metricName)
 
 	myMetric = prometheus.NewGaugeVec(
-		prometheus.GaugeOpts{
-			Namespace: "vsphere",
-			Subsystem: "vm",
-			Name:      metricName,
-			Help:      metricName,
-		},
+		c.gaugeOpts("vm", metricName, "distributed_cpu_entitlement_hertz", "CPU the VM is entitled to as computed by DRS"),
 		[]string{"datacenter"},
 	)
-	metricsMapVM[int(vmDistributedCpuEntitlement)] = myMetric
-	prometheus.MustRegister(myMetric)
+	c.metricsMapVM[int(vmDistributedCpuEntitlement)] = myMetric
+	c.registry.MustRegister(myMetric)
 
 	metricName = fmt.Sprintf("%d_distributed_memory_entitlement", vmDistributedMemoryEntitlement)
 	log.Debugln("Key:", metricName)
 
 	myMetric = prometheus.NewGaugeVec(
-		prometheus.GaugeOpts{
-			Namespace: "vsphere",
-			Subsystem: "vm",
-			Name:      metricName,
-			Help:      metricName,
-		},
+		c.gaugeOpts("vm", metricName, "distributed_memory_entitlement_bytes", "Memory the VM is entitled to as computed by DRS"),
 		[]string{"datacenter"},
 	)
-	metricsMapVM[int(vmDistributedMemoryEntitlement)] = myMetric
-	prometheus.MustRegister(myMetric)
+	c.metricsMapVM[int(vmDistributedMemoryEntitlement)] = myMetric
+	c.registry.MustRegister(myMetric)
 
 	// metricName = fmt.Sprintf("%d_ft_latency_status", vmFtLatencyStatus)
 	// log.Debugln("Key:", metricName)
@@ -154,31 +148,21 @@ func (c *Client) registerVMMetrics() error {
 	log.Debugln("Key:", metricName)
 
 	myMetric = prometheus.NewGaugeVec(
-		prometheus.GaugeOpts{
-			Namespace: "vsphere",
-			Subsystem: "vm",
-			Name:      metricName,
-			Help:      metricName,
-		},
+		c.gaugeOpts("vm", metricName, "ft_log_bandwidth_bytes_per_second", "Fault tolerance logging bandwidth of the VM"),
 		[]string{"datacenter"},
 	)
-	metricsMapVM[int(vmFtLogBandwidth)] = myMetric
-	prometheus.MustRegister(myMetric)
+	c.metricsMapVM[int(vmFtLogBandwidth)] = myMetric
+	c.registry.MustRegister(myMetric)
 
 	metricName = fmt.Sprintf("%d_ft_secondary_latency", vmFtSecondaryLatency)
 	log.Debugln("Key:", metricName)
 
 	myMetric = prometheus.NewGaugeVec(
-		prometheus.GaugeOpts{
-			Namespace: "vsphere",
-			Subsystem: "vm",
-			Name:      metricName,
-			Help:      metricName,
-		},
+		c.gaugeOpts("vm", metricName, "ft_secondary_latency_seconds", "Fault tolerance latency between the primary and secondary VM"),
 		[]string{"datacenter"},
 	)
-	metricsMapVM[int(vmFtSecondaryLatency)] = myMetric
-	prometheus.MustRegister(myMetric)
+	c.metricsMapVM[int(vmFtSecondaryLatency)] = myMetric
+	c.registry.MustRegister(myMetric)
 
 	// metricName = fmt.Sprintf("%d_guest_heartbeat_status", vmGuestHeartbeatStatus)
 	// log.Debugln("Key:", metricName)
@@ -199,166 +183,126 @@ func (c *Client) registerVMMetrics() error {
 	log.Debugln("Key:", metricName)
 
 	myMetric = prometheus.NewGaugeVec(
-		prometheus.GaugeOpts{
-			Namespace: "vsphere",
-			Subsystem: "vm",
-			Name:      metricName,
-			Help:      metricName,
-		},
+		c.gaugeOpts("vm", metricName, "guest_memory_usage_bytes", "Guest memory actively used by the VM"),
 		[]string{"datacenter"},
 	)
-	metricsMapVM[int(vmGuestMemoryUsage)] = myMetric
-	prometheus.MustRegister(myMetric)
+	c.metricsMapVM[int(vmGuestMemoryUsage)] = myMetric
+	c.registry.MustRegister(myMetric)
 
 	metricName = fmt.Sprintf("%d_host_memory_usage", vmHostMemoryUsage)
 	log.Debugln("Key:", metricName)
 
 	myMetric = prometheus.NewGaugeVec(
-		prometheus.GaugeOpts{
-			Namespace: "vsphere",
-			Subsystem: "vm",
-			Name:      metricName,
-			Help:      metricName,
-		},
+		c.gaugeOpts("vm", metricName, "host_memory_usage_bytes", "Host memory consumed by the VM"),
 		[]string{"datacenter"},
 	)
-	metricsMapVM[int(vmHostMemoryUsage)] = myMetric
-	prometheus.MustRegister(myMetric)
+	c.metricsMapVM[int(vmHostMemoryUsage)] = myMetric
+	c.registry.MustRegister(myMetric)
 
 	metricName = fmt.Sprintf("%d_overall_cpu_demand", vmOverallCpuDemand)
 	log.Debugln("Key:", metricName)
 
 	myMetric = prometheus.NewGaugeVec(
-		prometheus.GaugeOpts{
-			Namespace: "vsphere",
-			Subsystem: "vm",
-			Name:      metricName,
-			Help:      metricName,
-		},
+		c.gaugeOpts("vm", metricName, "overall_cpu_demand_hertz", "CPU demand of the VM"),
 		[]string{"datacenter"},
 	)
-	metricsMapVM[int(vmOverallCpuDemand)] = myMetric
-	prometheus.MustRegister(myMetric)
+	c.metricsMapVM[int(vmOverallCpuDemand)] = myMetric
+	c.registry.MustRegister(myMetric)
 
 	metricName = fmt.Sprintf("%d_overall_cpu_usage", vmOverallCpuUsage)
 	log.Debugln("Key:", metricName)
 
 	myMetric = prometheus.NewGaugeVec(
-		prometheus.GaugeOpts{
-			Namespace: "vsphere",
-			Subsystem: "vm",
-			Name:      metricName,
-			Help:      metricName,
-		},
+		c.gaugeOpts("vm", metricName, "overall_cpu_usage_hertz", "CPU used by the VM"),
 		[]string{"datacenter"},
 	)
-	metricsMapVM[int(vmOverallCpuUsage)] = myMetric
-	prometheus.MustRegister(myMetric)
+	c.metricsMapVM[int(vmOverallCpuUsage)] = myMetric
+	c.registry.MustRegister(myMetric)
 
 	metricName = fmt.Sprintf("%d_private_memory", vmPrivateMemory)
 	log.Debugln("Key:", metricName)
 
 	myMetric = prometheus.NewGaugeVec(
-		prometheus.GaugeOpts{
-			Namespace: "vsphere",
-			Subsystem: "vm",
-			Name:      metricName,
-			Help:      metricName,
-		},
+		c.gaugeOpts("vm", metricName, "private_memory_bytes", "Memory backed by host memory and not shared"),
 		[]string{"datacenter"},
 	)
-	metricsMapVM[int(vmPrivateMemory)] = myMetric
-	prometheus.MustRegister(myMetric)
+	c.metricsMapVM[int(vmPrivateMemory)] = myMetric
+	c.registry.MustRegister(myMetric)
 
 	metricName = fmt.Sprintf("%d_shared_memory", vmSharedMemory)
 	log.Debugln("Key:", metricName)
 
 	myMetric = prometheus.NewGaugeVec(
-		prometheus.GaugeOpts{
-			Namespace: "vsphere",
-			Subsystem: "vm",
-			Name:      metricName,
-			Help:      metricName,
-		},
+		c.gaugeOpts("vm", metricName, "shared_memory_bytes", "Guest memory shared with other VMs"),
 		[]string{"datacenter"},
 	)
-	metricsMapVM[int(vmSharedMemory)] = myMetric
-	prometheus.MustRegister(myMetric)
+	c.metricsMapVM[int(vmSharedMemory)] = myMetric
+	c.registry.MustRegister(myMetric)
 
 	metricName = fmt.Sprintf("%d_ssd_swapped_memory", vmSsdSwappedMemory)
 	log.Debugln("Key:", metricName)
 
 	myMetric = prometheus.NewGaugeVec(
-		prometheus.GaugeOpts{
-			Namespace: "vsphere",
-			Subsystem: "vm",
-			Name:      metricName,
-			Help:      metricName,
-		},
+		c.gaugeOpts("vm", metricName, "ssd_swapped_memory_bytes", "Memory of the VM swapped to the host cache"),
 		[]string{"datacenter"},
 	)
-	metricsMapVM[int(vmSsdSwappedMemory)] = myMetric
-	prometheus.MustRegister(myMetric)
+	c.metricsMapVM[int(vmSsdSwappedMemory)] = myMetric
+	c.registry.MustRegister(myMetric)
 
 	metricName = fmt.Sprintf("%d_static_cpu_entitlement", vmStaticCpuEntitlement)
 	log.Debugln("Key:", metricName)
 
 	myMetric = prometheus.NewGaugeVec(
-		prometheus.GaugeOpts{
-			Namespace: "vsphere",
-			Subsystem: "vm",
-			Name:      metricName,
-			Help:      metricName,
-		},
+		c.gaugeOpts("vm", metricName, "static_cpu_entitlement_hertz", "CPU the VM would receive if all VMs consumed their full entitlement"),
 		[]string{"datacenter"},
 	)
-	metricsMapVM[int(vmStaticCpuEntitlement)] = myMetric
-	prometheus.MustRegister(myMetric)
+	c.metricsMapVM[int(vmStaticCpuEntitlement)] = myMetric
+	c.registry.MustRegister(myMetric)
 
 	metricName = fmt.Sprintf("%d_static_memory_entitlement", vmStaticMemoryEntitlement)
 	log.Debugln("Key:", metricName)
 
 	myMetric = prometheus.NewGaugeVec(
-		prometheus.GaugeOpts{
-			Namespace: "vsphere",
-			Subsystem: "vm",
-			Name:      metricName,
-			Help:      metricName,
-		},
+		c.gaugeOpts("vm", metricName, "static_memory_entitlement_bytes", "Memory the VM would receive if all VMs consumed their full entitlement"),
 		[]string{"datacenter"},
 	)
-	metricsMapVM[int(vmStaticMemoryEntitlement)] = myMetric
-	prometheus.MustRegister(myMetric)
+	c.metricsMapVM[int(vmStaticMemoryEntitlement)] = myMetric
+	c.registry.MustRegister(myMetric)
 
 	metricName = fmt.Sprintf("%d_swapped_memory", vmSwappedMemory)
 	log.Debugln("Key:", metricName)
 
 	myMetric = prometheus.NewGaugeVec(
-		prometheus.GaugeOpts{
-			Namespace: "vsphere",
-			Subsystem: "vm",
-			Name:      metricName,
-			Help:      metricName,
-		},
+		c.gaugeOpts("vm", metricName, "swapped_memory_bytes", "Memory of the VM swapped to disk"),
 		[]string{"datacenter"},
 	)
-	metricsMapVM[int(vmSwappedMemory)] = myMetric
-	prometheus.MustRegister(myMetric)
+	c.metricsMapVM[int(vmSwappedMemory)] = myMetric
+	c.registry.MustRegister(myMetric)
 
 	metricName = fmt.Sprintf("%d_uptime_seconds", vmUptimeSeconds)
 	log.Debugln("Key:", metricName)
 
 	myMetric = prometheus.NewGaugeVec(
-		prometheus.GaugeOpts{
-			Namespace: "vsphere",
-			Subsystem: "vm",
-			Name:      metricName,
-			Help:      metricName,
-		},
+		c.gaugeOpts("vm", metricName, "uptime_seconds", "Time the VM has been powered on"),
 		[]string{"datacenter"},
 	)
-	metricsMapVM[int(vmUptimeSeconds)] = myMetric
-	prometheus.MustRegister(myMetric)
+	c.metricsMapVM[int(vmUptimeSeconds)] = myMetric
+	c.registry.MustRegister(myMetric)
+
+	myMetric, err := c.registerInfoMetric("vm", vmInfo)
+	if err != nil {
+		log.Debugln("registerInfoMetric Failed:", err)
+		log.Debugln("registerVMMetrics LEAVE")
+		return err
+	}
+	c.metricsMapVM[vmInfo] = myMetric
+
+	err = c.registerVMStorageMetrics()
+	if err != nil {
+		log.Debugln("registerVMStorageMetrics Failed:", err)
+		log.Debugln("registerVMMetrics LEAVE")
+		return err
+	}
 
 	/*
 		labels := []string{"datacenter", "vm"}
@@ -366,27 +310,27 @@ func (c *Client) registerVMMetrics() error {
 		metricName := fmt.Sprintf("%d_ballooned_memory", vmBalloonedMemory)
 		log.Debugln("Key:", metricName)
 		myMetric := prometheus.NewDesc(metricName, "ballooned memory", labels, nil)
-		metricsMapVM[int(vmBalloonedMemory)] = myMetric
+		c.metricsMapVM[int(vmBalloonedMemory)] = myMetric
 
 		metricName = fmt.Sprintf("%d_compressed_memory", vmCompressedMemory)
 		log.Debugln("Key:", metricName)
 		myMetric = prometheus.NewDesc(metricName, "compressed memory", labels, nil)
-		metricsMapVM[int(vmCompressedMemory)] = myMetric
+		c.metricsMapVM[int(vmCompressedMemory)] = myMetric
 
 		metricName = fmt.Sprintf("%d_consumed_overhead_memory", vmConsumedOverheadMemory)
 		log.Debugln("Key:", metricName)
 		myMetric = prometheus.NewDesc(metricName, "consumed overhead memory", labels, nil)
-		metricsMapVM[int(vmConsumedOverheadMemory)] = myMetric
+		c.metricsMapVM[int(vmConsumedOverheadMemory)] = myMetric
 
 		metricName = fmt.Sprintf("%d_distributed_cpu_entitlement", vmDistributedCpuEntitlement)
 		log.Debugln("Key:", metricName)
 		myMetric = prometheus.NewDesc(metricName, "distributed cpu entitlement", labels, nil)
-		metricsMapVM[int(vmDistributedCpuEntitlement)] = myMetric
+		c.metricsMapVM[int(vmDistributedCpuEntitlement)] = myMetric
 
 		metricName = fmt.Sprintf("%d_distributed_memory_entitlement", vmDistributedMemoryEntitlement)
 		log.Debugln("Key:", metricName)
 		myMetric = prometheus.NewDesc(metricName, "distributed memory entitlement", labels, nil)
-		metricsMapVM[int(vmDistributedMemoryEntitlement)] = myMetric
+		c.metricsMapVM[int(vmDistributedMemoryEntitlement)] = myMetric
 
 		// metricName = fmt.Sprintf("%d_ft_latency_status", vmFtLatencyStatus)
 		// log.Debugln("Key:", metricName)
@@ -396,12 +340,12 @@ func (c *Client) registerVMMetrics() error {
 		metricName = fmt.Sprintf("%d_ft_log_bandwidth", vmFtLogBandwidth)
 		log.Debugln("Key:", metricName)
 		myMetric = prometheus.NewDesc(metricName, "ft log bandwidth", labels, nil)
-		metricsMapVM[int(vmFtLogBandwidth)] = myMetric
+		c.metricsMapVM[int(vmFtLogBandwidth)] = myMetric
 
 		metricName = fmt.Sprintf("%d_ft_secondary_latency", vmFtSecondaryLatency)
 		log.Debugln("Key:", metricName)
 		myMetric = prometheus.NewDesc(metricName, "ft secondary latency", labels, nil)
-		metricsMapVM[int(vmFtSecondaryLatency)] = myMetric
+		c.metricsMapVM[int(vmFtSecondaryLatency)] = myMetric
 
 		// metricName = fmt.Sprintf("%d_guest_heartbeat_status", vmGuestHeartbeatStatus)
 		// log.Debugln("Key:", metricName)
@@ -411,57 +355,57 @@ func (c *Client) registerVMMetrics() error {
 		metricName = fmt.Sprintf("%d_guest_memoruy_usage", vmGuestMemoryUsage)
 		log.Debugln("Key:", metricName)
 		myMetric = prometheus.NewDesc(metricName, "guest memoruy usage", labels, nil)
-		metricsMapVM[int(vmGuestMemoryUsage)] = myMetric
+		c.metricsMapVM[int(vmGuestMemoryUsage)] = myMetric
 
 		metricName = fmt.Sprintf("%d_host_memory_usage", vmHostMemoryUsage)
 		log.Debugln("Key:", metricName)
 		myMetric = prometheus.NewDesc(metricName, "host memory usage", labels, nil)
-		metricsMapVM[int(vmHostMemoryUsage)] = myMetric
+		c.metricsMapVM[int(vmHostMemoryUsage)] = myMetric
 
 		metricName = fmt.Sprintf("%d_overall_cpu_demand", vmOverallCpuDemand)
 		log.Debugln("Key:", metricName)
 		myMetric = prometheus.NewDesc(metricName, "overall cpu demand", labels, nil)
-		metricsMapVM[int(vmOverallCpuDemand)] = myMetric
+		c.metricsMapVM[int(vmOverallCpuDemand)] = myMetric
 
 		metricName = fmt.Sprintf("%d_overall_cpu_usage", vmOverallCpuUsage)
 		log.Debugln("Key:", metricName)
 		myMetric = prometheus.NewDesc(metricName, "overall cpu usage", labels, nil)
-		metricsMapVM[int(vmOverallCpuUsage)] = myMetric
+		c.metricsMapVM[int(vmOverallCpuUsage)] = myMetric
 
 		metricName = fmt.Sprintf("%d_private_memory", vmPrivateMemory)
 		log.Debugln("Key:", metricName)
 		myMetric = prometheus.NewDesc(metricName, "private memory", labels, nil)
-		metricsMapVM[int(vmPrivateMemory)] = myMetric
+		c.metricsMapVM[int(vmPrivateMemory)] = myMetric
 
 		metricName = fmt.Sprintf("%d_shared_memory", vmSharedMemory)
 		log.Debugln("Key:", metricName)
 		myMetric = prometheus.NewDesc(metricName, "shared memory", labels, nil)
-		metricsMapVM[int(vmSharedMemory)] = myMetric
+		c.metricsMapVM[int(vmSharedMemory)] = myMetric
 
 		metricName = fmt.Sprintf("%d_ssd_swapped_memory", vmSsdSwappedMemory)
 		log.Debugln("Key:", metricName)
 		myMetric = prometheus.NewDesc(metricName, "ssd swapped memory", labels, nil)
-		metricsMapVM[int(vmSsdSwappedMemory)] = myMetric
+		c.metricsMapVM[int(vmSsdSwappedMemory)] = myMetric
 
 		metricName = fmt.Sprintf("%d_static_cpu_entitlement", vmStaticCpuEntitlement)
 		log.Debugln("Key:", metricName)
 		myMetric = prometheus.NewDesc(metricName, "static cpu entitlement", labels, nil)
-		metricsMapVM[int(vmStaticCpuEntitlement)] = myMetric
+		c.metricsMapVM[int(vmStaticCpuEntitlement)] = myMetric
 
 		metricName = fmt.Sprintf("%d_static_memory_entitlement", vmStaticMemoryEntitlement)
 		log.Debugln("Key:", metricName)
 		myMetric = prometheus.NewDesc(metricName, "static memory entitlement", labels, nil)
-		metricsMapVM[int(vmStaticMemoryEntitlement)] = myMetric
+		c.metricsMapVM[int(vmStaticMemoryEntitlement)] = myMetric
 
 		metricName = fmt.Sprintf("%d_swapped_memory", vmSwappedMemory)
 		log.Debugln("Key:", metricName)
 		myMetric = prometheus.NewDesc(metricName, "swapped memory", labels, nil)
-		metricsMapVM[int(vmSwappedMemory)] = myMetric
+		c.metricsMapVM[int(vmSwappedMemory)] = myMetric
 
 		metricName = fmt.Sprintf("%d_uptime_seconds", vmUptimeSeconds)
 		log.Debugln("Key:", metricName)
 		myMetric = prometheus.NewDesc(metricName, "uptime seconds", labels, nil)
-		metricsMapVM[int(vmUptimeSeconds)] = myMetric
+		c.metricsMapVM[int(vmUptimeSeconds)] = myMetric
 	*/
 
 	log.Debugln("registerVMMetrics Succeeded")
@@ -480,31 +424,34 @@ func (c *Client) GetVSphereVMStats(w http.ResponseWriter, r *http.Request) error
 	vmStr := vars["vm"]
 	log.Infoln("VM:", vmStr)
 
+	ctx, cancel := c.scrapeContext(r)
+	defer c.endScrape(ctx, cancel)
+
 	// Create client
-	err := c.getClient()
+	s, err := c.getSession(ctx)
 	if err != nil {
-		http.Error(w, "Unable connect to the vCenter Server", http.StatusGone)
-		log.Errorln("getClient failed:", err)
+		err = scrapeError(ctx, w, err, "Unable connect to the vCenter Server", http.StatusGone)
+		log.Errorln("getSession failed:", err)
 		log.Debugln("GetVSphereVMStats LEAVE")
 
 		return err
 	}
 
 	//find our objects
-	finder := find.NewFinder(c.vClient.Client, false)
+	finder := find.NewFinder(s.client.Client, false)
 
-	dc, err := finder.Datacenter(*c.ctx, datacenterStr)
+	dc, err := finder.Datacenter(s.ctx, datacenterStr)
 	if err != nil {
-		http.Error(w, "Unable find the Datacener", http.StatusGone)
+		err = scrapeError(ctx, w, err, "Unable find the Datacener", http.StatusGone)
 		log.Errorln("finder.Datacenter(", datacenterStr, "):", err)
 		log.Debugln("GetVSphereVMStats LEAVE")
 		return err
 	}
 	finder.SetDatacenter(dc)
 
-	vm, err := finder.VirtualMachine(*c.ctx, vmStr)
+	vm, err := finder.VirtualMachine(s.ctx, vmStr)
 	if err != nil {
-		http.Error(w, "Unable find the VirtualMachine", http.StatusGone)
+		err = scrapeError(ctx, w, err, "Unable find the VirtualMachine", http.StatusGone)
 		log.Errorln("finder.VirtualMachine(", vmStr, "):", err)
 		log.Debugln("GetVSphereVMStats LEAVE")
 		return err
@@ -514,9 +461,9 @@ func (c *Client) GetVSphereVMStats(w http.ResponseWriter, r *http.Request) error
 	log.Infoln("VM:", vm.InventoryPath)
 
 	var oVM mo.VirtualMachine
-	err = vm.Properties(*c.ctx, vm.Reference(), []string{"config", "summary"}, &oVM)
+	err = vm.Properties(s.ctx, vm.Reference(), []string{"config", "summary", "storage", "layoutEx", "customValue"}, &oVM)
 	if err != nil {
-		http.Error(w, "Unable get the VirtualMachine properties", http.StatusBadRequest)
+		err = scrapeError(ctx, w, err, "Unable get the VirtualMachine properties", http.StatusBadRequest)
 		log.Errorln("vm.Properties(", vmStr, "):", err)
 		log.Debugln("GetVSphereVMStats LEAVE")
 		return err
@@ -530,109 +477,139 @@ func (c *Client) GetVSphereVMStats(w http.ResponseWriter, r *http.Request) error
 	log.Infoln(string(oVM.Summary.OverallStatus))
 	log.Infoln(string(oVM.OverallStatus))
 
-	myMetric := metricsMapVM[vmBalloonedMemory]
+	entity := entityInfo{
+		Path:       vm.InventoryPath,
+		Name:       vm.Name(),
+		PowerState: string(oVM.Summary.Runtime.PowerState),
+	}
+	if c.entityFilter.Uses(entityRuleCluster) {
+		entity.Cluster, err = c.hostCluster(s, oVM.Summary.Runtime.Host)
+		if err != nil {
+			// Without the cluster an exclude rule on it would not match
+			err = scrapeError(ctx, w, err, "Unable find the cluster for the entity filters", http.StatusServiceUnavailable)
+			log.Errorln("hostCluster(", vmStr, "):", err)
+			log.Debugln("GetVSphereVMStats LEAVE")
+			return err
+		}
+	}
+
+	err = c.filterEntity(w, vm.Reference(), &entity)
+	if err != nil {
+		log.Debugln("GetVSphereVMStats LEAVE")
+		return err
+	}
+
+	c.setInfoMetric(s, c.metricsMapVM[vmInfo], datacenterStr, vm.Name(), vm.Reference(), oVM.CustomValue)
+	c.setTagMetrics(vm.Name(), vm.Reference())
+
+	myMetric := c.metricsMapVM[vmBalloonedMemory]
 	if myMetric != nil {
-		myMetric.WithLabelValues(datacenterStr).Set(float64(oVM.Summary.QuickStats.BalloonedMemory))
+		myMetric.WithLabelValues(datacenterStr).Set(c.normalize(float64(oVM.Summary.QuickStats.BalloonedMemory), vmQuickStatsUnits[vmBalloonedMemory]))
 	}
-	myMetric = metricsMapVM[vmCompressedMemory]
+	myMetric = c.metricsMapVM[vmCompressedMemory]
 	if myMetric != nil {
-		myMetric.WithLabelValues(datacenterStr).Set(float64(oVM.Summary.QuickStats.CompressedMemory))
+		myMetric.WithLabelValues(datacenterStr).Set(c.normalize(float64(oVM.Summary.QuickStats.CompressedMemory), vmQuickStatsUnits[vmCompressedMemory]))
 	}
-	myMetric = metricsMapVM[vmConsumedOverheadMemory]
+	myMetric = c.metricsMapVM[vmConsumedOverheadMemory]
 	if myMetric != nil {
-		myMetric.WithLabelValues(datacenterStr).Set(float64(oVM.Summary.QuickStats.ConsumedOverheadMemory))
+		myMetric.WithLabelValues(datacenterStr).Set(c.normalize(float64(oVM.Summary.QuickStats.ConsumedOverheadMemory), vmQuickStatsUnits[vmConsumedOverheadMemory]))
 	}
-	myMetric = metricsMapVM[vmDistributedCpuEntitlement]
+	myMetric = c.metricsMapVM[vmDistributedCpuEntitlement]
 	if myMetric != nil {
-		myMetric.WithLabelValues(datacenterStr).Set(float64(oVM.Summary.QuickStats.DistributedCpuEntitlement))
+		myMetric.WithLabelValues(datacenterStr).Set(c.normalize(float64(oVM.Summary.QuickStats.DistributedCpuEntitlement), vmQuickStatsUnits[vmDistributedCpuEntitlement]))
 	}
-	myMetric = metricsMapVM[vmDistributedMemoryEntitlement]
+	myMetric = c.metricsMapVM[vmDistributedMemoryEntitlement]
 	if myMetric != nil {
-		myMetric.WithLabelValues(datacenterStr).Set(float64(oVM.Summary.QuickStats.DistributedMemoryEntitlement))
+		myMetric.WithLabelValues(datacenterStr).Set(c.normalize(float64(oVM.Summary.QuickStats.DistributedMemoryEntitlement), vmQuickStatsUnits[vmDistributedMemoryEntitlement]))
 	}
 	// myMetric = metricsMapVM[vmFtLatencyStatus]
 	// if myMetric != nil {
 	// 	myMetric.WithLabelValues(datacenterStr).Set(float64(oVM.Summary.QuickStats.FtLatencyStatus))
 	// }
-	myMetric = metricsMapVM[vmFtLogBandwidth]
+	myMetric = c.metricsMapVM[vmFtLogBandwidth]
 	if myMetric != nil {
-		myMetric.WithLabelValues(datacenterStr).Set(float64(oVM.Summary.QuickStats.FtLogBandwidth))
+		myMetric.WithLabelValues(datacenterStr).Set(c.normalize(float64(oVM.Summary.QuickStats.FtLogBandwidth), vmQuickStatsUnits[vmFtLogBandwidth]))
 	}
-	myMetric = metricsMapVM[vmFtSecondaryLatency]
+	myMetric = c.metricsMapVM[vmFtSecondaryLatency]
 	if myMetric != nil {
-		myMetric.WithLabelValues(datacenterStr).Set(float64(oVM.Summary.QuickStats.FtSecondaryLatency))
+		myMetric.WithLabelValues(datacenterStr).Set(c.normalize(float64(oVM.Summary.QuickStats.FtSecondaryLatency), vmQuickStatsUnits[vmFtSecondaryLatency]))
 	}
 	// myMetric = metricsMapVM[vmGuestHeartbeatStatus]
 	// if myMetric != nil {
 	// 	myMetric.WithLabelValues(datacenterStr).Set(float64(oVM.Summary.QuickStats.GuestHeartbeatStatus))
 	// }
-	myMetric = metricsMapVM[vmGuestMemoryUsage]
+	myMetric = c.metricsMapVM[vmGuestMemoryUsage]
 	if myMetric != nil {
-		myMetric.WithLabelValues(datacenterStr).Set(float64(oVM.Summary.QuickStats.GuestMemoryUsage))
+		myMetric.WithLabelValues(datacenterStr).Set(c.normalize(float64(oVM.Summary.QuickStats.GuestMemoryUsage), vmQuickStatsUnits[vmGuestMemoryUsage]))
 	}
-	myMetric = metricsMapVM[vmHostMemoryUsage]
+	myMetric = c.metricsMapVM[vmHostMemoryUsage]
 	if myMetric != nil {
-		myMetric.WithLabelValues(datacenterStr).Set(float64(oVM.Summary.QuickStats.HostMemoryUsage))
+		myMetric.WithLabelValues(datacenterStr).Set(c.normalize(float64(oVM.Summary.QuickStats.HostMemoryUsage), vmQuickStatsUnits[vmHostMemoryUsage]))
 	}
-	myMetric = metricsMapVM[vmOverallCpuDemand]
+	myMetric = c.metricsMapVM[vmOverallCpuDemand]
 	if myMetric != nil {
-		myMetric.WithLabelValues(datacenterStr).Set(float64(oVM.Summary.QuickStats.OverallCpuDemand))
+		myMetric.WithLabelValues(datacenterStr).Set(c.normalize(float64(oVM.Summary.QuickStats.OverallCpuDemand), vmQuickStatsUnits[vmOverallCpuDemand]))
 	}
-	myMetric = metricsMapVM[vmOverallCpuUsage]
+	myMetric = c.metricsMapVM[vmOverallCpuUsage]
 	if myMetric != nil {
-		myMetric.WithLabelValues(datacenterStr).Set(float64(oVM.Summary.QuickStats.OverallCpuUsage))
+		myMetric.WithLabelValues(datacenterStr).Set(c.normalize(float64(oVM.Summary.QuickStats.OverallCpuUsage), vmQuickStatsUnits[vmOverallCpuUsage]))
 	}
-	myMetric = metricsMapVM[vmPrivateMemory]
+	myMetric = c.metricsMapVM[vmPrivateMemory]
 	if myMetric != nil {
-		myMetric.WithLabelValues(datacenterStr).Set(float64(oVM.Summary.QuickStats.PrivateMemory))
+		myMetric.WithLabelValues(datacenterStr).Set(c.normalize(float64(oVM.Summary.QuickStats.PrivateMemory), vmQuickStatsUnits[vmPrivateMemory]))
 	}
-	myMetric = metricsMapVM[vmSharedMemory]
+	myMetric = c.metricsMapVM[vmSharedMemory]
 	if myMetric != nil {
-		myMetric.WithLabelValues(datacenterStr).Set(float64(oVM.Summary.QuickStats.SharedMemory))
+		myMetric.WithLabelValues(datacenterStr).Set(c.normalize(float64(oVM.Summary.QuickStats.SharedMemory), vmQuickStatsUnits[vmSharedMemory]))
 	}
-	myMetric = metricsMapVM[vmSsdSwappedMemory]
+	myMetric = c.metricsMapVM[vmSsdSwappedMemory]
 	if myMetric != nil {
-		myMetric.WithLabelValues(datacenterStr).Set(float64(oVM.Summary.QuickStats.SsdSwappedMemory))
+		myMetric.WithLabelValues(datacenterStr).Set(c.normalize(float64(oVM.Summary.QuickStats.SsdSwappedMemory), vmQuickStatsUnits[vmSsdSwappedMemory]))
 	}
-	myMetric = metricsMapVM[vmStaticCpuEntitlement]
+	myMetric = c.metricsMapVM[vmStaticCpuEntitlement]
 	if myMetric != nil {
-		myMetric.WithLabelValues(datacenterStr).Set(float64(oVM.Summary.QuickStats.StaticCpuEntitlement))
+		myMetric.WithLabelValues(datacenterStr).Set(c.normalize(float64(oVM.Summary.QuickStats.StaticCpuEntitlement), vmQuickStatsUnits[vmStaticCpuEntitlement]))
 	}
-	myMetric = metricsMapVM[vmStaticMemoryEntitlement]
+	myMetric = c.metricsMapVM[vmStaticMemoryEntitlement]
 	if myMetric != nil {
-		myMetric.WithLabelValues(datacenterStr).Set(float64(oVM.Summary.QuickStats.StaticMemoryEntitlement))
+		myMetric.WithLabelValues(datacenterStr).Set(c.normalize(float64(oVM.Summary.QuickStats.StaticMemoryEntitlement), vmQuickStatsUnits[vmStaticMemoryEntitlement]))
 	}
-	myMetric = metricsMapVM[vmSwappedMemory]
+	myMetric = c.metricsMapVM[vmSwappedMemory]
 	if myMetric != nil {
-		myMetric.WithLabelValues(datacenterStr).Set(float64(oVM.Summary.QuickStats.SwappedMemory))
+		myMetric.WithLabelValues(datacenterStr).Set(c.normalize(float64(oVM.Summary.QuickStats.SwappedMemory), vmQuickStatsUnits[vmSwappedMemory]))
 	}
-	myMetric = metricsMapVM[vmUptimeSeconds]
+	myMetric = c.metricsMapVM[vmUptimeSeconds]
 	if myMetric != nil {
-		myMetric.WithLabelValues(datacenterStr).Set(float64(oVM.Summary.QuickStats.UptimeSeconds))
+		myMetric.WithLabelValues(datacenterStr).Set(c.normalize(float64(oVM.Summary.QuickStats.UptimeSeconds), vmQuickStatsUnits[vmUptimeSeconds]))
+	}
+
+	err = c.setVMStorageMetrics(s, datacenterStr, &oVM)
+	if err != nil {
+		log.Errorln("setVMStorageMetrics(", vmStr, "):", err)
 	}
 
 	/*
-		myMetric := metricsMapVM[vmBalloonedMemory]
+		myMetric := c.metricsMapVM[vmBalloonedMemory]
 		if myMetric != nil {
 			prometheus.MustNewConstMetric(myMetric, prometheus.GaugeValue, float64(oVM.Summary.QuickStats.BalloonedMemory),
 				datacenterStr, vmStr)
 		}
-		myMetric = metricsMapVM[vmCompressedMemory]
+		myMetric = c.metricsMapVM[vmCompressedMemory]
 		if myMetric != nil {
 			prometheus.MustNewConstMetric(myMetric, prometheus.GaugeValue, float64(oVM.Summary.QuickStats.CompressedMemory),
 				datacenterStr, vmStr)
 		}
-		myMetric = metricsMapVM[vmConsumedOverheadMemory]
+		myMetric = c.metricsMapVM[vmConsumedOverheadMemory]
 		if myMetric != nil {
 			prometheus.MustNewConstMetric(myMetric, prometheus.GaugeValue, float64(oVM.Summary.QuickStats.ConsumedOverheadMemory),
 				datacenterStr, vmStr)
 		}
-		myMetric = metricsMapVM[vmDistributedCpuEntitlement]
+		myMetric = c.metricsMapVM[vmDistributedCpuEntitlement]
 		if myMetric != nil {
 			prometheus.MustNewConstMetric(myMetric, prometheus.GaugeValue, float64(oVM.Summary.QuickStats.DistributedCpuEntitlement),
 				datacenterStr, vmStr)
 		}
-		myMetric = metricsMapVM[vmDistributedMemoryEntitlement]
+		myMetric = c.metricsMapVM[vmDistributedMemoryEntitlement]
 		if myMetric != nil {
 			prometheus.MustNewConstMetric(myMetric, prometheus.GaugeValue, float64(oVM.Summary.QuickStats.DistributedMemoryEntitlement),
 				datacenterStr, vmStr)
@@ -642,12 +619,12 @@ func (c *Client) GetVSphereVMStats(w http.ResponseWriter, r *http.Request) error
 		//	prometheus.MustNewConstMetric(myMetric, prometheus.GaugeValue, float64(oVM.Summary.QuickStats.FtLatencyStatus),
 		//		datacenterStr, vmStr)
 		// }
-		myMetric = metricsMapVM[vmFtLogBandwidth]
+		myMetric = c.metricsMapVM[vmFtLogBandwidth]
 		if myMetric != nil {
 			prometheus.MustNewConstMetric(myMetric, prometheus.GaugeValue, float64(oVM.Summary.QuickStats.FtLogBandwidth),
 				datacenterStr, vmStr)
 		}
-		myMetric = metricsMapVM[vmFtSecondaryLatency]
+		myMetric = c.metricsMapVM[vmFtSecondaryLatency]
 		if myMetric != nil {
 			prometheus.MustNewConstMetric(myMetric, prometheus.GaugeValue, float64(oVM.Summary.QuickStats.FtSecondaryLatency),
 				datacenterStr, vmStr)
@@ -657,57 +634,57 @@ func (c *Client) GetVSphereVMStats(w http.ResponseWriter, r *http.Request) error
 		//	prometheus.MustNewConstMetric(myMetric, prometheus.GaugeValue, float64(oVM.Summary.QuickStats.GuestHeartbeatStatus),
 		//		datacenterStr, vmStr)
 		// }
-		myMetric = metricsMapVM[vmGuestMemoryUsage]
+		myMetric = c.metricsMapVM[vmGuestMemoryUsage]
 		if myMetric != nil {
 			prometheus.MustNewConstMetric(myMetric, prometheus.GaugeValue, float64(oVM.Summary.QuickStats.GuestMemoryUsage),
 				datacenterStr, vmStr)
 		}
-		myMetric = metricsMapVM[vmHostMemoryUsage]
+		myMetric = c.metricsMapVM[vmHostMemoryUsage]
 		if myMetric != nil {
 			prometheus.MustNewConstMetric(myMetric, prometheus.GaugeValue, float64(oVM.Summary.QuickStats.HostMemoryUsage),
 				datacenterStr, vmStr)
 		}
-		myMetric = metricsMapVM[vmOverallCpuDemand]
+		myMetric = c.metricsMapVM[vmOverallCpuDemand]
 		if myMetric != nil {
 			prometheus.MustNewConstMetric(myMetric, prometheus.GaugeValue, float64(oVM.Summary.QuickStats.OverallCpuDemand),
 				datacenterStr, vmStr)
 		}
-		myMetric = metricsMapVM[vmOverallCpuUsage]
+		myMetric = c.metricsMapVM[vmOverallCpuUsage]
 		if myMetric != nil {
 			prometheus.MustNewConstMetric(myMetric, prometheus.GaugeValue, float64(oVM.Summary.QuickStats.OverallCpuUsage),
 				datacenterStr, vmStr)
 		}
-		myMetric = metricsMapVM[vmPrivateMemory]
+		myMetric = c.metricsMapVM[vmPrivateMemory]
 		if myMetric != nil {
 			prometheus.MustNewConstMetric(myMetric, prometheus.GaugeValue, float64(oVM.Summary.QuickStats.PrivateMemory),
 				datacenterStr, vmStr)
 		}
-		myMetric = metricsMapVM[vmSharedMemory]
+		myMetric = c.metricsMapVM[vmSharedMemory]
 		if myMetric != nil {
 			prometheus.MustNewConstMetric(myMetric, prometheus.GaugeValue, float64(oVM.Summary.QuickStats.SharedMemory),
 				datacenterStr, vmStr)
 		}
-		myMetric = metricsMapVM[vmSsdSwappedMemory]
+		myMetric = c.metricsMapVM[vmSsdSwappedMemory]
 		if myMetric != nil {
 			prometheus.MustNewConstMetric(myMetric, prometheus.GaugeValue, float64(oVM.Summary.QuickStats.SsdSwappedMemory),
 				datacenterStr, vmStr)
 		}
-		myMetric = metricsMapVM[vmStaticCpuEntitlement]
+		myMetric = c.metricsMapVM[vmStaticCpuEntitlement]
 		if myMetric != nil {
 			prometheus.MustNewConstMetric(myMetric, prometheus.GaugeValue, float64(oVM.Summary.QuickStats.StaticCpuEntitlement),
 				datacenterStr, vmStr)
 		}
-		myMetric = metricsMapVM[vmStaticMemoryEntitlement]
+		myMetric = c.metricsMapVM[vmStaticMemoryEntitlement]
 		if myMetric != nil {
 			prometheus.MustNewConstMetric(myMetric, prometheus.GaugeValue, float64(oVM.Summary.QuickStats.StaticMemoryEntitlement),
 				datacenterStr, vmStr)
 		}
-		myMetric = metricsMapVM[vmSwappedMemory]
+		myMetric = c.metricsMapVM[vmSwappedMemory]
 		if myMetric != nil {
 			prometheus.MustNewConstMetric(myMetric, prometheus.GaugeValue, float64(oVM.Summary.QuickStats.SwappedMemory),
 				datacenterStr, vmStr)
 		}
-		myMetric = metricsMapVM[vmUptimeSeconds]
+		myMetric = c.metricsMapVM[vmUptimeSeconds]
 		if myMetric != nil {
 			prometheus.MustNewConstMetric(myMetric, prometheus.GaugeValue, float64(oVM.Summary.QuickStats.UptimeSeconds),
 				datacenterStr, vmStr)
@@ -0,0 +1,229 @@
+/*
+Copyright (c) 2018 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vsphere
+
+import (
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/vmware/govmomi/property"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+const (
+	vmStorageCommitted = (iota + 2304)
+	vmStorageUncommitted
+	vmStorageUnshared
+	vmStorageFileSize
+)
+
+//File categories used for the per file type breakdown of VM storage
+const (
+	vmFileDisk     = "disk"
+	vmFileSnapshot = "snapshot"
+	vmFileSwap     = "swap"
+	vmFileLog      = "log"
+	vmFileConfig   = "config"
+	vmFileOther    = "other"
+)
+
+var (
+	vmFileCategories = []string{vmFileDisk, vmFileSnapshot, vmFileSwap, vmFileLog, vmFileConfig, vmFileOther}
+)
+
+func (c *Client) registerVMStorageMetrics() error {
+	log.Debugln("registerVMStorageMetrics ENTER")
+
+	//committed
+	metricName := fmt.Sprintf("%d_storage_committed_size", vmStorageCommitted)
+	log.Debugln("Key:", metricName)
+
+	myMetric := prometheus.NewGaugeVec(
+		c.gaugeOpts("vm", metricName, "storage_committed_bytes", "Storage committed by the VM per datastore"),
+		[]string{"datacenter", "datastore"},
+	)
+	c.metricsMapVM[vmStorageCommitted] = myMetric
+	c.registry.MustRegister(myMetric)
+
+	//uncommitted
+	metricName = fmt.Sprintf("%d_storage_uncommitted_size", vmStorageUncommitted)
+	log.Debugln("Key:", metricName)
+
+	myMetric = prometheus.NewGaugeVec(
+		c.gaugeOpts("vm", metricName, "storage_uncommitted_bytes", "Additional storage the VM can still claim per datastore"),
+		[]string{"datacenter", "datastore"},
+	)
+	c.metricsMapVM[vmStorageUncommitted] = myMetric
+	c.registry.MustRegister(myMetric)
+
+	//unshared
+	metricName = fmt.Sprintf("%d_storage_unshared_size", vmStorageUnshared)
+	log.Debugln("Key:", metricName)
+
+	myMetric = prometheus.NewGaugeVec(
+		c.gaugeOpts("vm", metricName, "storage_unshared_bytes", "Storage used only by the VM per datastore"),
+		[]string{"datacenter", "datastore"},
+	)
+	c.metricsMapVM[vmStorageUnshared] = myMetric
+	c.registry.MustRegister(myMetric)
+
+	//file size by type
+	metricName = fmt.Sprintf("%d_storage_file_size", vmStorageFileSize)
+	log.Debugln("Key:", metricName)
+
+	myMetric = prometheus.NewGaugeVec(
+		c.gaugeOpts("vm", metricName, "storage_file_bytes", "Size of the VM files per file type"),
+		[]string{"datacenter", "type"},
+	)
+	c.metricsMapVM[vmStorageFileSize] = myMetric
+	c.registry.MustRegister(myMetric)
+
+	log.Debugln("registerVMStorageMetrics Succeeded")
+	log.Debugln("registerVMStorageMetrics LEAVE")
+
+	return nil
+}
+
+//setVMStorageMetrics expects oVM to have the storage and layoutEx properties populated
+func (c *Client) setVMStorageMetrics(s *session, datacenterStr string, oVM *mo.VirtualMachine) error {
+	log.Debugln("setVMStorageMetrics ENTER")
+
+	// Only ever report the datastores and files of the current VM
+	for _, key := range []int{vmStorageCommitted, vmStorageUncommitted, vmStorageUnshared, vmStorageFileSize} {
+		myMetric := c.metricsMapVM[key]
+		if myMetric != nil {
+			myMetric.Reset()
+		}
+	}
+
+	if oVM.Storage != nil && len(oVM.Storage.PerDatastoreUsage) > 0 {
+		var refs []types.ManagedObjectReference
+		for _, usage := range oVM.Storage.PerDatastoreUsage {
+			refs = append(refs, usage.Datastore)
+		}
+
+		var oDatastores []mo.Datastore
+		pc := property.DefaultCollector(s.client.Client)
+		err := pc.Retrieve(s.ctx, refs, []string{"name"}, &oDatastores)
+		if err != nil {
+			log.Errorln("Retrieve datastore names failed:", err)
+			log.Debugln("setVMStorageMetrics LEAVE")
+			return err
+		}
+
+		names := make(map[types.ManagedObjectReference]string)
+		for _, oDatastore := range oDatastores {
+			names[oDatastore.Self] = oDatastore.Name
+		}
+
+		for _, usage := range oVM.Storage.PerDatastoreUsage {
+			datastoreStr := names[usage.Datastore]
+			if datastoreStr == "" {
+				datastoreStr = usage.Datastore.Value
+			}
+
+			myMetric := c.metricsMapVM[vmStorageCommitted]
+			if myMetric != nil {
+				myMetric.WithLabelValues(datacenterStr, datastoreStr).Set(float64(usage.Committed))
+			}
+			myMetric = c.metricsMapVM[vmStorageUncommitted]
+			if myMetric != nil {
+				myMetric.WithLabelValues(datacenterStr, datastoreStr).Set(float64(usage.Uncommitted))
+			}
+			myMetric = c.metricsMapVM[vmStorageUnshared]
+			if myMetric != nil {
+				myMetric.WithLabelValues(datacenterStr, datastoreStr).Set(float64(usage.Unshared))
+			}
+		}
+	}
+
+	if oVM.LayoutEx != nil {
+		sizes := vmFileSizeByCategory(oVM.LayoutEx)
+
+		myMetric := c.metricsMapVM[vmStorageFileSize]
+		if myMetric != nil {
+			for _, category := range vmFileCategories {
+				myMetric.WithLabelValues(datacenterStr, category).Set(float64(sizes[category]))
+			}
+		}
+	}
+
+	log.Debugln("setVMStorageMetrics Succeeded")
+	log.Debugln("setVMStorageMetrics LEAVE")
+
+	return nil
+}
+
+//vmFileSizeByCategory sums the VM's files into disk, snapshot, swap, log, config
+//and other. Delta disks that sit on top of a base disk in a chain only exist
+//because of a snapshot so they are accounted as snapshot rather than disk.
+func vmFileSizeByCategory(layout *types.VirtualMachineFileLayoutEx) map[string]int64 {
+	deltas := make(map[int32]bool)
+	for _, disk := range layout.Disk {
+		for i, unit := range disk.Chain {
+			if i == 0 {
+				continue
+			}
+			for _, key := range unit.FileKey {
+				deltas[key] = true
+			}
+		}
+	}
+
+	sizes := make(map[string]int64)
+	for _, file := range layout.File {
+		category := vmFileCategory(types.VirtualMachineFileLayoutExFileType(file.Type))
+		if category == vmFileDisk && deltas[file.Key] {
+			category = vmFileSnapshot
+		}
+		sizes[category] += file.Size
+	}
+
+	return sizes
+}
+
+func vmFileCategory(fileType types.VirtualMachineFileLayoutExFileType) string {
+	switch fileType {
+	case types.VirtualMachineFileLayoutExFileTypeDiskDescriptor,
+		types.VirtualMachineFileLayoutExFileTypeDiskExtent,
+		types.VirtualMachineFileLayoutExFileTypeDigestDescriptor,
+		types.VirtualMachineFileLayoutExFileTypeDigestExtent:
+		return vmFileDisk
+	case types.VirtualMachineFileLayoutExFileTypeSnapshotData,
+		types.VirtualMachineFileLayoutExFileTypeSnapshotMemory,
+		types.VirtualMachineFileLayoutExFileTypeSnapshotList,
+		types.VirtualMachineFileLayoutExFileTypeSnapshotManifestList:
+		return vmFileSnapshot
+	case types.VirtualMachineFileLayoutExFileTypeSwap,
+		types.VirtualMachineFileLayoutExFileTypeUwswap:
+		return vmFileSwap
+	case types.VirtualMachineFileLayoutExFileTypeLog,
+		types.VirtualMachineFileLayoutExFileTypeCore,
+		types.VirtualMachineFileLayoutExFileTypeStat:
+		return vmFileLog
+	case types.VirtualMachineFileLayoutExFileTypeConfig,
+		types.VirtualMachineFileLayoutExFileTypeExtendedConfig,
+		types.VirtualMachineFileLayoutExFileTypeNvram:
+		return vmFileConfig
+	}
+
+	return vmFileOther
+}
@@ -0,0 +1,125 @@
+/*
+Copyright (c) 2018 VMware, Inc. All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package vsphere
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	assert "github.com/stretchr/testify/assert"
+
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/soap"
+	"github.com/vmware/govmomi/vim25/types"
+
+	config "github.com/dvonthenen/vsphere-metrics-prometheus/config"
+)
+
+func TestVMFileSizeByCategory(t *testing.T) {
+	layout := &types.VirtualMachineFileLayoutEx{
+		File: []types.VirtualMachineFileLayoutExFileInfo{
+			{Key: 0, Type: "config", Size: 4},
+			{Key: 1, Type: "nvram", Size: 8},
+			{Key: 2, Type: "diskDescriptor", Size: 1},
+			{Key: 3, Type: "diskExtent", Size: 1000},
+			{Key: 4, Type: "diskDescriptor", Size: 1},
+			{Key: 5, Type: "diskExtent", Size: 300},
+			{Key: 6, Type: "snapshotData", Size: 20},
+			{Key: 7, Type: "swap", Size: 512},
+			{Key: 8, Type: "log", Size: 64},
+			{Key: 9, Type: "screenshot", Size: 2},
+		},
+		Disk: []types.VirtualMachineFileLayoutExDiskLayout{
+			{
+				Key: 2000,
+				Chain: []types.VirtualMachineFileLayoutExDiskUnit{
+					{FileKey: []int32{2, 3}},
+					{FileKey: []int32{4, 5}},
+				},
+			},
+		},
+	}
+
+	sizes := vmFileSizeByCategory(layout)
+
+	assert.Equal(t, int64(1001), sizes[vmFileDisk])
+	assert.Equal(t, int64(321), sizes[vmFileSnapshot])
+	assert.Equal(t, int64(512), sizes[vmFileSwap])
+	assert.Equal(t, int64(64), sizes[vmFileLog])
+	assert.Equal(t, int64(12), sizes[vmFileConfig])
+	assert.Equal(t, int64(2), sizes[vmFileOther])
+}
+
+func TestSetVMStorageMetrics(t *testing.T) {
+	c := NewClient(&config.Config{})
+	for _, key := range []int{vmStorageCommitted, vmStorageUncommitted, vmStorageUnshared} {
+		c.metricsMapVM[key] = prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "test"}, []string{"datacenter", "datastore"})
+	}
+	c.metricsMapVM[vmStorageFileSize] = prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "test"}, []string{"datacenter", "type"})
+
+	s := newFakeSession(&fakeVCenter{
+		properties: func(ctx context.Context, obj types.ManagedObjectReference) []types.DynamicProperty {
+			return []types.DynamicProperty{{Name: "name", Val: "ds-" + obj.Value}}
+		},
+		call: func(ctx context.Context, req, res soap.HasFault) error {
+			return errors.New("unexpected call")
+		},
+	})
+
+	datastore := func(value string) types.ManagedObjectReference {
+		return types.ManagedObjectReference{Type: "Datastore", Value: value}
+	}
+
+	vm1 := &mo.VirtualMachine{
+		Storage: &types.VirtualMachineStorageInfo{
+			PerDatastoreUsage: []types.VirtualMachineUsageOnDatastore{
+				{Datastore: datastore("1"), Committed: 100, Uncommitted: 10, Unshared: 50},
+				{Datastore: datastore("2"), Committed: 200, Uncommitted: 20, Unshared: 150},
+			},
+		},
+		LayoutEx: &types.VirtualMachineFileLayoutEx{
+			File: []types.VirtualMachineFileLayoutExFileInfo{{Key: 0, Type: "swap", Size: 512}},
+		},
+	}
+	assert.NoError(t, c.setVMStorageMetrics(s, "dc1", vm1))
+	assert.Equal(t, 2, seriesCount(c.metricsMapVM[vmStorageCommitted]))
+	assert.Equal(t, 200.0, vecValue(t, c.metricsMapVM[vmStorageCommitted], "dc1", "ds-2"))
+	assert.Equal(t, 512.0, vecValue(t, c.metricsMapVM[vmStorageFileSize], "dc1", vmFileSwap))
+
+	// A VM on one datastore without a file layout leaves nothing of the first
+	vm2 := &mo.VirtualMachine{
+		Storage: &types.VirtualMachineStorageInfo{
+			PerDatastoreUsage: []types.VirtualMachineUsageOnDatastore{
+				{Datastore: datastore("3"), Committed: 300, Uncommitted: 30, Unshared: 300},
+			},
+		},
+	}
+	assert.NoError(t, c.setVMStorageMetrics(s, "dc1", vm2))
+	for _, key := range []int{vmStorageCommitted, vmStorageUncommitted, vmStorageUnshared} {
+		assert.Equal(t, 1, seriesCount(c.metricsMapVM[key]))
+	}
+	assert.Equal(t, 300.0, vecValue(t, c.metricsMapVM[vmStorageUnshared], "dc1", "ds-3"))
+	assert.Equal(t, 0, seriesCount(c.metricsMapVM[vmStorageFileSize]))
+}
+
+func vecValue(t *testing.T, vec *prometheus.GaugeVec, labels ...string) float64 {
+	var metric dto.Metric
+	assert.NoError(t, vec.WithLabelValues(labels...).Write(&metric))
+	return metric.GetGauge().GetValue()
+}